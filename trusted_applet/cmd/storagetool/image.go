@@ -0,0 +1,73 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/file"
+)
+
+// image adapts an image file, which may hold only part of the eMMC, to the
+// block addresses used on the device.
+type image struct {
+	dev *file.Device
+	// start is the address on the eMMC of the first block in the image.
+	start uint
+	// overlay, if not nil, holds the blocks written via the image, leaving
+	// the image file itself untouched.
+	overlay map[uint][]byte
+}
+
+func (i *image) BlockSize() uint {
+	return i.dev.BlockSize()
+}
+
+func (i *image) ReadBlocks(lba uint, b []byte) error {
+	if lba < i.start {
+		return fmt.Errorf("block %d precedes the first block %d in the image", lba, i.start)
+	}
+	if err := i.dev.ReadBlocks(lba-i.start, b); err != nil {
+		return err
+	}
+	bs := i.dev.BlockSize()
+	for n := uint(0); n < uint(len(b))/bs; n++ {
+		if o, ok := i.overlay[lba+n]; ok {
+			copy(b[n*bs:], o)
+		}
+	}
+	return nil
+}
+
+func (i *image) WriteBlocks(lba uint, b []byte) (uint, error) {
+	if lba < i.start {
+		return 0, fmt.Errorf("block %d precedes the first block %d in the image", lba, i.start)
+	}
+	if i.overlay == nil {
+		return i.dev.WriteBlocks(lba-i.start, b)
+	}
+	bs := i.dev.BlockSize()
+	if r := uint(len(b)) % bs; r != 0 {
+		b = append(b, make([]byte, bs-r)...)
+	}
+	n := uint(len(b)) / bs
+	if end := lba - i.start + n; end > i.dev.NumBlocks() {
+		return 0, fmt.Errorf("blocks [%d, %d) are outside the image", lba, lba+n)
+	}
+	for j := range n {
+		i.overlay[lba+j] = append([]byte(nil), b[j*bs:(j+1)*bs]...)
+	}
+	return n, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// storagetool examines, and optionally repairs, the witness storage in an
+// image dumped from an ArmoredWitness eMMC.
+//
+// Usage:
+//
+//	storagetool --image=<file> logs
+//	storagetool --image=<file> checkpoint <slot>
+//	storagetool --image=<file> journal <slot>
+//	storagetool --image=<file> [--write] repair <slot>
+//	storagetool --image=<file> [--write] reconstruct
+//
+// The image is never modified unless --write is given.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/file"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/layout"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/remap"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"k8s.io/klog/v2"
+)
+
+var (
+	imagePath       = flag.String("image", "", "Path to the eMMC image file.")
+	blockSize       = flag.Uint("block_size", 512, "Block size of the eMMC, in bytes.")
+	imageStartBlock = flag.Uint("image_start_block", 0, "Address on the eMMC of the first block in the image, if only part of the eMMC was dumped.")
+	write           = flag.Bool("write", false, "Allow repair to modify the image, rather than only reporting its result.")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] logs | reconstruct | checkpoint <slot> | journal <slot> | repair <slot>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	ctx := context.Background()
+
+	if *imagePath == "" {
+		klog.Exit("--image must be set")
+	}
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, args := args[0], args[1:]
+	if noArgs := cmd == "logs" || cmd == "reconstruct"; noArgs != (len(args) == 0) || len(args) > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var slot uint
+	if len(args) == 1 {
+		s, err := strconv.ParseUint(args[0], 10, 0)
+		if err != nil {
+			klog.Exitf("Invalid slot %q: %v", args[0], err)
+		}
+		slot = uint(s)
+	}
+
+	part, img := openImage((cmd == "repair" || cmd == "reconstruct") && *write)
+	defer img.dev.Close()
+
+	switch cmd {
+	case "logs", "reconstruct":
+		if cmd == "reconstruct" {
+			n, err := storage.NewSlotPersistence(part).ReconstructDirectory(ctx)
+			if err != nil {
+				klog.Exitf("Failed to reconstruct directory: %v", err)
+			}
+			fmt.Printf("Reconstructed directory holding %d logs\n", n)
+			if !*write {
+				fmt.Println("Image not modified, use --write to store the reconstructed directory.")
+			}
+		}
+		c, err := storage.Inspect(ctx, part)
+		if err != nil {
+			klog.Exitf("Failed to inspect storage: %v", err)
+		}
+		fmt.Printf("Directory: format version %d, generation %d, revision %d\n", c.DirectoryVersion, c.DirectoryGeneration, c.DirectoryRevision)
+		for _, l := range c.Logs {
+			fmt.Printf("Slot %d: revision %d, log %s", l.Slot, l.Revision, l.ID)
+			if l.Origin != "" {
+				fmt.Printf(", origin %q", l.Origin)
+			}
+			if !l.Retired.IsZero() {
+				fmt.Printf(", retired %v", l.Retired)
+			}
+			if l.Error != "" {
+				fmt.Printf(", ERROR: %s", l.Error)
+			}
+			fmt.Println()
+		}
+	case "checkpoint":
+		c, err := storage.Inspect(ctx, part)
+		if err != nil {
+			klog.Exitf("Failed to inspect storage: %v", err)
+		}
+		for _, l := range c.Logs {
+			if l.Slot != slot {
+				continue
+			}
+			if l.Error != "" {
+				klog.Exitf("Failed to read checkpoint for log %s: %s", l.ID, l.Error)
+			}
+			fmt.Printf("Log %s, revision %d:\n%s", l.ID, l.Revision, l.Checkpoint)
+			return
+		}
+		klog.Exitf("No log is stored in slot %d", slot)
+	case "journal":
+		printJournal(ctx, part, slot)
+	case "repair":
+		if err := part.Repair(ctx, slot); err != nil {
+			klog.Exitf("Failed to repair slot %d: %v", slot, err)
+		}
+		printJournal(ctx, part, slot)
+		if !*write {
+			fmt.Println("Image not modified, use --write to apply the repair.")
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// openImage opens the partition stored in the image.
+// Unless writable is true, any writes are kept in memory.
+func openImage(writable bool) (*slots.Partition, *image) {
+	img := &image{start: *imageStartBlock}
+	var err error
+	if writable {
+		img.dev, err = file.Create(*imagePath, *blockSize, 0)
+	} else {
+		img.dev, err = file.Open(*imagePath, *blockSize)
+		img.overlay = make(map[uint][]byte)
+	}
+	if err != nil {
+		klog.Exitf("Failed to open image: %v", err)
+	}
+	var dev slots.BlockReaderWriter = img
+	if rg := layout.Remap(); rg.Start >= img.start {
+		if dev, err = remap.New(context.Background(), img, rg); err != nil {
+			klog.Exitf("Failed to read remap table: %v", err)
+		}
+	} else {
+		klog.Warningf("Image doesn't include the remap table at block %d, so any remapped blocks will be read from their original location", rg.Start)
+	}
+	// Opening the partition may initialise or upgrade its table, which must
+	// not be done to a dump of a device's storage unless asked to.
+	part, err := slots.OpenPartition(dev, layout.Geometry(*blockSize))
+	if err != nil {
+		klog.Exitf("Failed to open partition: %v", err)
+	}
+	return part, img
+}
+
+// printJournal prints every entry found in the given slot's journal.
+func printJournal(ctx context.Context, part *slots.Partition, slot uint) {
+	start, length, err := part.SlotExtent(slot)
+	if err != nil {
+		klog.Exit(err)
+	}
+	entries, err := part.Inspect(ctx, slot)
+	if err != nil {
+		klog.Exitf("Failed to inspect slot %d: %v", slot, err)
+	}
+	fmt.Printf("Slot %d: blocks [%d, %d)\n", slot, start, start+length)
+	for _, e := range entries {
+		if e.Error != "" {
+			fmt.Printf("  block %d: revision %d, BAD: %s", e.LBA, e.Revision, e.Error)
+			if e.Torn {
+				fmt.Print(" (torn write)")
+			}
+			fmt.Println()
+			continue
+		}
+		fmt.Printf("  block %d: revision %d, %d blocks, %d bytes", e.LBA, e.Revision, e.Blocks, len(e.Data))
+		if e.Head {
+			fmt.Print(" (current)")
+		}
+		fmt.Println()
+	}
+}
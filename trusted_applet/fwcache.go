@@ -0,0 +1,178 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage"
+	"github.com/transparency-dev/armored-witness-common/release/firmware"
+	"github.com/transparency-dev/armored-witness-common/release/firmware/ftlog"
+	"github.com/transparency-dev/armored-witness-common/release/firmware/update"
+	"k8s.io/klog/v2"
+)
+
+// cachingRemote is an update.Remote which keeps verified firmware bundles in
+// the on-device firmware cache, so that retrying a failed install doesn't
+// depend on the binaries server being reachable.
+//
+// Bundles are cached under the SHA256 hash of their manifest, and described
+// by their component and version, which is how they're found again.
+type cachingRemote struct {
+	*update.Fetcher
+	verifier fwVerifier
+	cache    *storage.SlotPersistence
+}
+
+// GetOS returns the latest OS bundle, from the cache if possible.
+func (c cachingRemote) GetOS(ctx context.Context) (firmware.Bundle, error) {
+	osVer, _, err := c.GetLatestVersions(ctx)
+	if err != nil {
+		return firmware.Bundle{}, err
+	}
+	return c.get(ctx, ftlog.ComponentOS, osVer, c.Fetcher.GetOS)
+}
+
+// GetApplet returns the latest applet bundle, from the cache if possible.
+func (c cachingRemote) GetApplet(ctx context.Context) (firmware.Bundle, error) {
+	_, appVer, err := c.GetLatestVersions(ctx)
+	if err != nil {
+		return firmware.Bundle{}, err
+	}
+	return c.get(ctx, ftlog.ComponentApplet, appVer, c.Fetcher.GetApplet)
+}
+
+// get returns the cached bundle for the given release, or fetches, verifies,
+// and caches it if there isn't one.
+func (c cachingRemote) get(ctx context.Context, component string, v semver.Version, fetch func(context.Context) (firmware.Bundle, error)) (firmware.Bundle, error) {
+	desc := cacheDescription(component, v)
+	if b, err := c.load(ctx, desc); err == nil {
+		klog.Infof("Using cached %s", desc)
+		return b, nil
+	} else if !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrNoFirmwareCache) {
+		klog.Warningf("Failed to load %s from firmware cache: %v", desc, err)
+	}
+
+	b, err := fetch(ctx)
+	if err != nil {
+		return b, err
+	}
+	if err := c.verifier.Verify(b); err != nil {
+		return b, fmt.Errorf("verification of %s failed: %v", desc, err)
+	}
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return b, fmt.Errorf("failed to marshal bundle: %v", err)
+	}
+	if err := c.cache.CacheFirmware(ctx, sha256.Sum256(b.Manifest), desc, raw); err != nil && !errors.Is(err, storage.ErrNoFirmwareCache) {
+		// The bundle can still be installed, it just won't survive a
+		// failed install.
+		klog.Warningf("Failed to cache %s: %v", desc, err)
+	}
+	return b, nil
+}
+
+// load returns the cached bundle with the given description, which is
+// verified before being returned. Any unusable entry is evicted.
+func (c cachingRemote) load(ctx context.Context, desc string) (firmware.Bundle, error) {
+	entries, err := c.cache.FirmwareCache(ctx)
+	if err != nil {
+		return firmware.Bundle{}, err
+	}
+	for _, e := range entries {
+		if e.Description != desc {
+			continue
+		}
+		b, err := c.loadEntry(ctx, e)
+		if err != nil {
+			if eErr := c.cache.EvictFirmware(ctx, e.Key); eErr != nil {
+				klog.Warningf("Failed to evict %s from firmware cache: %v", desc, eErr)
+			}
+		}
+		return b, err
+	}
+	return firmware.Bundle{}, storage.ErrNotFound
+}
+
+// loadEntry reads and verifies a cached bundle.
+func (c cachingRemote) loadEntry(ctx context.Context, e storage.CachedFirmware) (firmware.Bundle, error) {
+	var b firmware.Bundle
+	raw, err := c.cache.LoadFirmware(ctx, e.Key)
+	if err != nil {
+		return b, err
+	}
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return b, fmt.Errorf("failed to unmarshal cached bundle: %v", err)
+	}
+	if sha256.Sum256(b.Manifest) != e.Key {
+		return b, errors.New("cached bundle's manifest doesn't match its key")
+	}
+	if err := c.verifier.Verify(b); err != nil {
+		return b, fmt.Errorf("cached bundle failed verification: %v", err)
+	}
+	return b, nil
+}
+
+// evictInstalled removes any bundles from the firmware cache whose version
+// has been installed. Installing a bundle reboots the device, so this is how
+// successfully installed bundles get evicted.
+func (c cachingRemote) evictInstalled(ctx context.Context, osVer, appVer semver.Version) {
+	entries, err := c.cache.FirmwareCache(ctx)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNoFirmwareCache) {
+			klog.Warningf("Failed to read firmware cache: %v", err)
+		}
+		return
+	}
+	installed := map[string]semver.Version{
+		ftlog.ComponentOS:     osVer,
+		ftlog.ComponentApplet: appVer,
+	}
+	for _, e := range entries {
+		component, v, err := parseCacheDescription(e.Description)
+		if err != nil {
+			klog.Warningf("Evicting unrecognised entry %q from firmware cache: %v", e.Description, err)
+		} else if iv, ok := installed[component]; !ok || iv.LessThan(v) {
+			continue
+		}
+		if err := c.cache.EvictFirmware(ctx, e.Key); err != nil {
+			klog.Warningf("Failed to evict %q from firmware cache: %v", e.Description, err)
+		}
+	}
+}
+
+// cacheDescription returns the description of a cached bundle.
+func cacheDescription(component string, v semver.Version) string {
+	return fmt.Sprintf("%s %s", component, v)
+}
+
+// parseCacheDescription parses a description returned by cacheDescription.
+func parseCacheDescription(desc string) (string, semver.Version, error) {
+	component, v, ok := strings.Cut(desc, " ")
+	if !ok {
+		return "", semver.Version{}, errors.New("no version")
+	}
+	sv, err := semver.NewVersion(v)
+	if err != nil {
+		return "", semver.Version{}, err
+	}
+	return component, *sv, nil
+}
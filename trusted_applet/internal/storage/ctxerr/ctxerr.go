@@ -0,0 +1,40 @@
+// Copyright 2022 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ctxerr defines the errors reported by storage operations which are
+// abandoned because their context is done.
+//
+// It's kept separate from the slots package so that block device
+// implementations can report these errors without depending on it.
+package ctxerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTimeout is returned, wrapping context.DeadlineExceeded, when a storage
+// operation is abandoned because it didn't complete before its deadline.
+var ErrTimeout = errors.New("storage operation timed out")
+
+// FromContext returns the error which should be reported for an operation
+// which is abandoned because ctx is done, or nil if ctx is not done.
+func FromContext(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
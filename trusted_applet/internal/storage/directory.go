@@ -0,0 +1,130 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// directoryMagic is a prefix which denotes that the bytes following it are a
+	// versioned directory, as opposed to the legacy bare YAML slotMap.
+	// As with rawRecordMagic, the "control" character protects against
+	// misinterpretation as YAML.
+	directoryMagic = []byte("\x01DIR")
+
+	// errFutureDirectory is returned by unmarshalDirectory if the directory
+	// was stored by a later build using a newer format version.
+	errFutureDirectory = errors.New("directory is from the future")
+)
+
+// directory is the in-memory representation of the data stored in the
+// directory slot.
+type directory struct {
+	// Version is the on-disk format version of the directory.
+	//
+	// Version 0 is the legacy format, with no header.
+	// Version 1 adds a header containing directoryMagic followed by the
+	// version as a big-endian uint32. The remainder is a YAML slotMap.
+	// Version 2 has the same header, followed by a YAML directoryBody.
+	// Version 3 adds the generation to directoryBody, is mirrored to several
+	// slots, and reserves slots for transaction intent records and the
+	// firmware cache.
+	Version uint32
+	// Generation is incremented every time the directory is stored, and is
+	// used to pick the latest of its copies.
+	Generation uint64
+	// Slots maps log IDs to the index of the slot which stores their state.
+	Slots slotMap
+	// Retired maps the IDs of logs which are no longer being witnessed to the
+	// time at which they were retired.
+	Retired map[string]time.Time
+	// Releases maps the names of recently found firmware releases to the time
+	// at which they were first found.
+	Releases map[string]time.Time
+}
+
+// directoryBody is the YAML encoded structure stored after the header in
+// directory format versions 2 and later.
+type directoryBody struct {
+	Generation uint64               `yaml:"generation,omitempty"`
+	Slots      slotMap              `yaml:"slots"`
+	Retired    map[string]time.Time `yaml:"retired,omitempty"`
+	Releases   map[string]time.Time `yaml:"releases,omitempty"`
+}
+
+// marshalDirectory serialises a directory using the current format version,
+// regardless of the value of d.Version.
+func marshalDirectory(d directory) ([]byte, error) {
+	body, err := yaml.Marshal(directoryBody{Generation: d.Generation, Slots: d.Slots, Retired: d.Retired, Releases: d.Releases})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal mapping: %v", err)
+	}
+	b := bytes.NewBuffer(make([]byte, 0, len(directoryMagic)+4+len(body)))
+	b.Write(directoryMagic)
+	if err := binary.Write(b, binary.BigEndian, uint32(directoryFormatVersion)); err != nil {
+		return nil, fmt.Errorf("failed to write version: %v", err)
+	}
+	b.Write(body)
+	return b.Bytes(), nil
+}
+
+// unmarshalDirectory deserialises a directory stored in any known format
+// version.
+// An error is returned if the directory was stored using an unknown, likely
+// newer, format version.
+func unmarshalDirectory(b []byte) (directory, error) {
+	d := directory{Slots: make(slotMap), Retired: make(map[string]time.Time), Releases: make(map[string]time.Time)}
+	body, ok := bytes.CutPrefix(b, directoryMagic)
+	if ok {
+		if len(body) < 4 {
+			return d, fmt.Errorf("directory header too short (%d bytes)", len(b))
+		}
+		d.Version = binary.BigEndian.Uint32(body)
+		body = body[4:]
+		if d.Version > directoryFormatVersion {
+			return d, fmt.Errorf("%w: unknown directory format version %d, this build supports up to version %d", errFutureDirectory, d.Version, directoryFormatVersion)
+		}
+	}
+	if d.Version < 2 {
+		// Versions 0 and 1 share the same YAML encoding of the mapping.
+		if err := yaml.Unmarshal(body, &d.Slots); err != nil {
+			return d, fmt.Errorf("failed to unmarshal mapping: %v", err)
+		}
+		return d, nil
+	}
+	db := directoryBody{Slots: d.Slots, Retired: d.Retired, Releases: d.Releases}
+	if err := yaml.Unmarshal(body, &db); err != nil {
+		return d, fmt.Errorf("failed to unmarshal directory: %v", err)
+	}
+	// Unmarshalling an empty or null field will have replaced our empty maps.
+	if db.Slots != nil {
+		d.Slots = db.Slots
+	}
+	if db.Retired != nil {
+		d.Retired = db.Retired
+	}
+	if db.Releases != nil {
+		d.Releases = db.Releases
+	}
+	d.Generation = db.Generation
+	return d, nil
+}
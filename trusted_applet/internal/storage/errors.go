@@ -0,0 +1,62 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The errors below can be matched using errors.Is against any error returned
+// by SlotPersistence.
+var (
+	// ErrConflict is returned when a log's state was updated concurrently by
+	// another caller. Retrying the operation may succeed.
+	ErrConflict = slots.ErrConflict
+
+	// ErrCorrupt is returned when stored state can't be decoded, or fails
+	// its integrity checks. A slots.CorruptError can be extracted with
+	// errors.As to find which slot holds the corrupt state.
+	ErrCorrupt = slots.ErrCorrupt
+
+	// ErrPartitionFull is returned when a new log can't be added because
+	// every slot is already in use.
+	ErrPartitionFull = errors.New("no free slot available")
+
+	// ErrNotFound is returned by Latest and History when there's no state
+	// stored for a log. It carries the codes.NotFound gRPC status, which the
+	// omniwitness Persistence interface expects in that case.
+	ErrNotFound error = notFoundError{}
+)
+
+// notFoundError is the type of ErrNotFound.
+type notFoundError struct{}
+
+func (notFoundError) Error() string {
+	return "not found"
+}
+
+func (notFoundError) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, "not found")
+}
+
+// corrupt returns an error reporting that the state stored in the given slot
+// is corrupt.
+func corrupt(slot uint, err error) error {
+	return &slots.CorruptError{Slot: int(slot), Err: err}
+}
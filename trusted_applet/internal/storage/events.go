@@ -0,0 +1,97 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EventReset is the type of event recorded when the storage is reset.
+	EventReset = "reset"
+
+	// maxEvents is the number of most recent events retained in the event slot.
+	maxEvents = 64
+)
+
+// Event describes a significant change made to the witness storage.
+type Event struct {
+	Time   time.Time
+	Type   string
+	Detail string
+}
+
+// Events returns the history of events recorded in the event slot, oldest first.
+func (p *SlotPersistence) Events(_ context.Context) ([]Event, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.readEvents()
+}
+
+// eventSlot returns the index of the slot reserved for recording events.
+// This is the final slot in the partition, since slots are assigned to logs
+// starting from the beginning.
+// If slots are appended to the partition, previously recorded events are left
+// behind in the old final slot, and are cleared when it's assigned to a log.
+func (p *SlotPersistence) eventSlot() uint {
+	return uint(p.part.NumSlots() - 1)
+}
+
+// readEvents reads the list of events stored in the event slot.
+// Must be called with p.mu at least read-locked.
+func (p *SlotPersistence) readEvents() ([]Event, error) {
+	s, err := p.part.Open(p.eventSlot())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event slot: %v", err)
+	}
+	b, _, err := s.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event slot: %v", err)
+	}
+	var events []Event
+	if err := yaml.Unmarshal(b, &events); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal events: %v", err)
+	}
+	return events, nil
+}
+
+// recordEvent stores the list of prior events along with e in the event slot,
+// discarding the oldest events if necessary.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) recordEvent(prior []Event, e Event) error {
+	events := append(prior, e)
+	if l := len(events); l > maxEvents {
+		events = events[l-maxEvents:]
+	}
+	b, err := yaml.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal events: %v", err)
+	}
+	s, err := p.part.Open(p.eventSlot())
+	if err != nil {
+		return fmt.Errorf("failed to open event slot: %v", err)
+	}
+	if err := s.Write(b); err != nil {
+		return fmt.Errorf("failed to write event slot: %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		return fmt.Errorf("failed to sync event slot: %v", err)
+	}
+	return nil
+}
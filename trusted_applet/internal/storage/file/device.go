@@ -0,0 +1,179 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file provides a block device backed by an image file, so that the
+// storage code can be run on a regular host, e.g. for development, or to
+// examine a dump of a device's eMMC.
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/ctxerr"
+)
+
+// Device is a block device whose blocks are stored in an image file.
+//
+// Writes are flushed to stable storage with fsync before they're reported as
+// complete, mirroring the durability of writes to the MMC.
+type Device struct {
+	f         *os.File
+	blockSize uint
+	numBlocks uint
+	readOnly  bool
+}
+
+// Create opens the image file at path for reading and writing, creating it if
+// necessary, and ensures that it holds at least numBlocks blocks.
+//
+// The image is extended by truncating it, so on most filesystems space is
+// only allocated for blocks which have been written to, and a large image can
+// be created cheaply.
+func Create(path string, blockSize, numBlocks uint) (*Device, error) {
+	if blockSize == 0 {
+		return nil, errors.New("block size must be non-zero")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if size := int64(blockSize * numBlocks); fi.Size() < size {
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to extend image to %d bytes: %v", size, err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else {
+		numBlocks = uint(fi.Size()) / blockSize
+	}
+	return &Device{f: f, blockSize: blockSize, numBlocks: numBlocks}, nil
+}
+
+// Open opens an existing image file read-only, e.g. a dump of an eMMC.
+// Any partial block at the end of the file is ignored.
+func Open(path string, blockSize uint) (*Device, error) {
+	if blockSize == 0 {
+		return nil, errors.New("block size must be non-zero")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Device{f: f, blockSize: blockSize, numBlocks: uint(fi.Size()) / blockSize, readOnly: true}, nil
+}
+
+// Close closes the image file.
+func (d *Device) Close() error {
+	return d.f.Close()
+}
+
+// BlockSize returns the size in bytes of each block in the image.
+func (d *Device) BlockSize() uint {
+	return d.blockSize
+}
+
+// NumBlocks returns the number of blocks in the image.
+func (d *Device) NumBlocks() uint {
+	return d.numBlocks
+}
+
+// ReadBlocks reads len(b) bytes into b from contiguous blocks starting at the
+// given block address.
+// b must be an integer multiple of the device's block size.
+func (d *Device) ReadBlocks(lba uint, b []byte) error {
+	return d.ReadBlocksContext(context.Background(), lba, b)
+}
+
+// ReadBlocksContext behaves like ReadBlocks, but fails if ctx is done before
+// the read is started.
+func (d *Device) ReadBlocksContext(ctx context.Context, lba uint, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := ctxerr.FromContext(ctx); err != nil {
+		return err
+	}
+	if err := d.checkRange(lba, uint(len(b))); err != nil {
+		return err
+	}
+	if _, err := d.f.ReadAt(b, int64(lba*d.blockSize)); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read %d bytes at block %d: %v", len(b), lba, err)
+	}
+	return nil
+}
+
+// WriteBlocks writes the data in b to the blocks starting at the given block
+// address, and waits for it to reach stable storage.
+// If the final block to be written is partial, it will be padded with zeroes
+// to ensure that full blocks are written.
+// Returns the number of blocks written, or an error.
+func (d *Device) WriteBlocks(lba uint, b []byte) (uint, error) {
+	return d.WriteBlocksContext(context.Background(), lba, b)
+}
+
+// WriteBlocksContext behaves like WriteBlocks, but fails if ctx is done before
+// the write is started.
+func (d *Device) WriteBlocksContext(ctx context.Context, lba uint, b []byte) (uint, error) {
+	if d.readOnly {
+		return 0, errors.New("image is read-only")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := ctxerr.FromContext(ctx); err != nil {
+		return 0, err
+	}
+	if r := uint(len(b)) % d.blockSize; r != 0 {
+		b = append(b, make([]byte, d.blockSize-r)...)
+	}
+	if err := d.checkRange(lba, uint(len(b))); err != nil {
+		return 0, err
+	}
+	if _, err := d.f.WriteAt(b, int64(lba*d.blockSize)); err != nil {
+		return 0, fmt.Errorf("failed to write %d bytes at block %d: %v", len(b), lba, err)
+	}
+	if err := d.f.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync write at block %d: %v", lba, err)
+	}
+	return uint(len(b)) / d.blockSize, nil
+}
+
+// checkRange returns an error if the n bytes starting at the given block
+// address don't fall entirely within the image.
+func (d *Device) checkRange(lba, n uint) error {
+	if n%d.blockSize != 0 {
+		return fmt.Errorf("%d bytes is not a multiple of the block size %d", n, d.blockSize)
+	}
+	if end := lba + n/d.blockSize; lba >= d.numBlocks || end > d.numBlocks {
+		return fmt.Errorf("blocks [%d, %d) are outside image of %d blocks", lba, end, d.numBlocks)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+)
+
+const testBlockSize = 512
+
+func TestReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image")
+	d, err := Create(path, testBlockSize, 16)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer d.Close()
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 16*testBlockSize {
+		t.Fatalf("Stat = %v, %v, want size %d", fi, err, 16*testBlockSize)
+	}
+
+	// Partial blocks are padded.
+	if n, err := d.WriteBlocks(3, []byte("hello")); err != nil || n != 1 {
+		t.Fatalf("WriteBlocks = %d, %v, want 1 block", n, err)
+	}
+	b := make([]byte, 2*testBlockSize)
+	if err := d.ReadBlocks(3, b); err != nil {
+		t.Fatalf("ReadBlocks: %v", err)
+	}
+	want := append([]byte("hello"), make([]byte, len(b)-5)...)
+	if !bytes.Equal(b, want) {
+		t.Errorf("ReadBlocks got %q..., want %q...", b[:8], want[:8])
+	}
+
+	for _, lba := range []uint{15, 16} {
+		if _, err := d.WriteBlocks(lba, make([]byte, 2*testBlockSize)); err == nil {
+			t.Errorf("WriteBlocks(%d) past end of image succeeded", lba)
+		}
+	}
+	if err := d.ReadBlocks(0, make([]byte, testBlockSize+1)); err == nil {
+		t.Error("ReadBlocks of partial block succeeded")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.WriteBlocksContext(ctx, 0, []byte("x")); err == nil {
+		t.Error("WriteBlocksContext with cancelled context succeeded")
+	}
+}
+
+func TestOpenExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image")
+	d, err := Create(path, testBlockSize, 4)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := d.WriteBlocks(1, []byte("data")); err != nil {
+		t.Fatalf("WriteBlocks: %v", err)
+	}
+	d.Close()
+
+	// Creating an image which already exists must keep its contents, and size.
+	d, err = Create(path, testBlockSize, 2)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if got := d.NumBlocks(); got != 4 {
+		t.Errorf("NumBlocks = %d, want 4", got)
+	}
+	d.Close()
+
+	d, err = Open(path, testBlockSize)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer d.Close()
+	b := make([]byte, testBlockSize)
+	if err := d.ReadBlocks(1, b); err != nil || !bytes.HasPrefix(b, []byte("data")) {
+		t.Errorf("ReadBlocks = %q..., %v, want %q", b[:4], err, "data")
+	}
+	if _, err := d.WriteBlocks(0, b); err == nil {
+		t.Error("WriteBlocks to read-only image succeeded")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "image")
+	geo := slots.Geometry{Start: 8, Length: 72, TableBlocks: 2, IndexBlocks: 6, SlotLengths: []uint{10, 10, 10, 10, 10, 10}}
+
+	open := func() (*Device, *storage.SlotPersistence) {
+		t.Helper()
+		d, err := Create(path, testBlockSize, 128)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		part, err := slots.OpenPartition(d, geo)
+		if err != nil {
+			t.Fatalf("OpenPartition: %v", err)
+		}
+		p := storage.NewSlotPersistence(part)
+		if err := p.Init(ctx); err != nil {
+			t.Fatalf("Init: %v", err)
+		}
+		return d, p
+	}
+
+	d, p := open()
+	for _, cp := range []string{"CP 1", "CP 2"} {
+		if err := p.Update(ctx, "log", func([]byte) ([]byte, error) { return []byte(cp), nil }); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	d.Close()
+
+	d, p = open()
+	defer d.Close()
+	if got, err := p.Latest(ctx, "log"); err != nil || string(got) != "CP 2" {
+		t.Errorf("Latest = %q, %v, want %q", got, err, "CP 2")
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// firmwareCacheSlotCount is the number of slots reserved for caching firmware,
+// which immediately precede the transaction slot.
+// The first holds the cache's index, and the rest hold the cached data, split
+// into chunks which fit in a slot.
+const firmwareCacheSlotCount = 256
+
+var (
+	// firmwareIndexMagic and firmwareChunkMagic prefix the index and the
+	// chunks of data stored in the firmware cache, so that they can't be
+	// mistaken for checkpoints, or vice versa.
+	firmwareIndexMagic = []byte("\x01FWI")
+	firmwareChunkMagic = []byte("\x01FWC")
+
+	// ErrNoFirmwareCache is returned by the firmware cache methods if the
+	// partition is too small to reserve slots for the cache.
+	ErrNoFirmwareCache = errors.New("no slots reserved for firmware cache")
+)
+
+// CachedFirmware describes an entry in the firmware cache.
+type CachedFirmware struct {
+	// Key identifies the entry.
+	Key [sha256.Size]byte
+	// Description is the description given when the entry was cached.
+	Description string
+	// Size is the length of the cached data in bytes.
+	Size int
+	// Added is the time at which the entry was cached.
+	Added time.Time
+}
+
+// firmwareCacheEntry is the record of a cached entry stored in the index.
+type firmwareCacheEntry struct {
+	Key         string    `yaml:"key"`
+	Description string    `yaml:"description,omitempty"`
+	Size        int       `yaml:"size"`
+	SHA256      string    `yaml:"sha256"`
+	Slots       []uint    `yaml:"slots"`
+	Added       time.Time `yaml:"added"`
+}
+
+// firmwareCacheSlots returns the indices of the slots reserved for the
+// firmware cache, or nil if the partition is too small for them to take up
+// no more than half of it.
+func (p *SlotPersistence) firmwareCacheSlots() []uint {
+	t, ok := p.transactionSlot()
+	if !ok || t < 2*firmwareCacheSlotCount {
+		return nil
+	}
+	r := make([]uint, firmwareCacheSlotCount)
+	for i := range r {
+		r[i] = t - firmwareCacheSlotCount + uint(i)
+	}
+	return r
+}
+
+// CacheFirmware stores data in the firmware cache under the given key, along
+// with a description which is returned by FirmwareCache. Nothing is done if
+// the key is already cached.
+//
+// If there isn't room for the data, the oldest entries are evicted to make
+// room for it.
+func (p *SlotPersistence) CacheFirmware(ctx context.Context, key [sha256.Size]byte, description string, data []byte) error {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	c, err := p.openFirmwareCache(ctx)
+	if err != nil {
+		return err
+	}
+	if c.find(key) >= 0 {
+		return nil
+	}
+
+	// Work out which slots are free, and how much they can hold.
+	used := make(map[uint]bool)
+	for _, e := range c.entries {
+		for _, i := range e.Slots {
+			used[i] = true
+		}
+	}
+	free, room := []uint{}, 0
+	capacity := make(map[uint]int)
+	for _, i := range c.slots[1:] {
+		if capacity[i], err = p.firmwareChunkCapacity(ctx, i); err != nil {
+			return err
+		}
+		if !used[i] {
+			free, room = append(free, i), room+capacity[i]
+		}
+	}
+	evicted := false
+	for room < len(data) {
+		if len(c.entries) == 0 {
+			return fmt.Errorf("%d bytes won't fit in the firmware cache", len(data))
+		}
+		e := c.entries[0]
+		klog.Infof("Evicting %q from firmware cache to make room", e.Description)
+		c.entries = c.entries[1:]
+		for _, i := range e.Slots {
+			free, room = append(free, i), room+capacity[i]
+		}
+		evicted = true
+	}
+	if evicted {
+		// The evicted entries' slots can only be reused once the index no
+		// longer refers to them.
+		if err := c.storeIndex(ctx); err != nil {
+			return err
+		}
+	}
+	slices.Sort(free)
+
+	h := sha256.Sum256(data)
+	e := firmwareCacheEntry{
+		Key:         hex.EncodeToString(key[:]),
+		Description: description,
+		Size:        len(data),
+		SHA256:      hex.EncodeToString(h[:]),
+		Added:       time.Now(),
+	}
+	for rest := data; len(rest) > 0; {
+		i := free[len(e.Slots)]
+		n := min(capacity[i], len(rest))
+		if err := p.writeFirmwareChunk(ctx, i, rest[:n]); err != nil {
+			return err
+		}
+		e.Slots, rest = append(e.Slots, i), rest[n:]
+	}
+	c.entries = append(c.entries, e)
+	if err := c.storeIndex(ctx); err != nil {
+		return err
+	}
+	klog.Infof("Cached %q (%d bytes) in %d slots", description, len(data), len(e.Slots))
+	return nil
+}
+
+// LoadFirmware returns the data stored in the firmware cache under the given
+// key. An error wrapping ErrNotFound is returned if the key isn't cached, and
+// one wrapping ErrCorrupt if the cached data doesn't match what was stored.
+func (p *SlotPersistence) LoadFirmware(ctx context.Context, key [sha256.Size]byte) ([]byte, error) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	c, err := p.openFirmwareCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	j := c.find(key)
+	if j < 0 {
+		return nil, fmt.Errorf("%w: firmware %x isn't cached", ErrNotFound, key)
+	}
+	e := c.entries[j]
+	data := make([]byte, 0, e.Size)
+	for _, i := range e.Slots {
+		s, err := p.part.OpenContext(ctx, i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open slot %d: %v", i, err)
+		}
+		b, _, err := s.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read slot %d: %w", i, readErr(ctx, err))
+		}
+		chunk, ok := bytes.CutPrefix(b, firmwareChunkMagic)
+		if !ok {
+			return nil, corrupt(i, errors.New("slot doesn't hold cached firmware"))
+		}
+		data = append(data, chunk...)
+	}
+	if h := sha256.Sum256(data); hex.EncodeToString(h[:]) != e.SHA256 || len(data) != e.Size {
+		return nil, corrupt(e.Slots[0], fmt.Errorf("cached %q is %d bytes with SHA256 %x, want %d bytes with SHA256 %s", e.Description, len(data), h, e.Size, e.SHA256))
+	}
+	return data, nil
+}
+
+// EvictFirmware removes the entry with the given key from the firmware cache,
+// if there is one.
+func (p *SlotPersistence) EvictFirmware(ctx context.Context, key [sha256.Size]byte) error {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	c, err := p.openFirmwareCache(ctx)
+	if err != nil {
+		return err
+	}
+	j := c.find(key)
+	if j < 0 {
+		return nil
+	}
+	klog.Infof("Evicting %q from firmware cache", c.entries[j].Description)
+	c.entries = slices.Delete(c.entries, j, j+1)
+	return c.storeIndex(ctx)
+}
+
+// FirmwareCache lists the entries in the firmware cache, oldest first.
+func (p *SlotPersistence) FirmwareCache(ctx context.Context) ([]CachedFirmware, error) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	c, err := p.openFirmwareCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]CachedFirmware, 0, len(c.entries))
+	for _, e := range c.entries {
+		f := CachedFirmware{Description: e.Description, Size: e.Size, Added: e.Added}
+		if _, err := hex.Decode(f.Key[:], []byte(e.Key)); err != nil {
+			return nil, corrupt(c.slots[0], fmt.Errorf("invalid key %q in firmware cache index: %v", e.Key, err))
+		}
+		r = append(r, f)
+	}
+	return r, nil
+}
+
+// firmwareCache is the firmware cache's index, as read from storage.
+type firmwareCache struct {
+	// slots are the indices of the slots reserved for the cache, the first
+	// of which holds the index.
+	slots []uint
+	index *slots.Slot
+	token uint32
+	// entries are the cached entries, oldest first.
+	entries []firmwareCacheEntry
+}
+
+// openFirmwareCache reads the firmware cache's index.
+// Must be called with p.cacheMu locked.
+func (p *SlotPersistence) openFirmwareCache(ctx context.Context) (*firmwareCache, error) {
+	p.mu.RLock()
+	c := &firmwareCache{slots: p.firmwareCacheSlots()}
+	migrated := p.directoryVersion >= reservedSlotsVersion
+	p.mu.RUnlock()
+	if len(c.slots) == 0 || !migrated {
+		// Until the directory is migrated, the slots may hold logs' state.
+		return nil, ErrNoFirmwareCache
+	}
+
+	i := c.slots[0]
+	s, err := p.part.OpenContext(ctx, i)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open slot %d: %v", i, err)
+	}
+	b, t, err := s.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read slot %d: %w", i, readErr(ctx, err))
+	}
+	c.index, c.token = s, t
+	body, ok := bytes.CutPrefix(b, firmwareIndexMagic)
+	if !ok {
+		// Either empty, or left behind by a log which was moved out of the
+		// slot when it was reserved.
+		return c, nil
+	}
+	if err := yaml.Unmarshal(body, &c.entries); err != nil {
+		return nil, corrupt(i, fmt.Errorf("failed to unmarshal firmware cache index: %v", err))
+	}
+	return c, nil
+}
+
+// find returns the position of the entry with the given key, or -1 if there
+// isn't one.
+func (c *firmwareCache) find(key [sha256.Size]byte) int {
+	k := hex.EncodeToString(key[:])
+	return slices.IndexFunc(c.entries, func(e firmwareCacheEntry) bool { return e.Key == k })
+}
+
+// storeIndex durably stores the cache's index.
+func (c *firmwareCache) storeIndex(ctx context.Context) error {
+	b, err := yaml.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal firmware cache index: %v", err)
+	}
+	t, err := c.index.CheckAndWriteContext(ctx, c.token, append(bytes.Clone(firmwareIndexMagic), b...))
+	if err != nil {
+		return fmt.Errorf("failed to write firmware cache index to slot %d: %w", c.slots[0], err)
+	}
+	c.token = t
+	if err := c.index.Sync(); err != nil {
+		return fmt.Errorf("failed to sync firmware cache index in slot %d: %v", c.slots[0], err)
+	}
+	return nil
+}
+
+// firmwareChunkCapacity returns the number of bytes of cached data which can
+// be stored in the given slot.
+func (p *SlotPersistence) firmwareChunkCapacity(ctx context.Context, i uint) (int, error) {
+	s, err := p.part.OpenContext(ctx, i)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open slot %d: %v", i, err)
+	}
+	n, err := s.Capacity()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open slot %d: %w", i, readErr(ctx, err))
+	}
+	return n - len(firmwareChunkMagic), nil
+}
+
+// writeFirmwareChunk durably stores a chunk of cached data in the given slot.
+func (p *SlotPersistence) writeFirmwareChunk(ctx context.Context, i uint, chunk []byte) error {
+	s, err := p.part.OpenContext(ctx, i)
+	if err != nil {
+		return fmt.Errorf("failed to open slot %d: %v", i, err)
+	}
+	if err := s.WriteContext(ctx, append(bytes.Clone(firmwareChunkMagic), chunk...)); err != nil {
+		return fmt.Errorf("failed to write slot %d: %w", i, err)
+	}
+	if err := s.Sync(); err != nil {
+		return fmt.Errorf("failed to sync slot %d: %v", i, err)
+	}
+	return nil
+}
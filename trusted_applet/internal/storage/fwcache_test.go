@@ -0,0 +1,167 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+// newCachePersistence returns a SlotPersistence on a partition large enough
+// to reserve slots for the firmware cache.
+func newCachePersistence(t *testing.T) *SlotPersistence {
+	t.Helper()
+	const (
+		numSlots = 2*firmwareCacheSlotCount + 8
+		// Larger slots than usual, to hold the cache's index.
+		slotBlocks = 16
+	)
+	md := testonly.NewMemDev(t, numSlots*slotBlocks)
+	geo := slots.Geometry{Length: numSlots * slotBlocks}
+	for range numSlots {
+		geo.SlotLengths = append(geo.SlotLengths, slotBlocks)
+	}
+	part, err := slots.OpenPartition(md, geo)
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	p := NewSlotPersistence(part)
+	if err := p.Init(context.Background()); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return p
+}
+
+func TestFirmwareCache(t *testing.T) {
+	ctx := context.Background()
+	p := newCachePersistence(t)
+	cacheSlots := p.firmwareCacheSlots()
+	if got := len(cacheSlots); got != firmwareCacheSlotCount {
+		t.Fatalf("Got %d cache slots, want %d", got, firmwareCacheSlotCount)
+	}
+	for _, i := range p.freeSlots {
+		if slices.Contains(cacheSlots, i) {
+			t.Errorf("Cache slot %d is free to be assigned to a log", i)
+		}
+	}
+
+	perSlot, err := p.firmwareChunkCapacity(ctx, cacheSlots[1])
+	if err != nil {
+		t.Fatalf("firmwareChunkCapacity: %v", err)
+	}
+	// Each of these takes up just over half of the cache.
+	size := perSlot*(firmwareCacheSlotCount-1)/2 + 1
+	data := func(b byte) []byte { return bytes.Repeat([]byte{b}, size) }
+	keyA, keyB, keyC := sha256.Sum256([]byte("A")), sha256.Sum256([]byte("B")), sha256.Sum256([]byte("C"))
+
+	if err := p.CacheFirmware(ctx, keyA, "A", data('a')); err != nil {
+		t.Fatalf("CacheFirmware(A): %v", err)
+	}
+	// Caching the same key again does nothing.
+	if err := p.CacheFirmware(ctx, keyA, "A again", data('x')); err != nil {
+		t.Fatalf("CacheFirmware(A): %v", err)
+	}
+	if got, err := p.LoadFirmware(ctx, keyA); err != nil || !bytes.Equal(got, data('a')) {
+		t.Errorf("LoadFirmware(A) = %d bytes, %v, want A's data", len(got), err)
+	}
+	if _, err := p.LoadFirmware(ctx, keyB); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadFirmware(B) = %v, want ErrNotFound", err)
+	}
+
+	// There's no room for both, so caching B evicts A.
+	if err := p.CacheFirmware(ctx, keyB, "B", data('b')); err != nil {
+		t.Fatalf("CacheFirmware(B): %v", err)
+	}
+	if _, err := p.LoadFirmware(ctx, keyA); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadFirmware(A) after eviction = %v, want ErrNotFound", err)
+	}
+	if got, err := p.LoadFirmware(ctx, keyB); err != nil || !bytes.Equal(got, data('b')) {
+		t.Errorf("LoadFirmware(B) = %d bytes, %v, want B's data", len(got), err)
+	}
+
+	// Small entries fit alongside B.
+	if err := p.CacheFirmware(ctx, keyC, "C", []byte("c")); err != nil {
+		t.Fatalf("CacheFirmware(C): %v", err)
+	}
+	entries, err := p.FirmwareCache(ctx)
+	if err != nil {
+		t.Fatalf("FirmwareCache: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Description)
+	}
+	if want := []string{"B", "C"}; !slices.Equal(got, want) {
+		t.Errorf("FirmwareCache lists %q, want %q", got, want)
+	}
+	if entries[0].Key != keyB || entries[0].Size != size {
+		t.Errorf("FirmwareCache()[0] = %x, %d bytes, want %x, %d bytes", entries[0].Key, entries[0].Size, keyB, size)
+	}
+
+	if err := p.EvictFirmware(ctx, keyB); err != nil {
+		t.Fatalf("EvictFirmware(B): %v", err)
+	}
+	if err := p.EvictFirmware(ctx, keyB); err != nil {
+		t.Errorf("EvictFirmware(B) again: %v", err)
+	}
+	if _, err := p.LoadFirmware(ctx, keyB); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LoadFirmware(B) after eviction = %v, want ErrNotFound", err)
+	}
+
+	// Too large to fit even with everything evicted.
+	if err := p.CacheFirmware(ctx, keyA, "A", make([]byte, perSlot*firmwareCacheSlotCount)); err == nil {
+		t.Error("CacheFirmware of more data than the cache holds succeeded")
+	}
+}
+
+func TestFirmwareCacheCorrupt(t *testing.T) {
+	ctx := context.Background()
+	p := newCachePersistence(t)
+	key := sha256.Sum256([]byte("A"))
+	if err := p.CacheFirmware(ctx, key, "A", bytes.Repeat([]byte("a"), 2000)); err != nil {
+		t.Fatalf("CacheFirmware: %v", err)
+	}
+
+	// Swap the contents of the first chunk for something else.
+	i := p.firmwareCacheSlots()[1]
+	s, err := p.part.Open(i)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write(append(bytes.Clone(firmwareChunkMagic), 'b')); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_, err = p.LoadFirmware(ctx, key)
+	var ce *slots.CorruptError
+	if !errors.Is(err, ErrCorrupt) || !errors.As(err, &ce) || ce.Slot != int(i) {
+		t.Errorf("LoadFirmware of corrupt entry = %v, want corruption in slot %d", err, i)
+	}
+}
+
+func TestFirmwareCacheUnavailable(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	key := sha256.Sum256([]byte("A"))
+	if err := p.CacheFirmware(ctx, key, "A", []byte("a")); !errors.Is(err, ErrNoFirmwareCache) {
+		t.Errorf("CacheFirmware on small partition = %v, want ErrNoFirmwareCache", err)
+	}
+}
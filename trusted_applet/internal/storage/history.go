@@ -0,0 +1,185 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	logfmt "github.com/transparency-dev/formats/log"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// historyRecordMagic is a prefix which denotes that the bytes following it
+	// hold the latest checkpoint for a log along with a bounded history of the
+	// checkpoints which preceded it.
+	// Like rawRecordMagic, it contains a control character forbidden by YAML.
+	historyRecordMagic = []byte("\x01HST")
+)
+
+// HistoryEntry is a checkpoint which was stored for a log.
+type HistoryEntry struct {
+	// Revision identifies the write which stored this checkpoint, and
+	// increases by one with each update to the log's checkpoint.
+	Revision uint64
+	// Checkpoint is the checkpoint which was stored.
+	Checkpoint []byte
+}
+
+// HistoryPolicy bounds the number of previous checkpoints retained per log.
+type HistoryPolicy struct {
+	// MaxEntries is the maximum number of checkpoints to keep for each log,
+	// including the latest one. Values less than 2 disable history.
+	MaxEntries int
+	// MaxBytes, if non-zero, limits the total size of the checkpoints kept for
+	// each log. The latest checkpoint is always kept regardless of its size.
+	MaxBytes int
+}
+
+// enabled returns true if the policy retains any history.
+func (h HistoryPolicy) enabled() bool {
+	return h.MaxEntries > 1
+}
+
+// prune returns the longest prefix of entries, which are ordered newest first,
+// permitted by the policy.
+func (h HistoryPolicy) prune(entries []HistoryEntry) []HistoryEntry {
+	n, size := 0, 0
+	for _, e := range entries {
+		size += len(e.Checkpoint)
+		if n > 0 && (n >= h.MaxEntries || (h.MaxBytes > 0 && size > h.MaxBytes)) {
+			break
+		}
+		n++
+	}
+	return entries[:n]
+}
+
+// SetHistoryPolicy configures how many previous checkpoints are retained for
+// each log, and so can be returned by History.
+//
+// History is only recorded by calls to Update made after it's enabled, and
+// is dropped the next time a log is updated if it's disabled.
+// Note that records containing history cannot be read by earlier releases.
+func (p *SlotPersistence) SetHistoryPolicy(h HistoryPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.history = h
+}
+
+// History returns the checkpoints stored for the given log, newest first,
+// bounded by the configured HistoryPolicy.
+// The first entry is the same checkpoint returned by Latest.
+func (p *SlotPersistence) History(ctx context.Context, origin string) ([]HistoryEntry, error) {
+	logID := logfmt.ID(origin)
+	i, err := p.logSlot(logID, false)
+	if err != nil {
+		return nil, err
+	}
+	s, err := p.part.OpenContext(ctx, i)
+	if err != nil {
+		return nil, fmt.Errorf("internal error opening slot %d associated with log ID %q: %v", i, logID, err)
+	}
+	b, t, err := s.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data: %w", readErr(ctx, err))
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: no checkpoint for log", ErrNotFound)
+	}
+	h, err := unmarshalHistory(b, t)
+	if err != nil {
+		return nil, corrupt(i, err)
+	}
+	return h, nil
+}
+
+// marshalHistory serialises the provided entries, ordered newest first, for
+// storage by the persistence.
+func marshalHistory(entries []HistoryEntry) []byte {
+	b := append([]byte{}, historyRecordMagic...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(entries)))
+	for _, e := range entries {
+		b = binary.BigEndian.AppendUint64(b, e.Revision)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(e.Checkpoint)))
+		b = append(b, e.Checkpoint...)
+	}
+	return b
+}
+
+// unmarshalHistory returns the checkpoint history held in a stored record,
+// newest first.
+//
+// Records without history yield a single entry, whose revision is taken from
+// the slot token the record was read with.
+func unmarshalHistory(b []byte, token uint32) ([]HistoryEntry, error) {
+	r, ok := bytes.CutPrefix(b, historyRecordMagic)
+	if !ok {
+		cp, err := unmarshalCheckpoint(b)
+		if err != nil {
+			return nil, err
+		}
+		if cp == nil {
+			return nil, nil
+		}
+		return []HistoryEntry{{Revision: uint64(token), Checkpoint: cp}}, nil
+	}
+
+	if len(r) < 4 {
+		return nil, errors.New("history record truncated in header")
+	}
+	n := binary.BigEndian.Uint32(r)
+	r = r[4:]
+	entries := make([]HistoryEntry, 0, min(n, 64))
+	for i := range n {
+		if len(r) < 12 {
+			return nil, fmt.Errorf("history record truncated in entry %d", i)
+		}
+		e := HistoryEntry{Revision: binary.BigEndian.Uint64(r)}
+		l := binary.BigEndian.Uint32(r[8:])
+		r = r[12:]
+		if uint64(len(r)) < uint64(l) {
+			return nil, fmt.Errorf("history record truncated in checkpoint %d", i)
+		}
+		e.Checkpoint, r = r[:l], r[l:]
+		entries = append(entries, e)
+	}
+	if len(r) != 0 {
+		klog.Warningf("Ignoring %d trailing bytes in history record", len(r))
+	}
+	return entries, nil
+}
+
+// appendHistory returns the record to store for a log when its checkpoint is
+// updated to cp, given its current record and slot token.
+func (h HistoryPolicy) appendHistory(current []byte, token uint32, cp []byte) ([]byte, error) {
+	if !h.enabled() {
+		return marshalCheckpoint(cp), nil
+	}
+	entries, err := unmarshalHistory(current, token)
+	if err != nil {
+		return nil, err
+	}
+	rev := uint64(token) + 1
+	if len(entries) > 0 {
+		rev = entries[0].Revision + 1
+	}
+	entries = append([]HistoryEntry{{Revision: rev, Checkpoint: cp}}, entries...)
+	return marshalHistory(h.prune(entries)), nil
+}
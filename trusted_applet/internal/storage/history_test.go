@@ -0,0 +1,186 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHistory(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		policy HistoryPolicy
+		writes int
+		want   []HistoryEntry
+	}{
+		{
+			name:   "disabled",
+			writes: 3,
+			want:   []HistoryEntry{{Revision: 3, Checkpoint: []byte("CP 2")}},
+		}, {
+			name:   "bounded by count",
+			policy: HistoryPolicy{MaxEntries: 3},
+			writes: 5,
+			want: []HistoryEntry{
+				{Revision: 5, Checkpoint: []byte("CP 4")},
+				{Revision: 4, Checkpoint: []byte("CP 3")},
+				{Revision: 3, Checkpoint: []byte("CP 2")},
+			},
+		}, {
+			name:   "bounded by bytes",
+			policy: HistoryPolicy{MaxEntries: 10, MaxBytes: 9},
+			writes: 5,
+			want: []HistoryEntry{
+				{Revision: 5, Checkpoint: []byte("CP 4")},
+				{Revision: 4, Checkpoint: []byte("CP 3")},
+			},
+		}, {
+			name:   "fewer writes than limit",
+			policy: HistoryPolicy{MaxEntries: 10},
+			writes: 2,
+			want: []HistoryEntry{
+				{Revision: 2, Checkpoint: []byte("CP 1")},
+				{Revision: 1, Checkpoint: []byte("CP 0")},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			p := newTestPersistence(t)
+			p.SetHistoryPolicy(test.policy)
+			for i := range test.writes {
+				mustStore(t, p, "log", fmt.Sprintf("CP %d", i))
+			}
+			got, err := p.History(ctx, "log")
+			if err != nil {
+				t.Fatalf("History: %v", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Got diff: %s", diff)
+			}
+			cp, err := p.Latest(ctx, "log")
+			if err != nil {
+				t.Fatalf("Latest: %v", err)
+			}
+			if diff := cmp.Diff(test.want[0].Checkpoint, cp); diff != "" {
+				t.Errorf("Latest differs from newest history entry: %s", diff)
+			}
+		})
+	}
+}
+
+func TestHistoryPolicyChanges(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+
+	// Records written before history is enabled become the oldest entry.
+	mustStore(t, p, "log", "CP 0")
+	mustStore(t, p, "log", "CP 1")
+	p.SetHistoryPolicy(HistoryPolicy{MaxEntries: 5})
+	mustStore(t, p, "log", "CP 2")
+	want := []HistoryEntry{
+		{Revision: 3, Checkpoint: []byte("CP 2")},
+		{Revision: 2, Checkpoint: []byte("CP 1")},
+	}
+	got, err := p.History(ctx, "log")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got diff after enabling: %s", diff)
+	}
+
+	// History must survive a reboot.
+	p = NewSlotPersistence(p.part)
+	if err := p.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	got, err = p.History(ctx, "log")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got diff after reboot: %s", diff)
+	}
+
+	// Disabling history drops it on the next update.
+	mustStore(t, p, "log", "CP 3")
+	got, err = p.History(ctx, "log")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(got) != 1 || string(got[0].Checkpoint) != "CP 3" {
+		t.Errorf("Got %+v after disabling, want only CP 3", got)
+	}
+}
+
+func TestHistoryUnknownLog(t *testing.T) {
+	p := newTestPersistence(t)
+	if _, err := p.History(context.Background(), "nope"); status.Code(err) != codes.NotFound {
+		t.Errorf("History: %v, want NotFound", err)
+	}
+}
+
+func TestUnmarshalHistory(t *testing.T) {
+	valid := marshalHistory([]HistoryEntry{
+		{Revision: 2, Checkpoint: []byte("CP 1")},
+		{Revision: 1, Checkpoint: []byte("CP 0")},
+	})
+	for _, test := range []struct {
+		name    string
+		b       []byte
+		want    []HistoryEntry
+		wantErr bool
+	}{
+		{
+			name: "history",
+			b:    valid,
+			want: []HistoryEntry{
+				{Revision: 2, Checkpoint: []byte("CP 1")},
+				{Revision: 1, Checkpoint: []byte("CP 0")},
+			},
+		}, {
+			name: "raw",
+			b:    marshalCheckpoint([]byte("CP")),
+			want: []HistoryEntry{{Revision: 7, Checkpoint: []byte("CP")}},
+		}, {
+			name: "legacy",
+			b:    marshalOldCheckpoint(t, []byte("CP")),
+			want: []HistoryEntry{{Revision: 7, Checkpoint: []byte("CP")}},
+		}, {
+			name: "empty",
+		}, {
+			name:    "truncated",
+			b:       valid[:len(valid)-1],
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := unmarshalHistory(test.b, 7)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("unmarshalHistory: %v, wantErr %t", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Got diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+)
+
+// Contents describes the witness state found in a partition by Inspect.
+type Contents struct {
+	// DirectoryVersion is the format version of the stored directory.
+	DirectoryVersion uint32
+	// DirectoryGeneration is the generation of the stored directory.
+	DirectoryGeneration uint64
+	// DirectoryRevision is the revision of the slot holding the latest copy
+	// of the directory.
+	DirectoryRevision uint32
+	// Logs describes each log in the directory, ordered by slot.
+	Logs []LogContents
+}
+
+// LogContents describes the state stored for a single log.
+type LogContents struct {
+	// ID is the log's ID, as recorded in the directory.
+	ID string
+	// Origin is the first line of the stored checkpoint, if there is one.
+	Origin string
+	// Slot is the index of the slot which stores the log's state.
+	Slot uint
+	// Revision is the revision of the log's slot.
+	Revision uint32
+	// Retired is the time at which the log was retired, or zero if it's still
+	// being witnessed.
+	Retired time.Time
+	// Checkpoint is the latest checkpoint stored for the log.
+	Checkpoint []byte
+	// Error describes why the log's state couldn't be read, if it couldn't.
+	Error string `json:",omitempty"`
+}
+
+// Inspect reads the directory and the latest checkpoint of every log stored
+// in part, without modifying them.
+//
+// This is intended for examining dumps of the storage offline, so failing to
+// read a log's state is reported in its LogContents rather than as an error.
+func Inspect(ctx context.Context, part *slots.Partition) (*Contents, error) {
+	// Use the latest generation of the directory found among its copies,
+	// without insisting on a quorum of them being readable.
+	p := NewSlotPersistence(part)
+	if err := p.openDirectoryCopies(); err != nil {
+		return nil, err
+	}
+	var d directory
+	var rev uint32
+	var errs []error
+	found := false
+	for i := range p.directoryCopies {
+		c, err := p.directoryCopies[i].read(i > 0)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !found || c.Generation > d.Generation {
+			d, rev, found = c, p.directoryCopies[i].token, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no readable copy of the directory: %v", errors.Join(errs...))
+	}
+
+	c := &Contents{DirectoryVersion: d.Version, DirectoryGeneration: d.Generation, DirectoryRevision: rev}
+	for id, i := range d.Slots {
+		l := LogContents{ID: id, Slot: i, Retired: d.Retired[id]}
+		if err := inspectLog(ctx, part, &l); err != nil {
+			l.Error = err.Error()
+		}
+		c.Logs = append(c.Logs, l)
+	}
+	sort.Slice(c.Logs, func(i, j int) bool { return c.Logs[i].Slot < c.Logs[j].Slot })
+	return c, nil
+}
+
+// inspectLog reads the latest checkpoint stored in l's slot.
+func inspectLog(ctx context.Context, part *slots.Partition, l *LogContents) error {
+	s, err := part.OpenContext(ctx, l.Slot)
+	if err != nil {
+		return fmt.Errorf("failed to open slot: %v", err)
+	}
+	b, rev, err := s.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read data: %v", err)
+	}
+	l.Revision = rev
+	if len(b) == 0 {
+		return nil
+	}
+	if l.Checkpoint, err = unmarshalCheckpoint(b); err != nil {
+		return err
+	}
+	origin, _, _ := bytes.Cut(l.Checkpoint, []byte("\n"))
+	l.Origin = string(origin)
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	logfmt "github.com/transparency-dev/formats/log"
+)
+
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	mustStore(t, p, "one", "one\n1\nroot\n")
+	mustStore(t, p, "two", "two\n1\nroot\n")
+	mustStore(t, p, "two", "two\n2\nroot\n")
+	if err := p.Retire(ctx, "one"); err != nil {
+		t.Fatalf("Retire: %v", err)
+	}
+	// Corrupt the record stored for a third log.
+	mustStore(t, p, "three", "three\n1\nroot\n")
+	s, err := p.part.Open(p.idToSlot[logfmt.ID("three")])
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write([]byte("not: [a checkpoint")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	c, err := Inspect(ctx, p.part)
+	if err != nil {
+		t.Fatalf("Inspect: %v", err)
+	}
+	if c.DirectoryVersion != directoryFormatVersion {
+		t.Errorf("DirectoryVersion = %d, want %d", c.DirectoryVersion, directoryFormatVersion)
+	}
+	if len(c.Logs) != 3 {
+		t.Fatalf("Inspect found %d logs, want 3: %+v", len(c.Logs), c.Logs)
+	}
+	for i, want := range []struct {
+		origin, cp string
+		rev        uint32
+		retired    bool
+		wantErr    bool
+	}{
+		{origin: "one", cp: "one\n1\nroot\n", rev: 1, retired: true},
+		{origin: "two", cp: "two\n2\nroot\n", rev: 2},
+		{rev: 2, wantErr: true},
+	} {
+		l := c.Logs[i]
+		if gotErr := l.Error != ""; gotErr != want.wantErr {
+			t.Errorf("Log %d: got error %q, want error %t", i, l.Error, want.wantErr)
+		}
+		if l.Origin != want.origin || string(l.Checkpoint) != want.cp || l.Revision != want.rev || l.Retired.IsZero() == want.retired {
+			t.Errorf("Log %d = %+v, want %+v", i, l, want)
+		}
+		if l.Slot != p.idToSlot[l.ID] {
+			t.Errorf("Log %d: got slot %d, want %d", i, l.Slot, p.idToSlot[l.ID])
+		}
+	}
+}
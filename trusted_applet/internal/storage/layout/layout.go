@@ -0,0 +1,84 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package layout describes where the witness stores its data on the eMMC.
+//
+// This is shared by the applet and by tools which examine dumps of the eMMC,
+// so that they always agree on the layout.
+package layout
+
+import (
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/remap"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+)
+
+const (
+	// StartBlock defines where our witness data storage partition starts.
+	// Changing this location is overwhelmingly likely to result in data loss.
+	StartBlock = 0x400000
+	// LengthBlocks specifies the size of the slots partition.
+	// Increasing this value is relatively safe, if you're sure there is no data
+	// stored in blocks which follow the current partition.
+	//
+	// We're starting with enough space for 4096 slots of 512KB each, which should be plenty.
+	LengthBlocks = 0x400000
+	// TableBlocks is the number of blocks reserved for the partition table
+	// which records the slots geometry.
+	//
+	// These immediately precede StartBlock, in blocks which were unused by
+	// earlier releases, so that existing slot data stays where it is.
+	TableBlocks = 16
+	// IndexBlocks is the number of blocks reserved for journal index
+	// superblocks, one per slot, which allow slots to be opened without
+	// scanning them.
+	//
+	// These sit between the partition table and StartBlock, so like the table
+	// they occupy blocks unused by earlier releases.
+	IndexBlocks = 4096
+	// RemapSpareBlocks is the number of spare blocks to which blocks which fail
+	// write verification are remapped.
+	//
+	// These, along with the two blocks holding the remap table, immediately
+	// precede the partition table.
+	RemapSpareBlocks = 32
+
+	// SlotSizeBytes is the size of each individual slot in the partition.
+	// Changing this is overwhelmingly likely to result in data loss.
+	SlotSizeBytes = 512 << 10
+)
+
+// Geometry returns the geometry of the witness storage partition on a device
+// with the given block size.
+func Geometry(blockSize uint) slots.Geometry {
+	geo := slots.Geometry{
+		Start:       StartBlock - IndexBlocks - TableBlocks,
+		Length:      LengthBlocks + IndexBlocks + TableBlocks,
+		TableBlocks: TableBlocks,
+		IndexBlocks: IndexBlocks,
+	}
+	sl := SlotSizeBytes / blockSize
+	for i := uint(0); i < LengthBlocks; i += sl {
+		geo.SlotLengths = append(geo.SlotLengths, sl)
+	}
+	return geo
+}
+
+// Remap returns the location of the blocks reserved for remapping blocks of
+// the witness storage partition which fail write verification.
+func Remap() remap.Geometry {
+	return remap.Geometry{
+		Start:  StartBlock - IndexBlocks - TableBlocks - 2 - RemapSpareBlocks,
+		Spares: RemapSpareBlocks,
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// directoryFormatVersion is the format version of the directory written by
+// this build.
+// It must be the same as the version produced by the final entry in
+// migrations.
+const directoryFormatVersion = 3
+
+// migration describes a step which upgrades the stored state from one format
+// version to the next.
+type migration struct {
+	// to is the format version produced by this migration, it will only be
+	// applied to state stored with an earlier version.
+	to uint32
+	// description briefly describes the change made by this migration.
+	description string
+	// migrate performs any changes required to the in-memory state, the
+	// directory is stored with the new version once it returns.
+	// Must be called with p.mu write-locked.
+	migrate func(p *SlotPersistence) error
+}
+
+// migrations is the ordered list of steps used to upgrade stored state to the
+// current format version.
+//
+// Migrations run synchronously during Init, so they should be quick. Slower
+// upgrades of per-log data should be done by UpgradeRecords in the background.
+var migrations = []migration{
+	{
+		to:          1,
+		description: "add versioned header to directory",
+		// Nothing to do other than store the directory with its new header.
+		migrate: func(*SlotPersistence) error { return nil },
+	}, {
+		to:          2,
+		description: "record retired logs in directory",
+		// No logs can have been retired yet, so there's nothing to do.
+		migrate: func(*SlotPersistence) error { return nil },
+	}, {
+		to:          3,
+		description: "reserve slots for directory mirrors, transaction intent records and firmware cache",
+		// Any logs assigned to the now reserved slots are moved out of them
+		// when the directory is opened, which also happens if the partition
+		// is grown, so there's nothing to do here.
+		migrate: func(*SlotPersistence) error { return nil },
+	},
+}
+
+// applyMigrations upgrades the stored state to the current format version.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) applyMigrations() error {
+	migrated := false
+	for _, m := range migrations {
+		if m.to <= p.directoryVersion {
+			continue
+		}
+		klog.Infof("Migrating storage from format version %d to %d: %s", p.directoryVersion, m.to, m.description)
+		if err := m.migrate(p); err != nil {
+			return fmt.Errorf("migration to format version %d failed: %v", m.to, err)
+		}
+		p.directoryVersion = m.to
+		migrated = true
+	}
+	// storeDirectory always writes the current version, so only do so once
+	// all of the migrations have been applied.
+	if !migrated {
+		return nil
+	}
+	if err := p.storeDirectory(); err != nil {
+		return fmt.Errorf("failed to store migrated directory: %v", err)
+	}
+	return nil
+}
+
+// UpgradeRecords rewrites any checkpoints which are still stored using the
+// legacy YAML logRecord encoding into the raw format.
+//
+// This is intended to be run in the background once Init has completed,
+// and will return early if ctx becomes done.
+// Concurrent updates to a log take precedence over upgrading its record, and
+// any such records will be skipped since they'll have been stored in the raw
+// format anyway.
+func (p *SlotPersistence) UpgradeRecords(ctx context.Context) error {
+	p.mu.RLock()
+	ids := make(slotMap, len(p.idToSlot))
+	for id, i := range p.idToSlot {
+		ids[id] = i
+	}
+	p.mu.RUnlock()
+
+	n := 0
+	for id, i := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s, err := p.part.OpenContext(ctx, i)
+		if err != nil {
+			return fmt.Errorf("failed to open slot %d associated with log ID %q: %v", i, id, err)
+		}
+		b, t, err := s.Read()
+		if err != nil {
+			klog.Warningf("Failed to read slot %d associated with log ID %q: %v", i, id, err)
+			continue
+		}
+		if len(b) == 0 || bytes.HasPrefix(b, rawRecordMagic) || bytes.HasPrefix(b, historyRecordMagic) {
+			continue
+		}
+		cp, err := unmarshalCheckpoint(b)
+		if err != nil {
+			klog.Warningf("Failed to unmarshal legacy record in slot %d for log ID %q: %v", i, id, err)
+			continue
+		}
+		if _, err := s.CheckAndWriteContext(ctx, t, marshalCheckpoint(cp)); err != nil {
+			klog.Warningf("Failed to upgrade record in slot %d for log ID %q: %v", i, id, err)
+			continue
+		}
+		n++
+	}
+	klog.Infof("Upgraded %d legacy checkpoint record(s)", n)
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	logfmt "github.com/transparency-dev/formats/log"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDirectoryRoundTrip(t *testing.T) {
+	want := directory{
+		Slots:    slotMap{"log1": 1, "log2": 2},
+		Retired:  map[string]time.Time{"log2": time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Releases: map[string]time.Time{"OS 1.2.3": time.Date(2026, 2, 3, 4, 5, 6, 0, time.UTC)},
+	}
+	b, err := marshalDirectory(want)
+	if err != nil {
+		t.Fatalf("marshalDirectory: %v", err)
+	}
+	if !bytes.HasPrefix(b, directoryMagic) {
+		t.Errorf("Marshalled directory %q missing magic prefix", b)
+	}
+	d, err := unmarshalDirectory(b)
+	if err != nil {
+		t.Fatalf("unmarshalDirectory: %v", err)
+	}
+	if got, want := d.Version, uint32(directoryFormatVersion); got != want {
+		t.Errorf("Got version %d, want %d", got, want)
+	}
+	if diff := cmp.Diff(want.Slots, d.Slots); diff != "" {
+		t.Errorf("Got slots diff: %s", diff)
+	}
+	if diff := cmp.Diff(want.Retired, d.Retired); diff != "" {
+		t.Errorf("Got retired diff: %s", diff)
+	}
+	if diff := cmp.Diff(want.Releases, d.Releases); diff != "" {
+		t.Errorf("Got releases diff: %s", diff)
+	}
+}
+
+func TestUnmarshalDirectory(t *testing.T) {
+	legacy, err := yaml.Marshal(slotMap{"log1": 1})
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %v", err)
+	}
+	for _, test := range []struct {
+		name        string
+		b           []byte
+		wantVersion uint32
+		wantSlots   slotMap
+		wantErr     bool
+	}{
+		{
+			name:      "empty",
+			wantSlots: slotMap{},
+		}, {
+			name:      "legacy",
+			b:         legacy,
+			wantSlots: slotMap{"log1": 1},
+		}, {
+			name:        "v1",
+			b:           versionedDirectory(1, legacy),
+			wantVersion: 1,
+			wantSlots:   slotMap{"log1": 1},
+		}, {
+			name:        "v2",
+			b:           versionedDirectory(2, []byte("slots:\n  log1: 1\n")),
+			wantVersion: 2,
+			wantSlots:   slotMap{"log1": 1},
+		}, {
+			name:    "future version",
+			b:       versionedDirectory(directoryFormatVersion+1, legacy),
+			wantErr: true,
+		}, {
+			name:    "truncated header",
+			b:       append(append([]byte{}, directoryMagic...), 0x00, 0x01),
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := unmarshalDirectory(test.b)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("unmarshalDirectory: %v, wantErr %t", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if d.Version != test.wantVersion {
+				t.Errorf("Got version %d, want %d", d.Version, test.wantVersion)
+			}
+			if diff := cmp.Diff(test.wantSlots, d.Slots); diff != "" {
+				t.Errorf("Got diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestInitMigratesLegacyDirectory(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	logID := logfmt.ID("legacy log")
+
+	// Overwrite the directory with a legacy one, and store a legacy record.
+	legacy, err := yaml.Marshal(slotMap{logID: 1})
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %v", err)
+	}
+	writeLegacyDirectory(t, p, legacy)
+	s, err := p.part.Open(1)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write(marshalOldCheckpoint(t, []byte("CP"))); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	p = NewSlotPersistence(p.part)
+	if err := p.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	b, _, err := p.directoryCopies[0].slot.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	d, err := unmarshalDirectory(b)
+	if err != nil {
+		t.Fatalf("unmarshalDirectory: %v", err)
+	}
+	if got, want := d.Version, uint32(directoryFormatVersion); got != want {
+		t.Errorf("Got stored version %d, want %d", got, want)
+	}
+	if diff := cmp.Diff(slotMap{logID: 1}, d.Slots); diff != "" {
+		t.Errorf("Got diff: %s", diff)
+	}
+
+	if err := p.UpgradeRecords(ctx); err != nil {
+		t.Fatalf("UpgradeRecords: %v", err)
+	}
+	b, _, err = s.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if want := marshalCheckpoint([]byte("CP")); !bytes.Equal(b, want) {
+		t.Errorf("Got record %q after upgrade, want %q", b, want)
+	}
+	if cp, err := p.Latest(ctx, "legacy log"); err != nil || string(cp) != "CP" {
+		t.Errorf("Latest: %q, %v, want %q", cp, err, "CP")
+	}
+}
+
+func TestInitRefusesFutureVersion(t *testing.T) {
+	p := newTestPersistence(t)
+	if err := p.directoryCopies[0].slot.Write(versionedDirectory(directoryFormatVersion+1, nil)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	p = NewSlotPersistence(p.part)
+	if err := p.Init(context.Background()); err == nil {
+		t.Fatal("Init succeeded, want error")
+	}
+}
+
+// writeLegacyDirectory replaces the directory with one written by a build
+// which predates mirroring it.
+func writeLegacyDirectory(t *testing.T, p *SlotPersistence, b []byte) {
+	t.Helper()
+	for i, c := range p.directoryCopies {
+		if i > 0 {
+			b = nil
+		}
+		if err := c.slot.Write(b); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+}
+
+func versionedDirectory(v uint32, body []byte) []byte {
+	b := append([]byte{}, directoryMagic...)
+	b = binary.BigEndian.AppendUint32(b, v)
+	return append(b, body...)
+}
@@ -0,0 +1,289 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// directoryMirrors is the number of copies of the directory kept in
+	// addition to the one in mappingConfigSlot.
+	// The mirrors are stored in the slots immediately preceding the event
+	// slot, since slots are assigned to logs starting from the beginning.
+	directoryMirrors = 2
+
+	// reservedSlotsVersion is the first directory format version which is
+	// mirrored, and which reserves slots for transaction intent records and
+	// the firmware cache.
+	reservedSlotsVersion = 3
+)
+
+var (
+	// ErrDirectoryUnavailable is returned by Init if too few copies of the
+	// logID → slot directory can be read to be sure of having the latest one.
+	// ReconstructDirectory can be used to rebuild it from the stored
+	// checkpoints.
+	ErrDirectoryUnavailable = errors.New("directory unavailable")
+)
+
+// directoryCopy is one of the slots holding a copy of the directory.
+type directoryCopy struct {
+	// index is the index of the slot.
+	index uint
+	slot  *slots.Slot
+	// token is the write token for the slot, as of the last read or write.
+	token uint32
+}
+
+// read reads the directory stored in the slot.
+// If mirror is true, an error is returned unless the slot holds a copy of a
+// mirrored directory, as opposed to e.g. being empty.
+func (c *directoryCopy) read(mirror bool) (directory, error) {
+	b, t, err := c.slot.Read()
+	if err != nil {
+		return directory{}, fmt.Errorf("failed to read slot %d: %v", c.index, err)
+	}
+	c.token = t
+	d, err := unmarshalDirectory(b)
+	if errors.Is(err, errFutureDirectory) {
+		return d, fmt.Errorf("slot %d: %w", c.index, err)
+	} else if err != nil {
+		return d, corrupt(c.index, err)
+	}
+	if mirror && d.Version < reservedSlotsVersion {
+		return d, fmt.Errorf("slot %d doesn't hold a copy of the directory", c.index)
+	}
+	return d, nil
+}
+
+// write stores b in the slot, and waits for it to be durable.
+func (c *directoryCopy) write(b []byte) error {
+	t, err := c.slot.CheckAndWrite(c.token, b)
+	if err != nil {
+		// Pick up the slot's current token in case the failure left it
+		// changed, so that the copy can be repaired by a later write.
+		if _, t, err := c.slot.Read(); err == nil {
+			c.token = t
+		}
+		return fmt.Errorf("failed to store mapping in slot %d: %w", c.index, err)
+	}
+	c.token = t
+	// The directory is always written through, since losing it would lose
+	// track of which slot holds which log's state.
+	if err := c.slot.Sync(); err != nil {
+		return fmt.Errorf("failed to sync mapping in slot %d: %v", c.index, err)
+	}
+	return nil
+}
+
+// mirrorSlots returns the indices of the slots reserved for mirrors of the
+// directory.
+func (p *SlotPersistence) mirrorSlots() []uint {
+	var r []uint
+	for i := uint(1); i <= directoryMirrors; i++ {
+		if s := p.eventSlot() - i; s > mappingConfigSlot && s < p.eventSlot() {
+			r = append(r, s)
+		}
+	}
+	return r
+}
+
+// reservedSlots returns the indices of the slots, other than mappingConfigSlot
+// and the event slot, which are reserved and so can't be assigned to logs.
+func (p *SlotPersistence) reservedSlots() []uint {
+	r := p.mirrorSlots()
+	if i, ok := p.transactionSlot(); ok {
+		r = append(r, i)
+	}
+	return append(r, p.firmwareCacheSlots()...)
+}
+
+// openDirectoryCopies opens the slots which hold copies of the directory.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) openDirectoryCopies() error {
+	p.directoryCopies = nil
+	for _, i := range append([]uint{mappingConfigSlot}, p.mirrorSlots()...) {
+		s, err := p.part.Open(i)
+		if err != nil {
+			return fmt.Errorf("failed to open mapping slot %d: %v", i, err)
+		}
+		p.directoryCopies = append(p.directoryCopies, directoryCopy{index: i, slot: s})
+	}
+	return nil
+}
+
+// readDirectory reads every copy of the directory, and returns the latest
+// generation found among them.
+//
+// Once the directory is mirrored, a majority of the copies must be readable,
+// otherwise there's a risk that the latest generation has been lost and an
+// earlier one would be used in its place. Any copies which are unreadable or
+// out of date are flagged for repair by setting p.directoryStale.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) readDirectory() (directory, error) {
+	ds := make([]directory, len(p.directoryCopies))
+	errs := make([]error, len(p.directoryCopies))
+	best := -1
+	for i := range p.directoryCopies {
+		ds[i], errs[i] = p.directoryCopies[i].read(i > 0)
+		if errors.Is(errs[i], errFutureDirectory) {
+			// A later build has been here, and it's not safe to carry on.
+			return directory{}, errs[i]
+		}
+		if errs[i] == nil && (best < 0 || ds[i].Generation > ds[best].Generation) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return directory{}, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, errors.Join(errs...))
+	}
+	d := ds[best]
+	if d.Version < reservedSlotsVersion {
+		// The mirrors are yet to be created.
+		return d, nil
+	}
+
+	readable := 0
+	for i := range p.directoryCopies {
+		switch {
+		case errs[i] != nil:
+			klog.Warningf("Copy of directory is unreadable: %v", errs[i])
+		case ds[i].Generation != d.Generation:
+			klog.Warningf("Copy of directory in slot %d is generation %d, latest is %d", p.directoryCopies[i].index, ds[i].Generation, d.Generation)
+			readable++
+		default:
+			readable++
+			continue
+		}
+		p.directoryStale = true
+	}
+	if n := len(p.directoryCopies); readable < n/2+1 {
+		return directory{}, fmt.Errorf("%w: only %d of %d copies are readable: %v", ErrDirectoryUnavailable, readable, n, errors.Join(errs...))
+	}
+	return d, nil
+}
+
+// storeDirectory writes the current logID -> slot map to every copy of the
+// directory.
+//
+// This only fails if a majority of the copies couldn't be written, any others
+// which failed are flagged for repair by setting p.directoryStale.
+// Must be called with p.mu at leaest read-locked.
+func (p *SlotPersistence) storeDirectory() error {
+	smRaw, err := marshalDirectory(directory{Generation: p.directoryGeneration + 1, Slots: p.idToSlot, Retired: p.retired, Releases: p.releases})
+	if err != nil {
+		return err
+	}
+	p.directoryGeneration++
+
+	inUse := make(map[uint]bool)
+	for _, i := range p.idToSlot {
+		inUse[i] = true
+	}
+	var errs []error
+	eligible, written := 0, 0
+	for i := range p.directoryCopies {
+		c := &p.directoryCopies[i]
+		if inUse[c.index] {
+			// A log is yet to be moved out of this mirror slot by
+			// vacateReservedSlots.
+			continue
+		}
+		eligible++
+		if err := c.write(smRaw); err != nil {
+			klog.Warningf("Failed to store copy of directory: %v", err)
+			errs = append(errs, err)
+			continue
+		}
+		written++
+	}
+	p.directoryStale = written < len(p.directoryCopies)
+	if written < eligible/2+1 {
+		return fmt.Errorf("only stored %d of %d copies of the directory: %v", written, eligible, errors.Join(errs...))
+	}
+	return nil
+}
+
+// vacateReservedSlots moves the state of any logs which are assigned to
+// reserved slots, such as those for mirrors of the directory or the event
+// slot, to free slots.
+// This is needed when upgrading from a format version which didn't reserve
+// them, or if the partition has been grown, moving the reserved slots.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) vacateReservedSlots() error {
+	reserved := append(p.reservedSlots(), p.eventSlot())
+	moved, movedEvents := false, false
+	for id, from := range p.idToSlot {
+		if !slices.Contains(reserved, from) {
+			continue
+		}
+		if len(p.freeSlots) == 0 {
+			return fmt.Errorf("%w to move log ID %q to", ErrPartitionFull, id)
+		}
+		to := p.freeSlots[0]
+		if err := p.copySlot(from, to); err != nil {
+			return fmt.Errorf("failed to move log ID %q from slot %d to %d: %v", id, from, to, err)
+		}
+		klog.Infof("Moved log ID %q out of reserved slot %d to %d", id, from, to)
+		p.freeSlots = p.freeSlots[1:]
+		p.idToSlot[id] = to
+		moved = true
+		movedEvents = movedEvents || from == p.eventSlot()
+	}
+	if !moved {
+		return nil
+	}
+	// The old slots are only overwritten with copies of the directory once it
+	// records the new locations.
+	if err := p.storeDirectory(); err != nil {
+		return err
+	}
+	if movedEvents {
+		// The log's old checkpoint isn't a list of events.
+		return p.clearSlot(p.eventSlot())
+	}
+	return nil
+}
+
+// copySlot copies the data stored in one slot to another, which is assumed to
+// be free.
+func (p *SlotPersistence) copySlot(from, to uint) error {
+	src, err := p.part.Open(from)
+	if err != nil {
+		return fmt.Errorf("failed to open slot %d: %v", from, err)
+	}
+	b, _, err := src.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read slot %d: %v", from, err)
+	}
+	dst, err := p.part.Open(to)
+	if err != nil {
+		return fmt.Errorf("failed to open slot %d: %v", to, err)
+	}
+	_, t, err := dst.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read slot %d: %v", to, err)
+	}
+	if _, err := dst.CheckAndWrite(t, b); err != nil {
+		return fmt.Errorf("failed to write slot %d: %v", to, err)
+	}
+	return dst.Sync()
+}
@@ -0,0 +1,226 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	logfmt "github.com/transparency-dev/formats/log"
+)
+
+// reopen returns a new persistence for the same partition as p, as would be
+// created when the witness restarts.
+func reopen(t *testing.T, p *SlotPersistence) (*SlotPersistence, error) {
+	t.Helper()
+	p = NewSlotPersistence(p.part)
+	return p, p.Init(context.Background())
+}
+
+// checkCopies checks that every copy of the directory holds the same
+// generation, which maps logs to the given slots.
+func checkCopies(t *testing.T, p *SlotPersistence, want slotMap) {
+	t.Helper()
+	var gen uint64
+	for i := range p.directoryCopies {
+		c := p.directoryCopies[i]
+		d, err := c.read(i > 0)
+		if err != nil {
+			t.Errorf("Copy in slot %d: %v", c.index, err)
+			continue
+		}
+		if i == 0 {
+			gen = d.Generation
+		} else if d.Generation != gen {
+			t.Errorf("Copy in slot %d is generation %d, want %d", c.index, d.Generation, gen)
+		}
+		if diff := cmp.Diff(want, d.Slots); diff != "" {
+			t.Errorf("Copy in slot %d has diff: %s", c.index, diff)
+		}
+	}
+}
+
+func TestDirectoryMirrored(t *testing.T) {
+	p := newTestPersistence(t)
+	if got, want := p.mirrorSlots(), []uint{7, 6}; !cmp.Equal(got, want) {
+		t.Fatalf("mirrorSlots = %v, want %v", got, want)
+	}
+	mustStore(t, p, "one", "one\n1\nroot\n")
+	mustStore(t, p, "two", "two\n1\nroot\n")
+	checkCopies(t, p, slotMap{logfmt.ID("one"): 1, logfmt.ID("two"): 2})
+}
+
+func TestDirectoryRepairedOnRead(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// damage is applied to the copies of a directory holding a single
+		// log.
+		damage  func(t *testing.T, p *SlotPersistence)
+		wantErr bool
+	}{
+		{
+			name: "primary unreadable",
+			damage: func(t *testing.T, p *SlotPersistence) {
+				mustWriteSlot(t, p, 0, []byte("garbage"))
+			},
+		}, {
+			name: "primary empty",
+			damage: func(t *testing.T, p *SlotPersistence) {
+				mustWriteSlot(t, p, 0, nil)
+			},
+		}, {
+			name: "mirror unreadable",
+			damage: func(t *testing.T, p *SlotPersistence) {
+				mustWriteSlot(t, p, p.mirrorSlots()[0], []byte("garbage"))
+			},
+		}, {
+			name: "mirrors out of date",
+			damage: func(t *testing.T, p *SlotPersistence) {
+				// Lose the mirrors' copies of the directory recording the log,
+				// as if power was lost while storing it.
+				for _, c := range p.directoryCopies[1:] {
+					d, err := c.read(true)
+					if err != nil {
+						t.Fatalf("read: %v", err)
+					}
+					d.Generation--
+					d.Slots = slotMap{}
+					b, err := marshalDirectory(d)
+					if err != nil {
+						t.Fatalf("marshalDirectory: %v", err)
+					}
+					mustWriteSlot(t, p, c.index, b)
+				}
+			},
+		}, {
+			name: "no quorum",
+			damage: func(t *testing.T, p *SlotPersistence) {
+				mustWriteSlot(t, p, 0, []byte("garbage"))
+				mustWriteSlot(t, p, p.mirrorSlots()[1], []byte("garbage"))
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := newTestPersistence(t)
+			mustStore(t, p, "log", "log\n1\nroot\n")
+			test.damage(t, p)
+
+			p, err := reopen(t, p)
+			if test.wantErr {
+				if !errors.Is(err, ErrDirectoryUnavailable) {
+					t.Fatalf("Init: %v, want ErrDirectoryUnavailable", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			checkLatest(t, p, "log", "log\n1\nroot\n")
+			checkCopies(t, p, slotMap{logfmt.ID("log"): 1})
+		})
+	}
+}
+
+func TestMirrorSlotsVacated(t *testing.T) {
+	p := newTestPersistence(t)
+	// Assign a log to one of the mirror slots, as a build which predates the
+	// mirrors might have done.
+	id := logfmt.ID("log")
+	writeLegacyDirectory(t, p, versionedDirectory(2, []byte("slots:\n  "+id+": 6\n")))
+	mustWriteSlot(t, p, 6, marshalCheckpoint([]byte("log\n1\nroot\n")))
+
+	p, err := reopen(t, p)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	checkLatest(t, p, "log", "log\n1\nroot\n")
+	checkCopies(t, p, slotMap{id: 1})
+}
+
+func TestEventSlotVacated(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	// Assign a log to the final slot, as a build which predates the event
+	// slot might have done.
+	id := logfmt.ID("log")
+	last := uint(p.part.NumSlots() - 1)
+	writeLegacyDirectory(t, p, versionedDirectory(2, []byte(fmt.Sprintf("slots:\n  %s: %d\n", id, last))))
+	mustWriteSlot(t, p, last, marshalCheckpoint([]byte("log\n1\nroot\n")))
+
+	p, err := reopen(t, p)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	checkLatest(t, p, "log", "log\n1\nroot\n")
+	checkCopies(t, p, slotMap{id: 1})
+	if e, err := p.Events(ctx); err != nil || len(e) != 0 {
+		t.Errorf("Events = %v, %v, want no events", e, err)
+	}
+	if err := p.Reset(ctx, "test"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if e, err := p.Events(ctx); err != nil || len(e) != 1 {
+		t.Errorf("Events after Reset = %v, %v, want one event", e, err)
+	}
+}
+
+func TestReconstructDirectory(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	mustStore(t, p, "one", "one\n1\nroot\n")
+	mustStore(t, p, "two", "two\n5\nroot\n")
+	mustStore(t, p, "three", "three\n1\nroot\n")
+	// Leave a stale copy of a checkpoint behind in a free slot.
+	mustWriteSlot(t, p, 4, marshalCheckpoint([]byte("two\n4\nroot\n")))
+	// Lose all but one copy of the directory.
+	mustWriteSlot(t, p, 0, []byte("garbage"))
+	mustWriteSlot(t, p, p.mirrorSlots()[0], []byte("garbage"))
+	if _, err := reopen(t, p); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Fatalf("Init: %v, want ErrDirectoryUnavailable", err)
+	}
+
+	p = NewSlotPersistence(p.part)
+	if n, err := p.ReconstructDirectory(ctx); err != nil || n != 3 {
+		t.Fatalf("ReconstructDirectory = %d, %v, want 3, nil", n, err)
+	}
+	want := slotMap{logfmt.ID("one"): 1, logfmt.ID("two"): 2, logfmt.ID("three"): 3}
+	checkCopies(t, p, want)
+
+	p, err := reopen(t, p)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	checkLatest(t, p, "one", "one\n1\nroot\n")
+	checkLatest(t, p, "two", "two\n5\nroot\n")
+	checkLatest(t, p, "three", "three\n1\nroot\n")
+	// The stale checkpoint is cleared when its slot is reused.
+	mustStore(t, p, "four", "four\n1\nroot\n")
+	checkLatest(t, p, "four", "four\n1\nroot\n")
+}
+
+func mustWriteSlot(t *testing.T, p *SlotPersistence, i uint, b []byte) {
+	t.Helper()
+	s, err := p.part.Open(i)
+	if err != nil {
+		t.Fatalf("Open(%d): %v", i, err)
+	}
+	if err := s.Write(b); err != nil {
+		t.Fatalf("Write(%d): %v", i, err)
+	}
+}
@@ -19,8 +19,11 @@
 package mmc
 
 import (
+	"context"
 	"runtime"
+	"time"
 
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/ctxerr"
 	"github.com/transparency-dev/armored-witness-os/api/rpc"
 	"github.com/usbarmory/GoTEE/syscall"
 	"github.com/usbarmory/tamago/soc/nxp/usdhc"
@@ -36,8 +39,25 @@ var (
 
 // Device allows writing to one of the USB Armory storage peripherals, hiding some
 // of the sharp edges around DMA etc.
+//
+// Operations may be cancelled or bounded by a deadline via the *Context methods,
+// but note that the applet is suspended while the OS services each RPC, so an
+// operation can only be abandoned in between transfers of at most
+// MaxTransferBytes bytes, never part way through one.
 type Device struct {
 	CardInfo *usdhc.CardInfo
+
+	// Timeout, if non-zero, bounds the time taken by each read or write
+	// operation, in addition to any deadline set on the operation's context.
+	Timeout time.Duration
+}
+
+// opContext returns the context to use for a single operation.
+func (d *Device) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d.Timeout)
 }
 
 // BlockSize returns the size in bytes of the each block in the underlying storage.
@@ -50,14 +70,25 @@ func (d *Device) BlockSize() uint {
 // full blocks are written.
 // Returns the number of blocks written, or an error.
 func (d *Device) WriteBlocks(lba uint, b []byte) (uint, error) {
+	return d.WriteBlocksContext(context.Background(), lba, b)
+}
+
+// WriteBlocksContext behaves like WriteBlocks, but gives up if ctx becomes done
+// before all of the data has been written.
+// In that case, the number of blocks which were written is returned along with
+// an error.
+func (d *Device) WriteBlocksContext(ctx context.Context, lba uint, b []byte) (uint, error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	ctx, cancel := d.opContext(ctx)
+	defer cancel()
 	bs := int(d.BlockSize())
 	if r := len(b) % bs; r != 0 {
 		b = append(b, make([]byte, bs-r)...)
 	}
 	numBlocks := uint(len(b) / bs)
+	written := uint(0)
 	for len(b) > 0 {
 		bl := len(b)
 		if bl > MaxTransferBytes {
@@ -70,13 +101,18 @@ func (d *Device) WriteBlocks(lba uint, b []byte) (uint, error) {
 
 		// Since this could be a long-running operation, we need to play nice with the scheduler.
 		runtime.Gosched()
+		if err := ctxerr.FromContext(ctx); err != nil {
+			klog.Warningf("Abandoning write at block %d after %d of %d blocks: %v", lba, written, numBlocks, err)
+			return written, err
+		}
 
 		if err := syscall.Call("RPC.WriteBlocks", &xfer, nil); err != nil {
 			klog.Infof("syscall.Write(%d, ...) = %v", xfer.LBA, err)
-			return 0, err
+			return written, err
 		}
 		b = b[bl:]
 		lba += uint(bl / bs)
+		written += uint(bl / bs)
 	}
 	return numBlocks, nil
 }
@@ -84,9 +120,17 @@ func (d *Device) WriteBlocks(lba uint, b []byte) (uint, error) {
 // ReadBlocks reads data from the storage device at the given address into b.
 // b must be a multiple of the underlying device's block size.
 func (d *Device) ReadBlocks(lba uint, b []byte) error {
+	return d.ReadBlocksContext(context.Background(), lba, b)
+}
+
+// ReadBlocksContext behaves like ReadBlocks, but gives up if ctx becomes done
+// before all of the data has been read.
+func (d *Device) ReadBlocksContext(ctx context.Context, lba uint, b []byte) error {
 	if len(b) == 0 {
 		return nil
 	}
+	ctx, cancel := d.opContext(ctx)
+	defer cancel()
 	bs := int(d.BlockSize())
 	for len(b) > 0 {
 		bl := len(b)
@@ -100,6 +144,10 @@ func (d *Device) ReadBlocks(lba uint, b []byte) error {
 
 		// Since this could be a long-running operation, we need to play nice with the scheduler.
 		runtime.Gosched()
+		if err := ctxerr.FromContext(ctx); err != nil {
+			klog.Warningf("Abandoning read at block %d: %v", lba, err)
+			return err
+		}
 
 		var readBuf []byte
 		if err := syscall.Call("RPC.Read", xfer, &readBuf); err != nil {
@@ -0,0 +1,61 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/usbarmory/GoTEE/syscall"
+)
+
+// RPMBSectorSize is the size in bytes of the RPMB sector reserved for the
+// applet.
+const RPMBSectorSize = 256
+
+// RPMB provides access to the sector of the eMMC Replay Protected Memory Block
+// partition which the OS reserves for the applet.
+//
+// The OS holds the RPMB authentication key, and performs the authenticated
+// reads and writes on the applet's behalf, so the contents of the sector can't
+// be modified or rolled back by anything other than the applet.
+type RPMB struct{}
+
+// ReadAnchor returns the contents of the applet's RPMB sector.
+func (RPMB) ReadAnchor(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var b []byte
+	if err := syscall.Call("RPC.ReadRPMB", nil, &b); err != nil {
+		return nil, fmt.Errorf("RPC.ReadRPMB: %v", err)
+	}
+	return b, nil
+}
+
+// WriteAnchor replaces the contents of the applet's RPMB sector with b, which
+// must be no larger than RPMBSectorSize.
+func (RPMB) WriteAnchor(ctx context.Context, b []byte) error {
+	if len(b) > RPMBSectorSize {
+		return fmt.Errorf("%d bytes is larger than the RPMB sector size of %d", len(b), RPMBSectorSize)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := syscall.Call("RPC.WriteRPMB", b, nil); err != nil {
+		return fmt.Errorf("RPC.WriteRPMB: %v", err)
+	}
+	return nil
+}
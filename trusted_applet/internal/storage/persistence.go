@@ -20,11 +20,10 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
 	logfmt "github.com/transparency-dev/formats/log"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
 	"k8s.io/klog/v2"
 )
@@ -44,6 +43,11 @@ var (
 // SlotPersistence is an implementation of the witness Persistence
 // interface based on Slots.
 type SlotPersistence struct {
+	// cacheMu serialises access to the firmware cache, which is held in slots
+	// which are never assigned to logs, so that slow cache writes don't hold
+	// up updates to the logs' state.
+	cacheMu sync.Mutex
+
 	// mu protects access to everything below.
 	mu sync.RWMutex
 
@@ -51,21 +55,43 @@ type SlotPersistence struct {
 	// data.
 	part *slots.Partition
 
-	// directorySlot is a reference to the zeroth slot in a partition.
-	// This slot is used to maintain a mapping of log ID to slot index
-	// where state for that log is stored.
-	directorySlot *slots.Slot
-	// directoryWriteToken is the token received when we read the mapping config
-	// from the mapSlot above. It'll be used when we want to store an updated
-	// mapping config.
-	directoryWriteToken uint32
+	// directoryCopies refers to the slots used to maintain a mapping of log
+	// ID to slot index where state for that log is stored. The first is the
+	// zeroth slot in the partition, and the others are its mirrors.
+	directoryCopies []directoryCopy
+	// directoryGeneration is the generation of the directory as last read or
+	// stored.
+	directoryGeneration uint64
+	// directoryStale is set if some copies of the directory are known not to
+	// hold its latest generation.
+	directoryStale bool
+	// directoryVersion is the format version of the directory as read from
+	// storage, or as upgraded by any migrations which have been applied.
+	directoryVersion uint32
 
 	// idToSlot maintains the mapping from LogID to slot index used to store
 	// checkpoints from that log.
 	idToSlot slotMap
 
+	// retired maps the IDs of logs which are no longer being witnessed to the
+	// time they were retired. Once a grace period has passed the slots assigned
+	// to these logs may be reclaimed by CollectGarbage.
+	retired map[string]time.Time
+	// releases maps the names of recently found firmware releases to the time
+	// at which they were first found, see FoundRelease.
+	releases map[string]time.Time
+
 	// freeSlots is a list of unused slot indices available to be mapped to logIDs.
 	freeSlots []uint
+
+	// history bounds the previous checkpoints retained for each log.
+	history HistoryPolicy
+
+	// rollback, if set, protects the stored state against being rolled back.
+	rollback *rollbackGuard
+	// rollbackErr is set if the stored state was found to have been rolled
+	// back, in which case checkpoints are neither served nor updated.
+	rollbackErr error
 }
 
 // slotMap defines the structure of the mapping config stored in slot zero.
@@ -78,26 +104,111 @@ func NewSlotPersistence(part *slots.Partition) *SlotPersistence {
 	return &SlotPersistence{
 		part:     part,
 		idToSlot: make(map[string]uint),
+		retired:  make(map[string]time.Time),
+		releases: make(map[string]time.Time),
 	}
 }
 
 // Init sets up the persistence layer. This should be idempotent,
 // and will be called once per process startup.
-func (p *SlotPersistence) Init(_ context.Context) error {
+//
+// If a rollback anchor has been set and the stored state doesn't match it,
+// an error wrapping ErrRollback is returned, and the persistence will refuse
+// to serve or update checkpoints until it's Reset.
+func (p *SlotPersistence) Init(ctx context.Context) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	s, err := p.part.Open(mappingConfigSlot)
-	if err != nil {
-		return fmt.Errorf("failed to open mapping slot: %v", err)
+	if err := p.openDirectory(ctx); err != nil {
+		return err
+	}
+	return p.checkRollback(ctx)
+}
+
+// openDirectory opens the directory slot and reads the logID → slot mapping
+// stored in it.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) openDirectory(ctx context.Context) error {
+	if err := p.openDirectoryCopies(); err != nil {
+		return err
 	}
-	p.directorySlot = s
 	if err := p.populateMap(); err != nil {
-		return fmt.Errorf("failed to populate logID → slot map: %v", err)
+		return fmt.Errorf("failed to populate logID → slot map: %w", err)
+	}
+	if err := p.recoverTransaction(ctx); err != nil {
+		return fmt.Errorf("failed to recover interrupted transaction: %w", err)
+	}
+	if err := p.applyMigrations(); err != nil {
+		return fmt.Errorf("failed to migrate storage: %v", err)
+	}
+	if err := p.vacateReservedSlots(); err != nil {
+		return fmt.Errorf("failed to vacate reserved slots: %v", err)
+	}
+	if p.directoryStale {
+		klog.Warningf("Repairing copies of the directory")
+		// The directory has been read from a majority of its copies, so it's
+		// safe to carry on even if they can't be repaired right now.
+		if err := p.storeDirectory(); err != nil {
+			klog.Errorf("Failed to repair directory: %v", err)
+		}
 	}
 	return nil
 }
 
+// Reset erases all witness state held in storage, and re-creates an empty
+// logID → slot directory.
+//
+// The reset is recorded, along with the provided reason, in the event slot,
+// which retains the history of previous resets.
+// If some slots could not be erased, the directory is still re-created and the
+// returned error describes the slots which failed.
+// WARNING: Data Loss!
+func (p *SlotPersistence) Reset(ctx context.Context, reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	klog.Warningf("Resetting witness storage: %s", reason)
+	events, err := p.readEvents()
+	if err != nil {
+		klog.Warningf("Failed to read event history, it will be lost: %v", err)
+	}
+
+	eraseErr := p.part.Erase()
+	if eraseErr != nil {
+		klog.Errorf("Failed to erase partition: %v", eraseErr)
+	}
+	// Reopening the now empty directory will re-create it using the current
+	// format version.
+	if err := p.openDirectory(ctx); err != nil {
+		return fmt.Errorf("failed to re-create directory after erase: %v", err)
+	}
+	if p.rollback != nil {
+		// Some slots may have survived a failed erase, so anchor whatever is
+		// actually there.
+		stored, err := p.storedState(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read state after erase: %v", err)
+		}
+		if err := p.rollback.reset(ctx, stored); err != nil {
+			return err
+		}
+	}
+	p.rollbackErr = nil
+
+	e := Event{
+		Time:   time.Now(),
+		Type:   EventReset,
+		Detail: reason,
+	}
+	if eraseErr != nil {
+		e.Detail = fmt.Sprintf("%s (erase failed: %v)", reason, eraseErr)
+	}
+	if err := p.recordEvent(events, e); err != nil {
+		klog.Errorf("Failed to record reset event: %v", err)
+	}
+	return eraseErr
+}
+
 // marshalCheckpoint knows how to serialise a checkpoint for storage by the
 // persistence.
 func marshalCheckpoint(cpRaw []byte) []byte {
@@ -117,6 +228,16 @@ func unmarshalCheckpoint(b []byte) ([]byte, error) {
 	if b, ok := bytes.CutPrefix(b, rawRecordMagic); ok {
 		return b, nil
 	}
+	if bytes.HasPrefix(b, historyRecordMagic) {
+		h, err := unmarshalHistory(b, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal history: %v", err)
+		}
+		if len(h) == 0 {
+			return nil, nil
+		}
+		return h[0].Checkpoint, nil
+	}
 	// Othersize fall-back to reading legacy encoding.
 	lr := struct {
 		Checkpoint []byte
@@ -130,99 +251,147 @@ func unmarshalCheckpoint(b []byte) ([]byte, error) {
 }
 
 // Latest returns the last recorded checkpoint for the given logID, or an
-// `codes.NotFound` error if no such checkpoint has been recorded.
+// error wrapping ErrNotFound, which carries the `codes.NotFound` gRPC status,
+// if no such checkpoint has been recorded.
 //
 // Implements the omniwitness LogPersistence interface.
-func (p *SlotPersistence) Latest(_ context.Context, origin string) ([]byte, error) {
+func (p *SlotPersistence) Latest(ctx context.Context, origin string) ([]byte, error) {
 	logID := logfmt.ID(origin)
 	i, err := p.logSlot(logID, false)
 	if err != nil {
 		return nil, err
 	}
-	s, err := p.part.Open(i)
+	s, err := p.part.OpenContext(ctx, i)
 	if err != nil {
 		return nil, fmt.Errorf("internal error opening slot %d associated with log ID %q: %v", i, logID, err)
 	}
 	b, _, err := s.Read()
 	if err != nil {
 		klog.Warningf("Read failed: %v", err)
-		return nil, fmt.Errorf("failed to read data: %v", err)
+		return nil, fmt.Errorf("failed to read data: %w", readErr(ctx, err))
 	}
 	if len(b) == 0 {
-		return nil, status.Error(codes.NotFound, "no checkpoint for log")
+		return nil, fmt.Errorf("%w: no checkpoint for log", ErrNotFound)
 	}
-	return unmarshalCheckpoint(b)
+	cp, err := unmarshalCheckpoint(b)
+	if err != nil {
+		return nil, corrupt(i, err)
+	}
+	return cp, nil
 }
 
 // Update allows for storing a new checkpoint for a given LogID.
 //
 // Implements the omniwitness LogPersistence interface.
-func (p *SlotPersistence) Update(_ context.Context, origin string, f func(current []byte) ([]byte, error)) error {
+func (p *SlotPersistence) Update(ctx context.Context, origin string, f func(current []byte) ([]byte, error)) error {
 	logID := logfmt.ID(origin)
+	if done, err := p.createLog(ctx, logID, f); done {
+		return err
+	}
 	i, err := p.logSlot(logID, true)
 	if err != nil {
 		return err
 	}
-	s, err := p.part.Open(i)
+	s, err := p.part.OpenContext(ctx, i)
 	if err != nil {
 		return fmt.Errorf("internal error opening slot %d associated with log ID %q: %v", i, logID, err)
 	}
 	b, t, err := s.Read()
 	if err != nil {
 		klog.Warningf("Read failed: %v", err)
-		return fmt.Errorf("failed to read data: %v", err)
+		return fmt.Errorf("failed to read data: %w", readErr(ctx, err))
 	}
 
 	currCP, err := unmarshalCheckpoint(b)
 	if err != nil {
-		return fmt.Errorf("unmarshalCheckpoint: %v", err)
+		return corrupt(i, fmt.Errorf("unmarshalCheckpoint: %v", err))
 	}
 
 	newCP, err := f(currCP)
 	if err != nil {
 		return err
 	}
-	if err := s.CheckAndWrite(t, marshalCheckpoint(newCP)); err != nil {
+	p.mu.RLock()
+	h := p.history
+	p.mu.RUnlock()
+	r, err := h.appendHistory(b, t, newCP)
+	if err != nil {
+		return fmt.Errorf("failed to update history: %v", err)
+	}
+	if err := p.writeCheckpoint(ctx, logID, s, t, r, newCP); err != nil {
 		klog.Warningf("Write failed: %v", err)
-		return fmt.Errorf("failed to write data: %v", err)
+		return fmt.Errorf("failed to write data: %w", err)
 	}
 	return nil
 }
 
+// readErr returns the error to report when a slot couldn't be read.
+// Slots which fail to open are left unopened, so if that was because ctx is
+// done, we report that rather than the less helpful error from Read.
+func readErr(ctx context.Context, err error) error {
+	if cErr := slots.ContextErr(ctx); cErr != nil {
+		return cErr
+	}
+	return err
+}
+
+// Flush ensures that all checkpoints passed to Update have been durably stored.
+// This only has an effect if write-back has been enabled on the underlying
+// partition, in which case Update may return before the checkpoint is stored.
+func (p *SlotPersistence) Flush(_ context.Context) error {
+	return p.part.Flush()
+}
+
 // logSlot looks up the slot assigned to a given logID, optionally creating a mapping if there isn't
 // a slot currently assigned.
 func (p *SlotPersistence) logSlot(logID string, create bool) (uint, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.rollbackErr != nil {
+		return 0, p.rollbackErr
+	}
 	i, ok := p.idToSlot[logID]
 	if !ok {
 		if !create {
-			return 0, status.Error(codes.NotFound, "no slot for log")
+			return 0, fmt.Errorf("%w: no slot for log", ErrNotFound)
 		}
 		var err error
 		i, err = p.addLog(logID)
 		if err != nil {
 			klog.Warningf("Failed to add mapping: %q", err)
-			return 0, fmt.Errorf("unable to assign slot for log ID %q: %v", logID, err)
+			return 0, fmt.Errorf("unable to assign slot for log ID %q: %w", logID, err)
 		}
 		klog.V(2).Infof("Added mapping %q -> %d", logID, i)
 	}
+	if _, retired := p.retired[logID]; retired && create {
+		// The log is being updated, so it's clearly not retired after all.
+		klog.Infof("Reinstating retired log ID %q", logID)
+		delete(p.retired, logID)
+		if err := p.storeDirectory(); err != nil {
+			klog.Warningf("Failed to store directory after reinstating log ID %q: %v", logID, err)
+		}
+	}
 	return i, nil
 }
 
 // populateMap reads the logID -> slot mapping from storage.
 // Must be called with p.mu write-locked.
 func (p *SlotPersistence) populateMap() error {
-	b, t, err := p.directorySlot.Read()
+	d, err := p.readDirectory()
 	if err != nil {
-		return fmt.Errorf("failed to read persistence mapping: %v", err)
-	}
-	if err := yaml.Unmarshal(b, &p.idToSlot); err != nil {
-		return fmt.Errorf("failed to unmarshal persistence mapping: %v", err)
+		return fmt.Errorf("failed to read persistence mapping: %w", err)
 	}
-	// We read the logID<->Slot config, so save the token for if/when we want to
-	// store an updated mapping.
-	p.directoryWriteToken = t
+	return p.setDirectory(d)
+}
+
+// setDirectory replaces the in-memory logID -> slot mapping with d.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) setDirectory(d directory) error {
+	p.idToSlot = d.Slots
+	p.retired = d.Retired
+	p.releases = d.Releases
+	p.directoryVersion = d.Version
+	p.directoryGeneration = d.Generation
 
 	// Precalculate the list of available slots.
 	slotState := make([]bool, p.part.NumSlots())
@@ -230,11 +399,20 @@ func (p *SlotPersistence) populateMap() error {
 		if idx == mappingConfigSlot {
 			return errors.New("internal-error, reserved slot 0 has been used")
 		}
+		// Logs may still be assigned to the event slot, or the slots reserved
+		// for mirrors or transactions, by older builds or before the partition
+		// was grown, in which case vacateReservedSlots will move them.
 		slotState[idx] = true
 	}
 
-	// Slot 0 is reserved for the mapping config, so mark it used here:
+	// Slot 0 and its mirrors are reserved for the mapping config, the final
+	// slot for events, and others for transactions and the firmware cache,
+	// so mark them used here:
 	slotState[mappingConfigSlot] = true
+	slotState[p.eventSlot()] = true
+	for _, idx := range p.reservedSlots() {
+		slotState[idx] = true
+	}
 
 	p.freeSlots = make([]uint, 0, p.part.NumSlots())
 	for idx, used := range slotState {
@@ -245,22 +423,6 @@ func (p *SlotPersistence) populateMap() error {
 	return nil
 }
 
-// storeDirectory writes the current logID -> slot map to storage.
-// Must be called with p.mu at leaest read-locked.
-func (p *SlotPersistence) storeDirectory() error {
-	smRaw, err := yaml.Marshal(p.idToSlot)
-	if err != nil {
-		return fmt.Errorf("failed to marshal mapping: %v", err)
-	}
-	if err := p.directorySlot.CheckAndWrite(p.directoryWriteToken, smRaw); err != nil {
-		return fmt.Errorf("failed to store mapping: %v", err)
-	}
-	// TODO(al): CheckAndWrite should return the next token rather than us knowing
-	// how the token changes after a successful write.
-	p.directoryWriteToken++
-	return nil
-}
-
 // addLog assigns a slot to a new log ID.
 // Must be called with p.mu write-locked.
 func (p *SlotPersistence) addLog(id string) (uint, error) {
@@ -268,9 +430,14 @@ func (p *SlotPersistence) addLog(id string) (uint, error) {
 		return idx, nil
 	}
 	if len(p.freeSlots) == 0 {
-		return 0, errors.New("no free slot available")
+		return 0, ErrPartitionFull
 	}
 	f := p.freeSlots[0]
+	// Slots reclaimed from retired logs are tombstoned before being freed, but
+	// make sure we never hand out a slot which still holds state.
+	if err := p.clearSlot(f); err != nil {
+		return 0, err
+	}
 	p.freeSlots = p.freeSlots[1:]
 	p.idToSlot[id] = f
 	if err := p.storeDirectory(); err != nil {
@@ -279,3 +446,27 @@ func (p *SlotPersistence) addLog(id string) (uint, error) {
 	klog.V(1).Infof("Added new mapping %q -> %d", id, f)
 	return f, nil
 }
+
+// clearSlot ensures that the given slot holds no data.
+func (p *SlotPersistence) clearSlot(i uint) error {
+	s, err := p.part.Open(i)
+	if err != nil {
+		return fmt.Errorf("failed to open slot %d: %v", i, err)
+	}
+	b, t, err := s.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read slot %d: %v", i, err)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	klog.Warningf("Clearing stale data from free slot %d", i)
+	if _, err := s.CheckAndWrite(t, nil); err != nil {
+		return fmt.Errorf("failed to clear slot %d: %v", i, err)
+	}
+	// This must be durable before the slot is assigned to another log.
+	if err := s.Sync(); err != nil {
+		return fmt.Errorf("failed to sync cleared slot %d: %v", i, err)
+	}
+	return nil
+}
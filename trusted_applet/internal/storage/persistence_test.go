@@ -16,8 +16,18 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+	logfmt "github.com/transparency-dev/formats/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
 )
 
@@ -86,3 +96,130 @@ func marshalOldCheckpoint(t *testing.T, cp []byte) []byte {
 	}
 	return y
 }
+
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+
+	origins := []string{"log1", "log2"}
+	for _, o := range origins {
+		if err := p.Update(ctx, o, func([]byte) ([]byte, error) { return []byte("CP for " + o), nil }); err != nil {
+			t.Fatalf("Update(%q): %v", o, err)
+		}
+	}
+
+	for i, reason := range []string{"first", "second"} {
+		if err := p.Reset(ctx, reason); err != nil {
+			t.Fatalf("Reset: %v", err)
+		}
+		for _, o := range origins {
+			if _, err := p.Latest(ctx, o); status.Code(err) != codes.NotFound {
+				t.Errorf("Latest(%q): %v, want NotFound", o, err)
+			}
+		}
+		events, err := p.Events(ctx)
+		if err != nil {
+			t.Fatalf("Events: %v", err)
+		}
+		if got, want := len(events), i+1; got != want {
+			t.Fatalf("Got %d events, want %d", got, want)
+		}
+		if e := events[i]; e.Type != EventReset || e.Detail != reason {
+			t.Errorf("Got event %+v, want type %q detail %q", e, EventReset, reason)
+		}
+	}
+
+	// Check that the directory survives a "reboot" and is usable.
+	p = NewSlotPersistence(p.part)
+	if err := p.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := p.Update(ctx, "log3", func([]byte) ([]byte, error) { return []byte("CP"), nil }); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, err := p.Latest(ctx, "log3"); err != nil || string(got) != "CP" {
+		t.Fatalf("Latest: %q, %v, want %q", got, err, "CP")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+
+	_, err := p.Latest(ctx, "missing")
+	if !errors.Is(err, ErrNotFound) || status.Code(err) != codes.NotFound {
+		t.Errorf("Latest for missing log: %v, want ErrNotFound with codes.NotFound", err)
+	}
+
+	mustStore(t, p, "log", "log\n1\nroot\n")
+	slot := p.idToSlot[logfmt.ID("log")]
+	mustWriteSlot(t, p, slot, []byte("garbage"))
+	_, err = p.Latest(ctx, "log")
+	var ce *slots.CorruptError
+	if !errors.Is(err, ErrCorrupt) || !errors.As(err, &ce) || ce.Slot != int(slot) {
+		t.Errorf("Latest for corrupt log: %v, want ErrCorrupt for slot %d", err, slot)
+	}
+
+	// Slots 1 to 4 are free, and one of them has just been used.
+	for i := range 3 {
+		mustStore(t, p, fmt.Sprint(i), "CP")
+	}
+	if err := p.Update(ctx, "one too many", func([]byte) ([]byte, error) { return []byte("CP"), nil }); !errors.Is(err, ErrPartitionFull) {
+		t.Errorf("Update with no free slots: %v, want ErrPartitionFull", err)
+	}
+}
+
+// newTestPersistence returns an initialised SlotPersistence backed by an
+// in-memory partition.
+func newTestPersistence(t *testing.T) *SlotPersistence {
+	t.Helper()
+	const (
+		numSlots   = 9
+		slotBlocks = 4
+	)
+	md := testonly.NewMemDev(t, numSlots*slotBlocks)
+	geo := slots.Geometry{Length: numSlots * slotBlocks}
+	for range numSlots {
+		geo.SlotLengths = append(geo.SlotLengths, slotBlocks)
+	}
+	part, err := slots.OpenPartition(md, geo)
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	p := NewSlotPersistence(part)
+	if err := p.Init(context.Background()); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return p
+}
+
+func TestWriteBackKeepsDirectoryDurable(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	p.part.EnableWriteBack(ctx, time.Hour)
+
+	for i := range 5 {
+		if err := p.Update(ctx, "log", func([]byte) ([]byte, error) { return []byte(fmt.Sprintf("CP %d", i)), nil }); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	// Only the transaction adding the new log, which stores the directory
+	// and first checkpoint, should have been written through, bypassing
+	// write-back, with the later checkpoints coalesced in memory.
+	want := slots.WriteBackStats{Writes: 4, Coalesced: 3}
+	if diff := cmp.Diff(want, p.part.WriteBackStats()); diff != "" {
+		t.Errorf("Got stats diff before flush: %s", diff)
+	}
+
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	want.Flushes++
+	if diff := cmp.Diff(want, p.part.WriteBackStats()); diff != "" {
+		t.Errorf("Got stats diff after flush: %s", diff)
+	}
+	cp, err := p.Latest(ctx, "log")
+	if err != nil || string(cp) != "CP 4" {
+		t.Errorf("Latest = %q, %v, want CP 4", cp, err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	logfmt "github.com/transparency-dev/formats/log"
+	"k8s.io/klog/v2"
+)
+
+// ReconstructDirectory rebuilds the logID → slot directory from the
+// checkpoints stored in the partition, and returns the number of logs found.
+// This is intended to recover storage for which Init fails with
+// ErrDirectoryUnavailable, and can be called in place of Init.
+//
+// Every slot which isn't reserved is read, and any checkpoint found in it is
+// assigned to the log ID derived from its origin line. If more than one slot
+// holds a checkpoint for the same log, e.g. following an interrupted move, the
+// one with the largest tree size is used. Which logs had been retired can't be
+// recovered, so they're treated as live until they're retired again.
+//
+// Like Init, if a rollback anchor has been set and the reconstructed state
+// doesn't match it, an error wrapping ErrRollback is returned.
+func (p *SlotPersistence) ReconstructDirectory(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.openDirectoryCopies(); err != nil {
+		return 0, err
+	}
+	// Carry on from the latest generation of any readable copies, so that the
+	// reconstructed directory supersedes them.
+	d := directory{Version: directoryFormatVersion, Slots: make(slotMap), Retired: make(map[string]time.Time), Releases: make(map[string]time.Time)}
+	for i := range p.directoryCopies {
+		if c, err := p.directoryCopies[i].read(i > 0); err == nil && c.Generation > d.Generation {
+			d.Generation = c.Generation
+		}
+	}
+
+	sizes := make(map[string]uint64)
+	for i := uint(0); i < uint(p.part.NumSlots()); i++ {
+		if i == mappingConfigSlot || i == p.eventSlot() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		origin, size, err := p.readSlotCheckpoint(i)
+		if err != nil {
+			klog.Warningf("Skipping slot %d: %v", i, err)
+			continue
+		}
+		if origin == "" {
+			continue
+		}
+		id := logfmt.ID(origin)
+		if j, ok := d.Slots[id]; ok {
+			klog.Warningf("Slots %d and %d both hold checkpoints for origin %q", j, i, origin)
+			if sizes[id] >= size {
+				continue
+			}
+		}
+		d.Slots[id], sizes[id] = i, size
+	}
+
+	if err := p.setDirectory(d); err != nil {
+		return 0, err
+	}
+	if err := p.vacateReservedSlots(); err != nil {
+		return 0, fmt.Errorf("failed to vacate reserved slots: %v", err)
+	}
+	if err := p.storeDirectory(); err != nil {
+		return 0, fmt.Errorf("failed to store reconstructed directory: %v", err)
+	}
+	klog.Infof("Reconstructed directory holding %d logs", len(d.Slots))
+	return len(d.Slots), p.checkRollback(ctx)
+}
+
+// readSlotCheckpoint returns the origin and tree size of the checkpoint stored
+// in the given slot, or an empty origin if the slot doesn't hold a checkpoint.
+func (p *SlotPersistence) readSlotCheckpoint(i uint) (string, uint64, error) {
+	s, err := p.part.Open(i)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open slot: %v", err)
+	}
+	b, _, err := s.Read()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read slot: %v", err)
+	}
+	if len(b) == 0 || bytes.HasPrefix(b, directoryMagic) || bytes.HasPrefix(b, firmwareIndexMagic) || bytes.HasPrefix(b, firmwareChunkMagic) {
+		// Either empty, a stale copy of the directory, or part of the
+		// firmware cache.
+		return "", 0, nil
+	}
+	cp, err := unmarshalCheckpoint(b)
+	if err != nil {
+		return "", 0, err
+	}
+	return checkpointHead(cp)
+}
+
+// checkpointHead returns the origin and tree size of a stored checkpoint.
+// The checkpoint's signatures aren't verified.
+func checkpointHead(cp []byte) (string, uint64, error) {
+	lines := bytes.SplitN(cp, []byte("\n"), 3)
+	if len(lines) < 3 || len(lines[0]) == 0 {
+		return "", 0, errors.New("malformed checkpoint")
+	}
+	size, err := strconv.ParseUint(string(lines[1]), 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed checkpoint size: %v", err)
+	}
+	return string(lines[0]), size, nil
+}
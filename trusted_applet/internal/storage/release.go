@@ -0,0 +1,62 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// maxReleases is the number of firmware releases whose first-found times are
+// remembered by FoundRelease.
+const maxReleases = 8
+
+// ReleaseFound returns the time at which the firmware release with the given
+// name was first found, as recorded by FoundRelease, and whether it has been.
+func (p *SlotPersistence) ReleaseFound(_ context.Context, release string) (time.Time, bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	t, ok := p.releases[release]
+	return t, ok, nil
+}
+
+// FoundRelease records that the firmware release with the given name was found
+// at time t, unless it has been found before, and returns the time at which it
+// was first found.
+//
+// These times are stored in the directory, so that they survive restarts.
+// Only the most recently found releases are remembered.
+func (p *SlotPersistence) FoundRelease(_ context.Context, release string, t time.Time) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if found, ok := p.releases[release]; ok {
+		return found, nil
+	}
+	p.releases[release] = t
+	for len(p.releases) > maxReleases {
+		oldest := ""
+		for r, f := range p.releases {
+			if oldest == "" || f.Before(p.releases[oldest]) {
+				oldest = r
+			}
+		}
+		delete(p.releases, oldest)
+	}
+	if err := p.storeDirectory(); err != nil {
+		delete(p.releases, release)
+		return time.Time{}, err
+	}
+	return t, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFoundRelease(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	if _, ok, err := p.ReleaseFound(ctx, "OS 1.0.0"); err != nil || ok {
+		t.Fatalf("ReleaseFound before FoundRelease = %t, %v, want false", ok, err)
+	}
+	if got, err := p.FoundRelease(ctx, "OS 1.0.0", start); err != nil || !got.Equal(start) {
+		t.Fatalf("FoundRelease = %v, %v, want %v", got, err, start)
+	}
+	// Finding the release again mustn't reset its age, including after a
+	// restart.
+	if got, err := p.FoundRelease(ctx, "OS 1.0.0", start.Add(time.Hour)); err != nil || !got.Equal(start) {
+		t.Fatalf("Second FoundRelease = %v, %v, want %v", got, err, start)
+	}
+	p, err := reopen(t, p)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got, ok, err := p.ReleaseFound(ctx, "OS 1.0.0"); err != nil || !ok || !got.Equal(start) {
+		t.Fatalf("ReleaseFound after restart = %v, %t, %v, want %v", got, ok, err, start)
+	}
+
+	// Only the most recent releases are remembered.
+	for i := range maxReleases {
+		if _, err := p.FoundRelease(ctx, fmt.Sprintf("applet 1.%d.0", i), start.Add(time.Duration(i+1)*time.Hour)); err != nil {
+			t.Fatalf("FoundRelease(%d): %v", i, err)
+		}
+	}
+	if _, ok, _ := p.ReleaseFound(ctx, "OS 1.0.0"); ok {
+		t.Error("Oldest release is still remembered")
+	}
+	if _, ok, _ := p.ReleaseFound(ctx, "applet 1.0.0"); !ok {
+		t.Error("Recent release was forgotten")
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remap provides a block device layer which can verify writes by
+// reading them back, and which moves blocks that fail verification to spare
+// blocks, so that an ageing eMMC degrades gracefully rather than silently
+// corrupting the journals stored on it.
+package remap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// ErrNoSpares is returned when a block fails verification and there are
+	// no spare blocks left to remap it to.
+	ErrNoSpares = errors.New("no spare blocks left")
+
+	// errVerify is returned when the data read back from a block doesn't
+	// match what was written to it.
+	errVerify = errors.New("data read back does not match data written")
+)
+
+// Geometry describes the blocks reserved on a device for remapping.
+type Geometry struct {
+	// Start is the address of the first of the two blocks which hold the
+	// remap table. These are immediately followed by the spare blocks.
+	Start uint
+	// Spares is the number of spare blocks.
+	Spares uint
+}
+
+// Stats holds counts of a device's activity and errors.
+type Stats struct {
+	// Reads and Writes are the numbers of reads and writes made through the
+	// device.
+	Reads, Writes uint64
+	// ReadErrors and WriteErrors are the numbers of operations on the
+	// underlying device which failed.
+	ReadErrors, WriteErrors uint64
+	// VerifyFailures is the number of block writes which read back
+	// differently to what was written.
+	VerifyFailures uint64
+	// Remapped is the number of blocks currently remapped to spares.
+	Remapped uint64
+	// SparesFree is the number of spare blocks which are still unused.
+	SparesFree uint64
+}
+
+// Device is a block device layered over another, which optionally verifies
+// every write, and redirects accesses to blocks which have failed
+// verification to spare blocks.
+//
+// A block which fails verification is rewritten once in place, since the
+// failure may have been transient, and is only remapped if that fails too.
+// The remap table is consulted for every access regardless of whether
+// verification is enabled, so blocks stay remapped if it's later disabled.
+type Device struct {
+	dev slots.BlockReaderWriter
+	geo Geometry
+
+	// mu guards everything below, and serialises writes so that the remap
+	// table is only updated by one write at a time.
+	mu     sync.Mutex
+	verify bool
+	table  table
+	stats  Stats
+}
+
+// New returns a device layered over dev, which stores its remap table and
+// spare blocks in the blocks described by geo.
+// The blocks reserved by geo must not be used for anything else.
+func New(ctx context.Context, dev slots.BlockReaderWriter, geo Geometry) (*Device, error) {
+	bs := dev.BlockSize()
+	if m := maxTableEntries(bs); geo.Spares > m {
+		return nil, fmt.Errorf("%d spare blocks is more than the %d remappings which fit in the table", geo.Spares, m)
+	}
+	d := &Device{dev: dev, geo: geo}
+
+	b := make([]byte, 2*bs)
+	if err := readBlocks(ctx, dev, geo.Start, b); err != nil {
+		return nil, fmt.Errorf("failed to read remap table: %v", err)
+	}
+	found := false
+	var errs []error
+	for i := range uint(2) {
+		t, err := unmarshalTable(b[i*bs : (i+1)*bs])
+		if err == nil {
+			err = d.checkTable(t)
+		}
+		if err != nil {
+			if err != errNoTable {
+				klog.Warningf("Remap table copy at block %d is invalid: %v", geo.Start+i, err)
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if !found || t.Generation > d.table.Generation {
+			d.table, found = t, true
+		}
+	}
+	if !found {
+		if len(errs) > 0 {
+			// Carrying on would silently expose the stale data in remapped
+			// blocks.
+			return nil, fmt.Errorf("no valid copy of the remap table: %v", errors.Join(errs...))
+		}
+		d.table = table{Blocks: make(map[uint]uint)}
+	}
+	d.stats.Remapped = uint64(len(d.table.Blocks))
+	d.stats.SparesFree = uint64(geo.Spares - uint(d.table.NextSpare))
+	if len(d.table.Blocks) > 0 {
+		klog.Infof("%d blocks are remapped, %d spares remain", len(d.table.Blocks), d.stats.SparesFree)
+	}
+	return d, nil
+}
+
+// checkTable returns an error if t refers to blocks outside the spare area.
+func (d *Device) checkTable(t table) error {
+	if uint(t.NextSpare) > d.geo.Spares {
+		return fmt.Errorf("next spare %d is beyond the %d spare blocks", t.NextSpare, d.geo.Spares)
+	}
+	for f, s := range t.Blocks {
+		if s < d.spare(0) || s >= d.spare(uint(t.NextSpare)) {
+			return fmt.Errorf("block %d is remapped to block %d, which is not an allocated spare", f, s)
+		}
+	}
+	return nil
+}
+
+// spare returns the address of the i-th spare block.
+func (d *Device) spare(i uint) uint {
+	return d.geo.Start + 2 + i
+}
+
+// SetVerify enables or disables verification of writes.
+func (d *Device) SetVerify(v bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.verify = v
+}
+
+// Stats returns the device's activity and error counts.
+func (d *Device) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// BlockSize returns the block size of the underlying device.
+func (d *Device) BlockSize() uint {
+	return d.dev.BlockSize()
+}
+
+// ReadBlocks reads len(b) bytes into b from contiguous blocks starting at the
+// given block address.
+func (d *Device) ReadBlocks(lba uint, b []byte) error {
+	return d.ReadBlocksContext(context.Background(), lba, b)
+}
+
+// ReadBlocksContext behaves like ReadBlocks, but gives up if ctx becomes done.
+func (d *Device) ReadBlocksContext(ctx context.Context, lba uint, b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	bs := d.dev.BlockSize()
+	n := uint(len(b)) / bs
+	if err := d.checkRange(lba, n); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Reads++
+	if err := d.read(ctx, lba, b); err != nil {
+		return err
+	}
+	for f, s := range d.table.Blocks {
+		if f < lba || f >= lba+n {
+			continue
+		}
+		if err := d.read(ctx, s, b[(f-lba)*bs:(f-lba+1)*bs]); err != nil {
+			return fmt.Errorf("failed to read block %d from spare block %d: %v", f, s, err)
+		}
+	}
+	return nil
+}
+
+// WriteBlocks writes the data in b to the blocks starting at the given block
+// address, verifying it if enabled.
+// If the final block to be written is partial, it will be padded with zeroes
+// to ensure that full blocks are written.
+// Returns the number of blocks written, or an error.
+func (d *Device) WriteBlocks(lba uint, b []byte) (uint, error) {
+	return d.WriteBlocksContext(context.Background(), lba, b)
+}
+
+// WriteBlocksContext behaves like WriteBlocks, but gives up if ctx becomes
+// done, in which case some, but not all, of the blocks may have been written.
+func (d *Device) WriteBlocksContext(ctx context.Context, lba uint, b []byte) (uint, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	bs := d.dev.BlockSize()
+	if r := uint(len(b)) % bs; r != 0 {
+		b = append(b, make([]byte, bs-r)...)
+	}
+	n := uint(len(b)) / bs
+	if err := d.checkRange(lba, n); err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Writes++
+
+	// Write runs of blocks which aren't remapped in one go, and remapped
+	// blocks individually to their spares.
+	for i := uint(0); i < n; {
+		j := i + 1
+		target, remapped := d.table.Blocks[lba+i]
+		if !remapped {
+			target = lba + i
+			for ; j < n; j++ {
+				if _, ok := d.table.Blocks[lba+j]; ok {
+					break
+				}
+			}
+		}
+		if err := d.writeRun(ctx, lba+i, target, b[i*bs:j*bs]); err != nil {
+			return i, err
+		}
+		i = j
+	}
+	return n, nil
+}
+
+// writeRun writes b to the blocks starting at target, which hold the data of
+// the blocks starting at lba, and verifies the write if enabled.
+func (d *Device) writeRun(ctx context.Context, lba, target uint, b []byte) error {
+	if err := d.write(ctx, target, b); err != nil {
+		return err
+	}
+	if !d.verify {
+		return nil
+	}
+	bs := d.dev.BlockSize()
+	got := make([]byte, len(b))
+	if err := d.read(ctx, target, got); err != nil {
+		return fmt.Errorf("failed to read back write: %v", err)
+	}
+	for i := uint(0); i < uint(len(b))/bs; i++ {
+		want := b[i*bs : (i+1)*bs]
+		if bytes.Equal(got[i*bs:(i+1)*bs], want) {
+			continue
+		}
+		d.stats.VerifyFailures++
+		klog.Warningf("Write to block %d failed verification", target+i)
+		if err := d.rewrite(ctx, lba+i, target+i, want); err != nil {
+			return fmt.Errorf("failed to write block %d: %w", lba+i, err)
+		}
+	}
+	return nil
+}
+
+// rewrite retries a write of a single block, whose data is held in target,
+// which has failed verification, and remaps the block to a spare if the retry
+// also fails.
+func (d *Device) rewrite(ctx context.Context, lba, target uint, b []byte) error {
+	err := d.writeVerified(ctx, target, b)
+	if err == nil {
+		return nil
+	}
+	if err := slots.ContextErr(ctx); err != nil {
+		return err
+	}
+	klog.Warningf("Retried write to block %d failed: %v", target, err)
+	for uint(d.table.NextSpare) < d.geo.Spares {
+		s := d.spare(uint(d.table.NextSpare))
+		d.table.NextSpare++
+		d.stats.SparesFree--
+		if err := d.writeVerified(ctx, s, b); err != nil {
+			if err := slots.ContextErr(ctx); err != nil {
+				return err
+			}
+			klog.Warningf("Write to spare block %d failed: %v", s, err)
+			continue
+		}
+		// Only point the table at the spare once it holds the data.
+		old := d.table.Blocks[lba]
+		d.table.Blocks[lba] = s
+		if err := d.storeTable(ctx); err != nil {
+			if old != 0 {
+				d.table.Blocks[lba] = old
+			} else {
+				delete(d.table.Blocks, lba)
+			}
+			return err
+		}
+		d.stats.Remapped = uint64(len(d.table.Blocks))
+		klog.Infof("Remapped block %d to spare block %d, %d spares remain", lba, s, d.stats.SparesFree)
+		return nil
+	}
+	return ErrNoSpares
+}
+
+// writeVerified writes b to a single block, and checks that it reads back.
+func (d *Device) writeVerified(ctx context.Context, lba uint, b []byte) error {
+	if err := d.write(ctx, lba, b); err != nil {
+		return err
+	}
+	got := make([]byte, len(b))
+	if err := d.read(ctx, lba, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, b) {
+		d.stats.VerifyFailures++
+		return errVerify
+	}
+	return nil
+}
+
+// storeTable writes the remap table over its older copy.
+func (d *Device) storeTable(ctx context.Context) error {
+	d.table.Generation++
+	b := make([]byte, d.dev.BlockSize())
+	copy(b, d.table.marshal())
+	if err := d.writeVerified(ctx, d.geo.Start+uint(d.table.Generation%2), b); err != nil {
+		return fmt.Errorf("failed to store remap table: %v", err)
+	}
+	return nil
+}
+
+// checkRange returns an error if any of the n blocks starting at lba are
+// reserved for remapping.
+func (d *Device) checkRange(lba, n uint) error {
+	if end := d.spare(d.geo.Spares); lba < end && lba+n > d.geo.Start {
+		return fmt.Errorf("blocks [%d, %d) overlap blocks [%d, %d) reserved for remapping", lba, lba+n, d.geo.Start, end)
+	}
+	return nil
+}
+
+// read reads from the underlying device, counting any failure.
+func (d *Device) read(ctx context.Context, lba uint, b []byte) error {
+	if err := readBlocks(ctx, d.dev, lba, b); err != nil {
+		if slots.ContextErr(ctx) == nil {
+			d.stats.ReadErrors++
+		}
+		return err
+	}
+	return nil
+}
+
+// write writes to the underlying device, counting any failure, including
+// the device claiming to have written a different number of blocks.
+// b must be a whole number of blocks.
+func (d *Device) write(ctx context.Context, lba uint, b []byte) error {
+	want := uint(len(b)) / d.dev.BlockSize()
+	n, err := writeBlocks(ctx, d.dev, lba, b)
+	if err == nil && n != want {
+		err = fmt.Errorf("device wrote %d blocks at block %d, want %d", n, lba, want)
+	}
+	if err != nil {
+		if slots.ContextErr(ctx) == nil {
+			d.stats.WriteErrors++
+		}
+		return err
+	}
+	return nil
+}
+
+// readBlocks reads blocks from dev, honouring ctx if dev supports it.
+func readBlocks(ctx context.Context, dev slots.BlockReaderWriter, lba uint, b []byte) error {
+	if d, ok := dev.(slots.ContextBlockReaderWriter); ok {
+		return d.ReadBlocksContext(ctx, lba, b)
+	}
+	if err := slots.ContextErr(ctx); err != nil {
+		return err
+	}
+	return dev.ReadBlocks(lba, b)
+}
+
+// writeBlocks writes blocks to dev, honouring ctx if dev supports it.
+func writeBlocks(ctx context.Context, dev slots.BlockReaderWriter, lba uint, b []byte) (uint, error) {
+	if d, ok := dev.(slots.ContextBlockReaderWriter); ok {
+		return d.WriteBlocksContext(ctx, lba, b)
+	}
+	if err := slots.ContextErr(ctx); err != nil {
+		return 0, err
+	}
+	return dev.WriteBlocks(lba, b)
+}
@@ -0,0 +1,233 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+// geo reserves blocks [40, 46) for remapping, with 4 spares.
+var geo = Geometry{Start: 40, Spares: 4}
+
+// badBlocks makes writes to the given blocks fail verification.
+// Each block fails the given number of times, or always if it's negative.
+func badBlocks(md *testonly.MemDev, fails map[uint]int) {
+	md.OnBlockWritten = func(lba uint) {
+		if n, ok := fails[lba]; ok && n != 0 {
+			md.Storage[lba][0] ^= 0xff
+			fails[lba] = n - 1
+		}
+	}
+}
+
+func mustNew(t *testing.T, md *testonly.MemDev, verify bool) *Device {
+	t.Helper()
+	d, err := New(context.Background(), md, geo)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	d.SetVerify(verify)
+	return d
+}
+
+func mustRead(t *testing.T, d *Device, lba uint, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if err := d.ReadBlocks(lba, got); err != nil {
+		t.Fatalf("ReadBlocks: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("ReadBlocks(%d) returned different data to that written", lba)
+	}
+}
+
+// blocks returns n blocks of data, each filled with a different value.
+func blocks(md *testonly.MemDev, n int, v byte) []byte {
+	bs := int(md.BlockSize())
+	b := make([]byte, n*bs)
+	for i := range b {
+		b[i] = v + byte(i/bs)
+	}
+	return b
+}
+
+func TestWriteVerification(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// fails is the number of times that block 7 fails verification.
+		fails int
+		// badSpare makes the first spare block fail verification too.
+		badSpare     bool
+		verify       bool
+		wantErr      bool
+		wantStats    Stats
+		wantRemapped bool
+	}{
+		{
+			name:      "healthy",
+			verify:    true,
+			wantStats: Stats{Writes: 1, SparesFree: 4},
+		}, {
+			name:      "transient failure",
+			fails:     1,
+			verify:    true,
+			wantStats: Stats{Writes: 1, VerifyFailures: 1, SparesFree: 4},
+		}, {
+			name:         "bad block",
+			fails:        -1,
+			verify:       true,
+			wantStats:    Stats{Writes: 1, VerifyFailures: 2, Remapped: 1, SparesFree: 3},
+			wantRemapped: true,
+		}, {
+			name:         "bad block and first spare",
+			fails:        -1,
+			badSpare:     true,
+			verify:       true,
+			wantStats:    Stats{Writes: 1, VerifyFailures: 3, Remapped: 1, SparesFree: 2},
+			wantRemapped: true,
+		}, {
+			name:      "verification disabled",
+			fails:     -1,
+			wantErr:   true,
+			wantStats: Stats{Writes: 1, SparesFree: 4},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			md := testonly.NewMemDev(t, 64)
+			fails := map[uint]int{7: test.fails}
+			spare := geo.Start + 2
+			if test.badSpare {
+				fails[spare] = -1
+				spare++
+			}
+			badBlocks(md, fails)
+			d := mustNew(t, md, test.verify)
+			want := blocks(md, 4, 1)
+			if n, err := d.WriteBlocks(5, want); err != nil || n != 4 {
+				t.Fatalf("WriteBlocks = %d, %v, want 4, nil", n, err)
+			}
+			got := d.Stats()
+			got.Reads = 0
+			if got != test.wantStats {
+				t.Errorf("Stats = %+v, want %+v", got, test.wantStats)
+			}
+
+			// The data should be readable, including after reopening the
+			// device, unless the corruption went undetected.
+			md.OnBlockWritten = nil
+			for _, d := range []*Device{d, mustNew(t, md, test.verify)} {
+				got := make([]byte, len(want))
+				if err := d.ReadBlocks(5, got); err != nil {
+					t.Fatalf("ReadBlocks: %v", err)
+				}
+				if gotErr := !bytes.Equal(got, want); gotErr != test.wantErr {
+					t.Errorf("ReadBlocks returned corrupt data: %t, want %t", gotErr, test.wantErr)
+				}
+			}
+			if test.wantRemapped {
+				if !bytes.Equal(md.Storage[spare][:], want[2*md.BlockSize():3*md.BlockSize()]) {
+					t.Error("Spare block doesn't hold the remapped block's data")
+				}
+			}
+		})
+	}
+}
+
+func TestWriteToRemappedBlock(t *testing.T) {
+	md := testonly.NewMemDev(t, 64)
+	badBlocks(md, map[uint]int{7: -1})
+	d := mustNew(t, md, true)
+	if _, err := d.WriteBlocks(7, blocks(md, 1, 1)); err != nil {
+		t.Fatalf("WriteBlocks: %v", err)
+	}
+	// Once remapped, writes go straight to the spare even without
+	// verification.
+	d = mustNew(t, md, false)
+	want := blocks(md, 3, 10)
+	if _, err := d.WriteBlocks(6, want); err != nil {
+		t.Fatalf("WriteBlocks: %v", err)
+	}
+	mustRead(t, d, 6, want)
+	mustRead(t, mustNew(t, md, false), 6, want)
+	if s := d.Stats(); s.VerifyFailures != 0 || s.Remapped != 1 {
+		t.Errorf("Stats = %+v, want no verify failures and 1 remapped block", s)
+	}
+}
+
+func TestNoSpares(t *testing.T) {
+	md := testonly.NewMemDev(t, 64)
+	fails := map[uint]int{7: -1}
+	for i := range geo.Spares {
+		fails[geo.Start+2+i] = -1
+	}
+	badBlocks(md, fails)
+	d := mustNew(t, md, true)
+	if _, err := d.WriteBlocks(7, blocks(md, 1, 1)); !errors.Is(err, ErrNoSpares) {
+		t.Errorf("WriteBlocks: %v, want ErrNoSpares", err)
+	}
+	if s := d.Stats(); s.SparesFree != 0 || s.Remapped != 0 {
+		t.Errorf("Stats = %+v, want no free spares and no remapped blocks", s)
+	}
+}
+
+func TestRemapTable(t *testing.T) {
+	md := testonly.NewMemDev(t, 64)
+	badBlocks(md, map[uint]int{7: -1, 9: -1})
+	d := mustNew(t, md, true)
+	want := blocks(md, 5, 1)
+	if _, err := d.WriteBlocks(5, want); err != nil {
+		t.Fatalf("WriteBlocks: %v", err)
+	}
+	md.OnBlockWritten = nil
+
+	// Corrupting the latest copy of the table falls back to the previous one,
+	// which only knows about the first remapped block.
+	latest := md.Storage[geo.Start]
+	md.Storage[geo.Start][20] ^= 0xff
+	d = mustNew(t, md, false)
+	if s := d.Stats(); s.Remapped != 1 {
+		t.Errorf("Remapped = %d, want 1", s.Remapped)
+	}
+	md.Storage[geo.Start] = latest
+	mustRead(t, mustNew(t, md, false), 5, want)
+
+	// A table which is corrupt in both copies can't be ignored.
+	md.Storage[geo.Start][20] ^= 0xff
+	md.Storage[geo.Start+1][20] ^= 0xff
+	if _, err := New(context.Background(), md, geo); err == nil {
+		t.Error("New succeeded with corrupt remap table")
+	}
+}
+
+func TestReservedBlocks(t *testing.T) {
+	md := testonly.NewMemDev(t, 64)
+	d := mustNew(t, md, false)
+	for _, lba := range []uint{geo.Start - 1, geo.Start + 5} {
+		if _, err := d.WriteBlocks(lba, blocks(md, 2, 1)); err == nil {
+			t.Errorf("WriteBlocks(%d) succeeded, want error", lba)
+		}
+		if err := d.ReadBlocks(lba, blocks(md, 2, 1)); err == nil {
+			t.Errorf("ReadBlocks(%d) succeeded, want error", lba)
+		}
+	}
+	if _, err := d.WriteBlocks(geo.Start+6, blocks(md, 2, 1)); err != nil {
+		t.Errorf("WriteBlocks after reserved blocks: %v", err)
+	}
+}
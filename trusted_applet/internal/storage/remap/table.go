@@ -0,0 +1,118 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	// tableHeaderSize is the size of the fixed part of a marshalled table:
+	// magic, generation, next spare, and entry count.
+	tableHeaderSize = 4 + 4 + 4 + 4
+	// tableEntrySize is the size of a single marshalled remapping.
+	// The eMMC addresses blocks with 32 bits, so that's all we store.
+	tableEntrySize = 4 + 4
+)
+
+var (
+	// tableMagic identifies a block as holding a remap table.
+	tableMagic = [4]byte{'T', 'F', 'M', '0'}
+
+	// errNoTable is returned by unmarshalTable if the block doesn't hold a
+	// remap table at all, as opposed to holding a corrupt one.
+	errNoTable = errors.New("no remap table")
+)
+
+// table is the on-storage record of the blocks which have been remapped.
+//
+// Two copies of the table are kept, one in each of the table blocks, and
+// updates are written over the copy with the older generation so that a
+// failed write always leaves the other copy intact.
+type table struct {
+	// Generation is incremented every time the table is rewritten.
+	Generation uint32
+	// NextSpare is the index of the next unused spare block.
+	// Spares are never reused, even those which failed to take a write.
+	NextSpare uint32
+	// Blocks maps the addresses of remapped blocks to the addresses of the
+	// spares which now hold their data.
+	Blocks map[uint]uint
+}
+
+// maxTableEntries returns the number of remappings which fit in a table
+// stored in a block of the given size.
+func maxTableEntries(blockSize uint) uint {
+	if blockSize < tableHeaderSize+sha256.Size {
+		return 0
+	}
+	return (blockSize - tableHeaderSize - sha256.Size) / tableEntrySize
+}
+
+// marshal returns the serialised form of the table.
+func (t table) marshal() []byte {
+	from := make([]uint, 0, len(t.Blocks))
+	for f := range t.Blocks {
+		from = append(from, f)
+	}
+	sort.Slice(from, func(i, j int) bool { return from[i] < from[j] })
+
+	b := bytes.NewBuffer(make([]byte, 0, tableHeaderSize+len(from)*tableEntrySize+sha256.Size))
+	b.Write(tableMagic[:])
+	_ = binary.Write(b, binary.BigEndian, t.Generation)
+	_ = binary.Write(b, binary.BigEndian, t.NextSpare)
+	_ = binary.Write(b, binary.BigEndian, uint32(len(from)))
+	for _, f := range from {
+		_ = binary.Write(b, binary.BigEndian, uint32(f))
+		_ = binary.Write(b, binary.BigEndian, uint32(t.Blocks[f]))
+	}
+	h := sha256.Sum256(b.Bytes())
+	b.Write(h[:])
+	return b.Bytes()
+}
+
+// unmarshalTable parses a table from the contents of a table block.
+// Returns errNoTable if the block doesn't start with the table magic.
+func unmarshalTable(b []byte) (table, error) {
+	if !bytes.HasPrefix(b, tableMagic[:]) {
+		return table{}, errNoTable
+	}
+	if len(b) < tableHeaderSize+sha256.Size {
+		return table{}, errors.New("remap table is truncated")
+	}
+	t := table{
+		Generation: binary.BigEndian.Uint32(b[4:]),
+		NextSpare:  binary.BigEndian.Uint32(b[8:]),
+		Blocks:     make(map[uint]uint),
+	}
+	n := uint(binary.BigEndian.Uint32(b[12:]))
+	if n > maxTableEntries(uint(len(b))) {
+		return table{}, fmt.Errorf("remap table has %d entries, which don't fit in %d bytes", n, len(b))
+	}
+	end := tableHeaderSize + n*tableEntrySize
+	if h := sha256.Sum256(b[:end]); !bytes.Equal(h[:], b[end:end+sha256.Size]) {
+		return table{}, errors.New("remap table checksum mismatch")
+	}
+	for i := uint(0); i < n; i++ {
+		e := b[tableHeaderSize+i*tableEntrySize:]
+		t.Blocks[uint(binary.BigEndian.Uint32(e))] = uint(binary.BigEndian.Uint32(e[4:]))
+	}
+	return t, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	logfmt "github.com/transparency-dev/formats/log"
+	"k8s.io/klog/v2"
+)
+
+// Retire marks the log with the given origin as no longer being witnessed.
+//
+// The log's state is retained until CollectGarbage is called once the grace
+// period has passed, and the log will be reinstated if it's updated before
+// then.
+func (p *SlotPersistence) Retire(_ context.Context, origin string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	logID := logfmt.ID(origin)
+	if _, ok := p.idToSlot[logID]; !ok {
+		return fmt.Errorf("no slot for log ID %q", logID)
+	}
+	if _, ok := p.retired[logID]; ok {
+		return nil
+	}
+	p.retired[logID] = time.Now()
+	klog.Infof("Retiring log ID %q", logID)
+	return p.storeDirectory()
+}
+
+// RetireAllExcept retires every log with stored state whose origin is not in
+// the provided list, e.g. because it has been removed from the witness config.
+// Any previously retired logs whose origins are in the list are reinstated.
+func (p *SlotPersistence) RetireAllExcept(_ context.Context, origins []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	active := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		active[logfmt.ID(o)] = true
+	}
+	changed := false
+	now := time.Now()
+	for logID := range p.idToSlot {
+		_, retired := p.retired[logID]
+		switch {
+		case active[logID] && retired:
+			klog.Infof("Reinstating retired log ID %q", logID)
+			delete(p.retired, logID)
+			changed = true
+		case !active[logID] && !retired:
+			klog.Infof("Retiring log ID %q", logID)
+			p.retired[logID] = now
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return p.storeDirectory()
+}
+
+// CollectGarbage reclaims the slots assigned to logs which were retired at
+// least gracePeriod ago, making them available for use by other logs.
+// Returns the number of slots reclaimed.
+func (p *SlotPersistence) CollectGarbage(ctx context.Context, gracePeriod time.Duration) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	changed := false
+	n := 0
+	now := time.Now()
+	for logID, t := range p.retired {
+		if now.Sub(t) < gracePeriod {
+			continue
+		}
+		changed = true
+		i, ok := p.idToSlot[logID]
+		if !ok {
+			delete(p.retired, logID)
+			continue
+		}
+		// Tombstone the slot before removing the mapping, so that a crash in
+		// between can never leave a retired log's state in a free slot.
+		s, err := p.part.Open(i)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to open slot %d for log ID %q: %v", i, logID, err))
+			continue
+		}
+		err = p.rollback.update(ctx,
+			func(st witnessState) { st.set(logID, nil) },
+			func() error {
+				if err := s.Write(nil); err != nil {
+					return fmt.Errorf("failed to tombstone slot %d for log ID %q: %v", i, logID, err)
+				}
+				if err := s.Sync(); err != nil {
+					return fmt.Errorf("failed to sync tombstone in slot %d for log ID %q: %v", i, logID, err)
+				}
+				return nil
+			})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(p.idToSlot, logID)
+		delete(p.retired, logID)
+		p.freeSlots = append(p.freeSlots, i)
+		klog.Infof("Reclaimed slot %d from retired log ID %q", i, logID)
+		n++
+	}
+	if changed {
+		if err := p.storeDirectory(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
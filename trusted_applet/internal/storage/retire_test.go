@@ -0,0 +1,128 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	logfmt "github.com/transparency-dev/formats/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func mustStore(t *testing.T, p *SlotPersistence, origin string, cp string) {
+	t.Helper()
+	if err := p.Update(context.Background(), origin, func([]byte) ([]byte, error) { return []byte(cp), nil }); err != nil {
+		t.Fatalf("Update(%q): %v", origin, err)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	mustStore(t, p, "keep", "CP keep")
+	mustStore(t, p, "drop", "CP drop")
+	dropSlot := p.idToSlot[logfmt.ID("drop")]
+
+	if err := p.RetireAllExcept(ctx, []string{"keep"}); err != nil {
+		t.Fatalf("RetireAllExcept: %v", err)
+	}
+
+	// Nothing should be reclaimed until the grace period has passed.
+	if n, err := p.CollectGarbage(ctx, time.Hour); err != nil || n != 0 {
+		t.Fatalf("CollectGarbage(hour) = %d, %v, want 0, nil", n, err)
+	}
+	if cp, err := p.Latest(ctx, "drop"); err != nil || string(cp) != "CP drop" {
+		t.Fatalf("Latest(drop) = %q, %v before grace period expired", cp, err)
+	}
+
+	if n, err := p.CollectGarbage(ctx, 0); err != nil || n != 1 {
+		t.Fatalf("CollectGarbage(0) = %d, %v, want 1, nil", n, err)
+	}
+	if _, err := p.Latest(ctx, "drop"); status.Code(err) != codes.NotFound {
+		t.Errorf("Latest(drop) after GC: %v, want NotFound", err)
+	}
+	if cp, err := p.Latest(ctx, "keep"); err != nil || string(cp) != "CP keep" {
+		t.Errorf("Latest(keep) = %q, %v after GC", cp, err)
+	}
+
+	// The reclaimed slot should be empty after a reboot, and reused for new logs.
+	p = NewSlotPersistence(p.part)
+	if err := p.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if _, err := p.Latest(ctx, "drop"); status.Code(err) != codes.NotFound {
+		t.Errorf("Latest(drop) after reboot: %v, want NotFound", err)
+	}
+	if len(p.retired) != 0 {
+		t.Errorf("Got retired logs %v after GC, want none", p.retired)
+	}
+	mustStore(t, p, "new", "CP new")
+	if got := p.idToSlot[logfmt.ID("new")]; got != dropSlot {
+		t.Errorf("New log assigned slot %d, want reclaimed slot %d", got, dropSlot)
+	}
+}
+
+func TestRetiredLogReinstated(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	mustStore(t, p, "log", "CP 1")
+
+	if err := p.Retire(ctx, "log"); err != nil {
+		t.Fatalf("Retire: %v", err)
+	}
+	if err := p.Retire(ctx, "unknown"); err == nil {
+		t.Error("Retire(unknown) succeeded, want error")
+	}
+
+	// Retirement must survive a reboot.
+	p = NewSlotPersistence(p.part)
+	if err := p.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if _, ok := p.retired[logfmt.ID("log")]; !ok {
+		t.Fatal("Retired log not retired after reboot")
+	}
+
+	// Updating the log reinstates it, so it's not collected.
+	mustStore(t, p, "log", "CP 2")
+	if n, err := p.CollectGarbage(ctx, 0); err != nil || n != 0 {
+		t.Fatalf("CollectGarbage = %d, %v, want 0, nil", n, err)
+	}
+	if cp, err := p.Latest(ctx, "log"); err != nil || string(cp) != "CP 2" {
+		t.Errorf("Latest = %q, %v, want CP 2", cp, err)
+	}
+}
+
+func TestRetireAllExceptReinstates(t *testing.T) {
+	ctx := context.Background()
+	p := newTestPersistence(t)
+	mustStore(t, p, "log", "CP")
+
+	if err := p.RetireAllExcept(ctx, nil); err != nil {
+		t.Fatalf("RetireAllExcept(nil): %v", err)
+	}
+	if _, ok := p.retired[logfmt.ID("log")]; !ok {
+		t.Fatal("Log not retired")
+	}
+	if err := p.RetireAllExcept(ctx, []string{"log"}); err != nil {
+		t.Fatalf("RetireAllExcept(log): %v", err)
+	}
+	if _, ok := p.retired[logfmt.ID("log")]; ok {
+		t.Fatal("Log not reinstated")
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// anchorRecordSize is the size of a marshalled anchor record: magic,
+	// counter, current state digest, and previous state digest.
+	anchorRecordSize = 4 + 8 + 32 + 32
+)
+
+var (
+	// anchorMagic identifies an anchor record.
+	anchorMagic = []byte("TFR0")
+
+	// stateDigestPrefix is hashed ahead of the witness state, so that the
+	// digest can't be mistaken for one computed over anything else.
+	stateDigestPrefix = []byte("ArmoredWitness rollback state v1\n")
+
+	// ErrRollback is returned when the state held in storage is older than
+	// the state recorded by the rollback anchor, which means that the storage
+	// has been rolled back.
+	ErrRollback = errors.New("storage state does not match rollback anchor")
+
+	// errIndeterminate is wrapped by errors returned from the write passed to
+	// rollbackGuard.update if the change may still be stored later, e.g. when
+	// an interrupted transaction is recovered.
+	errIndeterminate = errors.New("change may yet be stored")
+)
+
+// Anchor is a small amount of storage which can't be rolled back, such as a
+// sector of the eMMC Replay Protected Memory Block partition, in which a
+// record of the witness state is kept.
+type Anchor interface {
+	// ReadAnchor returns the data last written to the anchor, or an empty
+	// slice if it has never been written.
+	ReadAnchor(ctx context.Context) ([]byte, error)
+	// WriteAnchor replaces the data stored in the anchor.
+	WriteAnchor(ctx context.Context, b []byte) error
+}
+
+// anchorRecord is the content of the anchor.
+//
+// Changes to the witness state are made in two steps: the anchor is first
+// updated to record the new state as Current while keeping the state stored
+// at the time as Previous, then once the change has been durably stored, the
+// anchor is updated again with both set to the new state. Storage is accepted
+// only if it holds one of the two, so it's never possible to roll it back to
+// a state older than the last one which was successfully stored.
+type anchorRecord struct {
+	// Counter is incremented every time the anchor is written.
+	Counter uint64
+	// Current is the digest of the state being stored.
+	Current [32]byte
+	// Previous is the digest of the state stored before Current.
+	Previous [32]byte
+}
+
+func (r anchorRecord) marshal() []byte {
+	b := make([]byte, 0, anchorRecordSize)
+	b = append(b, anchorMagic...)
+	b = binary.BigEndian.AppendUint64(b, r.Counter)
+	b = append(b, r.Current[:]...)
+	return append(b, r.Previous[:]...)
+}
+
+// unmarshalAnchorRecord parses an anchor record, which may have trailing
+// padding. Returns false if b is empty or all zeroes, i.e. the anchor has never
+// been written.
+func unmarshalAnchorRecord(b []byte) (anchorRecord, bool, error) {
+	if !slices.ContainsFunc(b, func(c byte) bool { return c != 0 }) {
+		return anchorRecord{}, false, nil
+	}
+	if !bytes.HasPrefix(b, anchorMagic) {
+		return anchorRecord{}, false, errors.New("invalid anchor record magic")
+	}
+	if len(b) < anchorRecordSize {
+		return anchorRecord{}, false, errors.New("short anchor record")
+	}
+	r := anchorRecord{Counter: binary.BigEndian.Uint64(b[4:])}
+	copy(r.Current[:], b[12:])
+	copy(r.Previous[:], b[44:])
+	return r, true, nil
+}
+
+// witnessState maps log IDs to the hash of the latest checkpoint stored for
+// the log. Logs without a checkpoint are omitted.
+type witnessState map[string][32]byte
+
+// digest returns a hash which commits to the whole state.
+func (s witnessState) digest() [32]byte {
+	h := sha256.New()
+	h.Write(stateDigestPrefix)
+	for _, id := range slices.Sorted(maps.Keys(s)) {
+		_ = binary.Write(h, binary.BigEndian, uint32(len(id)))
+		h.Write([]byte(id))
+		cp := s[id]
+		h.Write(cp[:])
+	}
+	return [32]byte(h.Sum(nil))
+}
+
+// set records cp as the latest checkpoint for the log, or removes the log if
+// cp is empty.
+func (s witnessState) set(logID string, cp []byte) {
+	if len(cp) == 0 {
+		delete(s, logID)
+		return
+	}
+	s[logID] = sha256.Sum256(cp)
+}
+
+// rollbackGuard ties changes to the witness state to an Anchor.
+//
+// All methods may be called on a nil guard, in which case changes are made
+// without any protection.
+type rollbackGuard struct {
+	anchor Anchor
+
+	// mu serialises changes to the state, and protects everything below.
+	mu sync.Mutex
+	// record is the content of the anchor.
+	record anchorRecord
+	// state is the witness state last known to be durably stored.
+	state witnessState
+}
+
+// verify checks that the witness state held in storage matches the anchor,
+// writing the anchor if it's never been written before.
+func (g *rollbackGuard) verify(ctx context.Context, stored witnessState) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, err := g.anchor.ReadAnchor(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read rollback anchor: %v", err)
+	}
+	r, ok, err := unmarshalAnchorRecord(b)
+	if err != nil {
+		return fmt.Errorf("failed to parse rollback anchor: %v", err)
+	}
+	d := stored.digest()
+	switch {
+	case !ok:
+		klog.Infof("No rollback anchor found, anchoring current state of %d logs", len(stored))
+	case d == r.Current && d == r.Previous:
+		g.record, g.state = r, stored
+		return nil
+	case d == r.Current || d == r.Previous:
+		// We were interrupted part way through a change, either before or
+		// after it was stored.
+		klog.Warningf("Completing interrupted rollback anchor update %d", r.Counter)
+	default:
+		return fmt.Errorf("%w: anchor update %d records state %x, but storage holds state %x", ErrRollback, r.Counter, r.Current, d)
+	}
+	g.record, g.state = r, stored
+	return g.write(ctx, d, d)
+}
+
+// update makes a change to the witness state.
+//
+// The change is described by edit, which is passed a copy of the current state
+// to modify, and write is called to durably store it.
+func (g *rollbackGuard) update(ctx context.Context, edit func(witnessState), write func() error) error {
+	if g == nil {
+		return write()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	next := maps.Clone(g.state)
+	if next == nil {
+		next = witnessState{}
+	}
+	edit(next)
+	prev, d := g.state.digest(), next.digest()
+	if d == prev {
+		return write()
+	}
+	if err := g.write(ctx, d, prev); err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		if errors.Is(err, errIndeterminate) {
+			// Leave the anchor accepting either state.
+			return err
+		}
+		// The storage should still hold the previous state, so try to put the
+		// anchor back to match. If this fails, the next update will do so.
+		if aErr := g.write(ctx, prev, prev); aErr != nil {
+			klog.Warningf("Failed to restore rollback anchor after failed write: %v", aErr)
+		}
+		return err
+	}
+	g.state = next
+	if err := g.write(ctx, d, d); err != nil {
+		// The change has been stored, so report success, but until the
+		// anchor is written again storage could be rolled back to prev.
+		klog.Warningf("Failed to commit rollback anchor: %v", err)
+	}
+	return nil
+}
+
+// reset unconditionally anchors the stored state, e.g. following an authorised
+// reset of the storage.
+func (g *rollbackGuard) reset(ctx context.Context, stored witnessState) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.state = stored
+	d := stored.digest()
+	return g.write(ctx, d, d)
+}
+
+// write stores an anchor record with the given state digests.
+// Must be called with g.mu locked.
+func (g *rollbackGuard) write(ctx context.Context, current, previous [32]byte) error {
+	r := anchorRecord{Counter: g.record.Counter + 1, Current: current, Previous: previous}
+	if err := g.anchor.WriteAnchor(ctx, r.marshal()); err != nil {
+		return fmt.Errorf("failed to write rollback anchor: %v", err)
+	}
+	g.record = r
+	return nil
+}
+
+// SetRollbackAnchor configures the persistence to record its state in the
+// provided anchor, and refuse to serve or update checkpoints if the storage
+// is found to have been rolled back to an earlier state.
+//
+// This must be called before Init.
+// Since every checkpoint update then needs two writes to the anchor, and to be
+// written through to storage, this disables any write-back for those updates.
+func (p *SlotPersistence) SetRollbackAnchor(a Anchor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rollback = &rollbackGuard{anchor: a}
+}
+
+// checkRollback verifies that the stored state hasn't been rolled back.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) checkRollback(ctx context.Context) error {
+	p.rollbackErr = nil
+	if p.rollback == nil {
+		return nil
+	}
+	stored, err := p.storedState(ctx)
+	if err != nil {
+		return err
+	}
+	if err := p.rollback.verify(ctx, stored); err != nil {
+		if errors.Is(err, ErrRollback) {
+			// Keep refusing until the storage is reset.
+			p.rollbackErr = err
+		}
+		return err
+	}
+	return nil
+}
+
+// storedState reads the latest checkpoint of every log from storage.
+// Must be called with p.mu at least read-locked.
+func (p *SlotPersistence) storedState(ctx context.Context) (witnessState, error) {
+	stored := make(witnessState, len(p.idToSlot))
+	for id, i := range p.idToSlot {
+		s, err := p.part.OpenContext(ctx, i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open slot %d associated with log ID %q: %v", i, id, err)
+		}
+		b, _, err := s.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read slot %d associated with log ID %q: %w", i, id, readErr(ctx, err))
+		}
+		cp, err := unmarshalCheckpoint(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal checkpoint for log ID %q: %v", id, err)
+		}
+		stored.set(id, cp)
+	}
+	return stored, nil
+}
+
+// writeCheckpoint stores the record r, whose latest checkpoint is cp, in slot s
+// for the given log, using the write token t.
+func (p *SlotPersistence) writeCheckpoint(ctx context.Context, logID string, s *slots.Slot, t uint32, r, cp []byte) error {
+	return p.rollback.update(ctx,
+		func(st witnessState) { st.set(logID, cp) },
+		func() error {
+			if _, err := s.CheckAndWriteContext(ctx, t, r); err != nil {
+				return err
+			}
+			if p.rollback == nil {
+				return nil
+			}
+			return s.Sync()
+		})
+}
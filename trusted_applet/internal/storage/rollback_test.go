@@ -0,0 +1,201 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+// anchoredDevice is the storage for a witness using a rollback anchor.
+type anchoredDevice struct {
+	md   *testonly.MemDev
+	rpmb *testonly.RPMB
+}
+
+func newAnchoredDevice(t *testing.T) *anchoredDevice {
+	t.Helper()
+	return &anchoredDevice{md: testonly.NewMemDev(t, 32), rpmb: testonly.NewRPMB()}
+}
+
+// boot opens and initialises the persistence stored on the device, as would
+// happen when the witness starts.
+func (d *anchoredDevice) boot(t *testing.T) (*SlotPersistence, error) {
+	t.Helper()
+	geo := slots.Geometry{Length: 32, SlotLengths: []uint{4, 4, 4, 4, 4, 4, 4, 4}}
+	part, err := slots.OpenPartition(d.md, geo)
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	p := NewSlotPersistence(part)
+	p.SetRollbackAnchor(d.rpmb)
+	return p, p.Init(context.Background())
+}
+
+func (d *anchoredDevice) mustBoot(t *testing.T) *SlotPersistence {
+	t.Helper()
+	p, err := d.boot(t)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	return p
+}
+
+func checkLatest(t *testing.T, p *SlotPersistence, origin, want string) {
+	t.Helper()
+	if got, err := p.Latest(context.Background(), origin); err != nil || string(got) != want {
+		t.Errorf("Latest(%q) = %q, %v, want %q", origin, got, err, want)
+	}
+}
+
+func TestRollbackDetected(t *testing.T) {
+	ctx := context.Background()
+	d := newAnchoredDevice(t)
+	p := d.mustBoot(t)
+	mustStore(t, p, "log", "CP 1")
+	image := slices.Clone(d.md.Storage)
+	mustStore(t, p, "log", "CP 2")
+	mustStore(t, p, "other", "CP other")
+	checkLatest(t, d.mustBoot(t), "log", "CP 2")
+
+	// Restore the earlier storage image.
+	copy(d.md.Storage, image)
+	p, err := d.boot(t)
+	if !errors.Is(err, ErrRollback) {
+		t.Fatalf("Init after rollback: %v, want ErrRollback", err)
+	}
+	if _, err := p.Latest(ctx, "log"); !errors.Is(err, ErrRollback) {
+		t.Errorf("Latest after rollback: %v, want ErrRollback", err)
+	}
+	if err := p.Update(ctx, "log", func([]byte) ([]byte, error) { return []byte("CP 3"), nil }); !errors.Is(err, ErrRollback) {
+		t.Errorf("Update after rollback: %v, want ErrRollback", err)
+	}
+	if _, err := p.Export(ctx, "test", nil); !errors.Is(err, ErrRollback) {
+		t.Errorf("Export after rollback: %v, want ErrRollback", err)
+	}
+
+	// Only a reset gets the witness going again.
+	if err := p.Reset(ctx, "rolled back"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	mustStore(t, p, "log", "CP 3")
+	checkLatest(t, d.mustBoot(t), "log", "CP 3")
+}
+
+func TestRollbackInterruptedUpdate(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// failWrite is the anchor write made by Update which should fail.
+		failWrite uint32
+		// corruptSlot causes the update's slot write to fail.
+		corruptSlot bool
+		wantFail    bool
+		want        string
+	}{
+		{
+			name:      "anchor prepare fails",
+			failWrite: 1,
+			wantFail:  true,
+			want:      "CP 1",
+		}, {
+			name:        "slot write fails",
+			corruptSlot: true,
+			wantFail:    true,
+			want:        "CP 1",
+		}, {
+			name:      "anchor commit fails",
+			failWrite: 2,
+			want:      "CP 2",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := newAnchoredDevice(t)
+			p := d.mustBoot(t)
+			mustStore(t, p, "log", "CP 1")
+
+			start := d.rpmb.Counter()
+			if test.failWrite > 0 {
+				d.rpmb.OnWrite = func(c uint32) error {
+					if c == start+test.failWrite-1 {
+						return errors.New("power lost")
+					}
+					return nil
+				}
+			}
+			if test.corruptSlot {
+				d.md.OnBlockWritten = func(lba uint) { d.md.Storage[lba][0] ^= 0xff }
+			}
+			err := p.Update(ctx, "log", func([]byte) ([]byte, error) { return []byte("CP 2"), nil })
+			if gotFail := err != nil; gotFail != test.wantFail {
+				t.Fatalf("Update: %v, want failure %t", err, test.wantFail)
+			}
+			d.rpmb.OnWrite, d.md.OnBlockWritten = nil, nil
+
+			p = d.mustBoot(t)
+			checkLatest(t, p, "log", test.want)
+			mustStore(t, p, "log", "CP 3")
+			checkLatest(t, d.mustBoot(t), "log", "CP 3")
+		})
+	}
+}
+
+func TestRollbackCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	d := newAnchoredDevice(t)
+	p := d.mustBoot(t)
+	mustStore(t, p, "keep", "CP keep")
+	mustStore(t, p, "drop", "CP drop")
+	if err := p.Retire(ctx, "drop"); err != nil {
+		t.Fatalf("Retire: %v", err)
+	}
+	image := slices.Clone(d.md.Storage)
+	if n, err := p.CollectGarbage(ctx, 0); err != nil || n != 1 {
+		t.Fatalf("CollectGarbage = %d, %v, want 1, nil", n, err)
+	}
+	checkLatest(t, d.mustBoot(t), "keep", "CP keep")
+
+	// Bringing the reclaimed log back is also a rollback.
+	copy(d.md.Storage, image)
+	if _, err := d.boot(t); !errors.Is(err, ErrRollback) {
+		t.Errorf("Init after restoring collected log: %v, want ErrRollback", err)
+	}
+}
+
+func TestRollbackAnchorOnlyWrittenOnChange(t *testing.T) {
+	d := newAnchoredDevice(t)
+	p := d.mustBoot(t)
+	mustStore(t, p, "log", "CP")
+	n := d.rpmb.Counter()
+	// Neither rebooting, nor storing the same checkpoint again, nor upgrading
+	// records changes the witness state.
+	p = d.mustBoot(t)
+	mustStore(t, p, "log", "CP")
+	if err := p.UpgradeRecords(context.Background()); err != nil {
+		t.Fatalf("UpgradeRecords: %v", err)
+	}
+	if _, err := p.CollectGarbage(context.Background(), time.Hour); err != nil {
+		t.Fatalf("CollectGarbage: %v", err)
+	}
+	if got := d.rpmb.Counter(); got != n {
+		t.Errorf("Anchor written %d times, want 0", got-n)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+)
+
+// ReadAhead configures the block cache used while scanning a journal for its
+// latest entry.
+//
+// Without it, the scan reads one block per device operation, and on the
+// Armored Witness each such operation is an RPC to the OS.
+type ReadAhead struct {
+	// Window is the number of contiguous blocks fetched from the device when
+	// a block which isn't cached is read. Values less than 2 disable
+	// read-ahead.
+	Window uint
+	// MaxWindows is the number of windows kept in the cache, after which the
+	// least recently used window is evicted. Zero is treated as 1.
+	MaxWindows uint
+}
+
+// DefaultReadAhead is the read-ahead used by partitions unless changed with
+// Partition.SetReadAhead.
+//
+// With 512 byte blocks, a window is a single maximally sized MMC transfer, and
+// since a journal which wraps around needs both its first and last blocks,
+// keeping two windows avoids re-reading either.
+var DefaultReadAhead = ReadAhead{Window: 64, MaxWindows: 2}
+
+// enabled returns true if the policy caches any blocks.
+func (r ReadAhead) enabled() bool {
+	return r.Window > 1
+}
+
+// blockCache is a read-through cache of the blocks in the [start, start+length)
+// range of an underlying device.
+//
+// Writes are passed straight through to the device, invalidating any cached
+// windows they overlap.
+type blockCache struct {
+	dev           BlockReaderWriter
+	start, length uint
+	ra            ReadAhead
+
+	// lru holds the cached windows, most recently used first.
+	lru *list.List
+	// windows maps the first block address of each cached window to its
+	// element in lru.
+	windows map[uint]*list.Element
+}
+
+// cacheWindow is a contiguous run of blocks held by a blockCache.
+type cacheWindow struct {
+	lba uint
+	b   []byte
+}
+
+// newBlockCache returns a cache for the given range of blocks in dev.
+func newBlockCache(dev BlockReaderWriter, start, length uint, ra ReadAhead) *blockCache {
+	return &blockCache{
+		dev:     dev,
+		start:   start,
+		length:  length,
+		ra:      ra,
+		lru:     list.New(),
+		windows: make(map[uint]*list.Element),
+	}
+}
+
+// BlockSize returns the block size of the underlying storage system.
+func (c *blockCache) BlockSize() uint {
+	return c.dev.BlockSize()
+}
+
+// ReadBlocks reads len(b) bytes into b from contiguous storage blocks starting
+// at the given block address.
+func (c *blockCache) ReadBlocks(lba uint, b []byte) error {
+	return c.ReadBlocksContext(context.Background(), lba, b)
+}
+
+// ReadBlocksContext behaves like ReadBlocks, but gives up if ctx becomes done
+// before the blocks have been fetched from the device.
+func (c *blockCache) ReadBlocksContext(ctx context.Context, lba uint, b []byte) error {
+	bs := c.dev.BlockSize()
+	for len(b) > 0 {
+		if lba < c.start || lba >= c.start+c.length {
+			// Not ours to cache.
+			return readBlocks(ctx, c.dev, lba, b)
+		}
+		w, err := c.window(ctx, lba)
+		if err != nil {
+			if ContextErr(ctx) != nil {
+				return err
+			}
+			// A bad block elsewhere in the window shouldn't stop us reading
+			// the blocks we were asked for.
+			return readBlocks(ctx, c.dev, lba, b)
+		}
+		n := copy(b, w.b[(lba-w.lba)*bs:])
+		b = b[n:]
+		lba += uint(n) / bs
+	}
+	return nil
+}
+
+// WriteBlocks writes the data in b to the device, discarding any cached copies
+// of the blocks written.
+func (c *blockCache) WriteBlocks(lba uint, b []byte) (uint, error) {
+	return c.WriteBlocksContext(context.Background(), lba, b)
+}
+
+// WriteBlocksContext behaves like WriteBlocks, but gives up if ctx becomes done
+// before the write has completed.
+func (c *blockCache) WriteBlocksContext(ctx context.Context, lba uint, b []byte) (uint, error) {
+	bs := c.dev.BlockSize()
+	end := lba + (uint(len(b))+bs-1)/bs
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		w := e.Value.(*cacheWindow)
+		if w.lba < end && lba < w.lba+uint(len(w.b))/bs {
+			c.lru.Remove(e)
+			delete(c.windows, w.lba)
+		}
+		e = next
+	}
+	return writeBlocks(ctx, c.dev, lba, b)
+}
+
+// window returns the cached window holding the block at lba, reading it from
+// the device if necessary.
+func (c *blockCache) window(ctx context.Context, lba uint) (*cacheWindow, error) {
+	first := c.start + (lba-c.start)/c.ra.Window*c.ra.Window
+	if e, ok := c.windows[first]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cacheWindow), nil
+	}
+	n := min(c.ra.Window, c.start+c.length-first)
+	w := &cacheWindow{lba: first, b: make([]byte, n*c.dev.BlockSize())}
+	if err := readBlocks(ctx, c.dev, first, w.b); err != nil {
+		return nil, fmt.Errorf("failed to read %d blocks at %d: %w", n, first, err)
+	}
+	c.windows[first] = c.lru.PushFront(w)
+	for uint(c.lru.Len()) > max(c.ra.MaxWindows, 1) {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.windows, e.Value.(*cacheWindow).lba)
+	}
+	return w, nil
+}
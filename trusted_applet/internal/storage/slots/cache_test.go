@@ -0,0 +1,222 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+// countingDev counts the read operations made on the underlying device.
+type countingDev struct {
+	*testonly.MemDev
+	reads int
+}
+
+func (d *countingDev) ReadBlocks(lba uint, b []byte) error {
+	d.reads++
+	return d.MemDev.ReadBlocks(lba, b)
+}
+
+func TestBlockCache(t *testing.T) {
+	dev := &countingDev{MemDev: testonly.NewMemDev(t, 20)}
+	for i := range dev.Storage {
+		dev.Storage[i][0] = byte(i)
+	}
+	c := newBlockCache(dev, 2, 16, ReadAhead{Window: 4, MaxWindows: 2})
+	bs := dev.BlockSize()
+
+	read := func(lba uint, wantReads int) {
+		t.Helper()
+		b := make([]byte, bs)
+		if err := c.ReadBlocks(lba, b); err != nil {
+			t.Fatalf("ReadBlocks(%d): %v", lba, err)
+		}
+		if b[0] != dev.Storage[lba][0] {
+			t.Errorf("ReadBlocks(%d) got block starting %d, want %d", lba, b[0], dev.Storage[lba][0])
+		}
+		if dev.reads != wantReads {
+			t.Errorf("After ReadBlocks(%d) got %d device reads, want %d", lba, dev.reads, wantReads)
+		}
+	}
+
+	read(2, 1)
+	read(5, 1)  // Same window.
+	read(6, 2)  // Next window.
+	read(3, 2)  // Still cached.
+	read(10, 3) // Evicts [6, 10).
+	read(2, 3)
+	read(7, 4)
+	read(17, 5) // Final window is truncated to the end of the range.
+	read(0, 6)  // Outside the range, so passed through.
+	read(0, 7)
+
+	// Writes must invalidate the cached copy.
+	w := bytes.Repeat([]byte{0xff}, int(bs))
+	if _, err := c.WriteBlocks(17, w); err != nil {
+		t.Fatalf("WriteBlocks: %v", err)
+	}
+	read(17, 8)
+
+	// Reads spanning windows are stitched together.
+	b := make([]byte, 4*bs)
+	if err := c.ReadBlocks(8, b); err != nil {
+		t.Fatalf("ReadBlocks: %v", err)
+	}
+	for i := range uint(4) {
+		if got, want := b[i*bs], dev.Storage[8+i][0]; got != want {
+			t.Errorf("Block %d got %d, want %d", 8+i, got, want)
+		}
+	}
+}
+
+func TestOpenJournalReadAhead(t *testing.T) {
+	const length = 64
+	for _, ra := range []ReadAhead{{}, {Window: 8, MaxWindows: 1}, {Window: 16, MaxWindows: 2}, {Window: 100, MaxWindows: 1}} {
+		t.Run(fmt.Sprintf("%+v", ra), func(t *testing.T) {
+			dev := &countingDev{MemDev: testonly.NewMemDev(t, length)}
+			j, err := OpenJournal(dev, 0, length)
+			if err != nil {
+				t.Fatalf("OpenJournal: %v", err)
+			}
+			// Write enough records to wrap around the end of the journal.
+			for i := range 10 {
+				if err := j.Update(fill(3000, fmt.Sprintf("record %d", i))); err != nil {
+					t.Fatalf("Update: %v", err)
+				}
+			}
+			want, wantRev := j.Data()
+
+			dev.reads = 0
+			j, err = openJournal(t.Context(), dev, 0, length, journalOpts{readAhead: ra})
+			if err != nil {
+				t.Fatalf("openJournal: %v", err)
+			}
+			if got, gotRev := j.Data(); !bytes.Equal(got, want) || gotRev != wantRev {
+				t.Errorf("Got revision %d, want %d with same data", gotRev, wantRev)
+			}
+			if ra.enabled() && dev.reads >= length {
+				t.Errorf("Got %d device reads with read-ahead, want fewer than %d", dev.reads, length)
+			}
+		})
+	}
+}
+
+func TestLazyOpen(t *testing.T) {
+	p, md := memPartition(t)
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	dev := &countingDev{MemDev: md}
+	p, err = OpenPartition(dev, Geometry{Start: 10, Length: 10, SlotLengths: []uint{1, 1, 2, 4}})
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	p.SetLazyOpen(true)
+	s, err = p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if dev.reads != 0 {
+		t.Errorf("Lazy Open made %d device reads, want 0", dev.reads)
+	}
+	b, _, err := s.Read()
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("Read = %q, %v, want hello", b, err)
+	}
+	if dev.reads == 0 {
+		t.Error("First Read made no device reads")
+	}
+
+	// Writing is also a first use.
+	s, err = p.Open(2)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := s.CheckAndWrite(0, []byte("there")); err != nil {
+		t.Fatalf("CheckAndWrite: %v", err)
+	}
+	if b, rev, err := s.Read(); err != nil || string(b) != "there" || rev != 1 {
+		t.Fatalf("Read = %q, %d, %v, want there, 1", b, rev, err)
+	}
+}
+
+// BenchmarkOpenSlots measures the cost of opening every slot of a partition,
+// as happens when a witness which has been running for a while boots, with
+// and without read-ahead and lazy opening.
+func BenchmarkOpenSlots(b *testing.B) {
+	const (
+		numSlots   = 64
+		slotBlocks = 1024
+	)
+	geo := Geometry{Length: numSlots * slotBlocks}
+	for range numSlots {
+		geo.SlotLengths = append(geo.SlotLengths, slotBlocks)
+	}
+	dev := &countingDev{MemDev: &testonly.MemDev{Storage: make([][testonly.MemBlockSize]byte, geo.Length)}}
+	p, err := OpenPartition(dev, geo)
+	if err != nil {
+		b.Fatalf("OpenPartition: %v", err)
+	}
+	// Half of the slots are in use, the rest have never been written.
+	for i := range uint(numSlots / 2) {
+		s, err := p.Open(i)
+		if err != nil {
+			b.Fatalf("Open: %v", err)
+		}
+		for j := range 3 {
+			if err := s.Write(fill(2000, fmt.Sprintf("checkpoint %d", j))); err != nil {
+				b.Fatalf("Write: %v", err)
+			}
+		}
+	}
+
+	for _, bm := range []struct {
+		name string
+		ra   ReadAhead
+		lazy bool
+	}{
+		{name: "no read-ahead"},
+		{name: "read-ahead 16x2", ra: ReadAhead{Window: 16, MaxWindows: 2}},
+		{name: "read-ahead default", ra: DefaultReadAhead},
+		{name: "lazy", ra: DefaultReadAhead, lazy: true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			dev.reads = 0
+			for b.Loop() {
+				p, err := OpenPartition(dev, geo)
+				if err != nil {
+					b.Fatalf("OpenPartition: %v", err)
+				}
+				p.SetReadAhead(bm.ra)
+				p.SetLazyOpen(bm.lazy)
+				for i := range uint(numSlots) {
+					if _, err := p.Open(i); err != nil {
+						b.Fatalf("Open: %v", err)
+					}
+				}
+			}
+			b.ReportMetric(float64(dev.reads)/float64(b.N), "reads/op")
+		})
+	}
+}
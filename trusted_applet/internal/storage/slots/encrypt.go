@@ -0,0 +1,174 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	// sealedMagic is a prefix which denotes that the data in a journal entry
+	// has been encrypted.
+	// Entries are still written with the usual header, so that code which
+	// predates encryption fails to make sense of the data, rather than
+	// mistaking the journal for an empty one.
+	sealedMagic = []byte("\x02AE1")
+
+	// ErrPlaintext is returned when opening a slot whose data is not
+	// encrypted, if encryption is required.
+	ErrPlaintext = errors.New("slot data is not encrypted")
+)
+
+// Encryption configures authenticated encryption of the data stored in slots.
+type Encryption struct {
+	// AEAD is used to seal the data written to slots, and open it again when
+	// read. It must accept nonces of the standard 12 byte size.
+	AEAD cipher.AEAD
+	// AllowPlaintext permits slots whose latest data predates encryption to
+	// be read, so that existing partitions can be migrated. The data will be
+	// encrypted the next time the slot is written to, or by Encrypt.
+	//
+	// Since anyone with access to the storage could write a plaintext entry,
+	// this should be disabled once migration is complete.
+	AllowPlaintext bool
+}
+
+// sealer encrypts and decrypts the data in a single journal.
+type sealer struct {
+	Encryption
+	// slot is the index of the slot the journal belongs to.
+	slot uint
+}
+
+// overhead returns the number of bytes added to data when it's sealed.
+func (s *sealer) overhead() int {
+	return len(sealedMagic) + s.AEAD.NonceSize() + s.AEAD.Overhead()
+}
+
+// additionalData returns the associated data for an entry with the given
+// revision, which binds the ciphertext to its slot and revision so that
+// entries can't be moved between slots or have their revisions rewritten.
+func (s *sealer) additionalData(rev uint32) []byte {
+	ad := binary.BigEndian.AppendUint64(nil, uint64(s.slot))
+	return binary.BigEndian.AppendUint32(ad, rev)
+}
+
+// seal returns the encrypted form of data, to be stored in the entry with the
+// given revision.
+func (s *sealer) seal(rev uint32, data []byte) ([]byte, error) {
+	nonce := make([]byte, s.AEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	b := make([]byte, 0, len(data)+s.overhead())
+	b = append(b, sealedMagic...)
+	b = append(b, nonce...)
+	return s.AEAD.Seal(b, nonce, data, s.additionalData(rev)), nil
+}
+
+// open returns the plaintext of the data stored in the entry with the given
+// revision.
+func (s *sealer) open(rev uint32, data []byte) ([]byte, error) {
+	b, ok := bytes.CutPrefix(data, sealedMagic)
+	if !ok {
+		if !s.AllowPlaintext {
+			return nil, ErrPlaintext
+		}
+		return data, nil
+	}
+	ns := s.AEAD.NonceSize()
+	if len(b) < ns {
+		return nil, errors.New("sealed data truncated")
+	}
+	p, err := s.AEAD.Open(nil, b[:ns], b[ns:], s.additionalData(rev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data for revision %d in slot %d: %v", rev, s.slot, err)
+	}
+	return p, nil
+}
+
+// SetEncryption configures the encryption of data written to slots opened
+// after this call.
+//
+// Must not be called concurrently with other methods on the partition.
+func (p *Partition) SetEncryption(e Encryption) {
+	p.enc = &e
+}
+
+// Encrypt rewrites the data in every slot whose latest entry is not encrypted,
+// so that encryption may then be required.
+// Returns the number of slots which were rewritten.
+//
+// If ctx becomes done before all slots have been checked, the number rewritten
+// so far is returned along with the context's error.
+func (p *Partition) Encrypt(ctx context.Context) (int, error) {
+	if p.enc == nil {
+		return 0, errors.New("encryption is not configured")
+	}
+	n := 0
+	var errs []error
+	for i := range p.slots {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		s, err := p.OpenContext(ctx, uint(i))
+		if err != nil {
+			return n, err
+		}
+		ok, err := s.encrypt(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("slot %d: %w", i, err))
+			continue
+		}
+		if ok {
+			n++
+		}
+	}
+	if n > 0 {
+		klog.Infof("Encrypted data in %d slots", n)
+	}
+	return n, errors.Join(errs...)
+}
+
+// encrypt rewrites the slot's data if it's stored in plaintext, and returns
+// whether it did so.
+func (s *Slot) encrypt(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.openDeferred(ctx); err != nil {
+		return false, err
+	}
+	if s.journal == nil {
+		return false, s.notOpen()
+	}
+	if !s.journal.plaintext || s.dirty {
+		// Either there's nothing to do, or the pending write will be
+		// encrypted when it's flushed.
+		return false, nil
+	}
+	if err := s.journal.UpdateContext(ctx, s.journal.current.Data); err != nil {
+		return false, err
+	}
+	s.revision++
+	return true, nil
+}
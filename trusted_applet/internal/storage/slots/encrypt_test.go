@@ -0,0 +1,178 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+func mustAEAD(t *testing.T, key byte) cipher.AEAD {
+	t.Helper()
+	b, err := aes.NewCipher(bytes.Repeat([]byte{key}, 32))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	a, err := cipher.NewGCM(b)
+	if err != nil {
+		t.Fatalf("NewGCM: %v", err)
+	}
+	return a
+}
+
+// encryptedPartition reopens the partition on md with the given encryption.
+func encryptedPartition(t *testing.T, md *testonly.MemDev, e Encryption) *Partition {
+	t.Helper()
+	p, err := OpenPartition(md, Geometry{Start: 10, Length: 10, SlotLengths: []uint{1, 1, 2, 4}})
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	p.SetEncryption(e)
+	return p
+}
+
+func mustWrite(t *testing.T, p *Partition, slot uint, data string) {
+	t.Helper()
+	s, err := p.Open(slot)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write([]byte(data)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func readSlot(t *testing.T, p *Partition, slot uint) (string, error) {
+	t.Helper()
+	s, err := p.Open(slot)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	b, _, err := s.Read()
+	return string(b), err
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	_, md := memPartition(t)
+	e := Encryption{AEAD: mustAEAD(t, 1)}
+	mustWrite(t, encryptedPartition(t, md, e), 3, "secret checkpoint")
+
+	for _, b := range md.Storage {
+		if bytes.Contains(b[:], []byte("secret")) {
+			t.Fatal("Found plaintext on storage")
+		}
+	}
+	if got, err := readSlot(t, encryptedPartition(t, md, e), 3); err != nil || got != "secret checkpoint" {
+		t.Errorf("Read = %q, %v, want %q", got, err, "secret checkpoint")
+	}
+	if _, err := readSlot(t, encryptedPartition(t, md, Encryption{AEAD: mustAEAD(t, 2)}), 3); err == nil {
+		t.Error("Read with the wrong key succeeded")
+	}
+}
+
+func TestEncryptionDetectsTampering(t *testing.T) {
+	e := Encryption{AEAD: mustAEAD(t, 1)}
+	for _, test := range []struct {
+		name   string
+		tamper func(md *testonly.MemDev)
+	}{
+		{
+			name: "entry moved to another slot",
+			tamper: func(md *testonly.MemDev) {
+				// Slots 0 and 1 are a single block each.
+				md.Storage[11] = md.Storage[10]
+			},
+		}, {
+			name: "revision rewritten",
+			tamper: func(md *testonly.MemDev) {
+				// The revision isn't covered by the entry's data hash.
+				md.Storage[11][7]++
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, md := memPartition(t)
+			p := encryptedPartition(t, md, e)
+			mustWrite(t, p, 0, "slot zero")
+			mustWrite(t, p, 1, "slot one")
+			test.tamper(md)
+			if got, err := readSlot(t, encryptedPartition(t, md, e), 1); err == nil {
+				t.Errorf("Read of tampered slot = %q, want error", got)
+			}
+		})
+	}
+}
+
+func TestEncryptionMigration(t *testing.T) {
+	p, md := memPartition(t)
+	mustWrite(t, p, 1, "old")
+	mustWrite(t, p, 3, "older")
+
+	// Plaintext must be refused unless explicitly allowed.
+	strict := Encryption{AEAD: mustAEAD(t, 1)}
+	if _, err := readSlot(t, encryptedPartition(t, md, strict), 1); err == nil {
+		t.Fatal("Read of plaintext slot succeeded without AllowPlaintext")
+	}
+	if _, err := openJournal(context.Background(), md, 14, 4, journalOpts{seal: &sealer{Encryption: strict, slot: 3}}); !errors.Is(err, ErrPlaintext) {
+		t.Fatalf("openJournal: %v, want ErrPlaintext", err)
+	}
+
+	lenient := strict
+	lenient.AllowPlaintext = true
+	p = encryptedPartition(t, md, lenient)
+	if got, err := readSlot(t, p, 1); err != nil || got != "old" {
+		t.Fatalf("Read = %q, %v, want %q", got, err, "old")
+	}
+	n, err := p.Encrypt(context.Background())
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Encrypted %d slots, want 2", n)
+	}
+	if n, err := p.Encrypt(context.Background()); err != nil || n != 0 {
+		t.Errorf("Second Encrypt = %d, %v, want 0", n, err)
+	}
+
+	p = encryptedPartition(t, md, strict)
+	for slot, want := range map[uint]string{1: "old", 3: "older", 2: ""} {
+		if got, err := readSlot(t, p, slot); err != nil || got != want {
+			t.Errorf("Read(%d) after migration = %q, %v, want %q", slot, got, err, want)
+		}
+	}
+}
+
+func TestEncryptionSizeLimit(t *testing.T) {
+	_, md := memPartition(t)
+	p := encryptedPartition(t, md, Encryption{AEAD: mustAEAD(t, 1)})
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Slot 3 is 4 blocks, so holds records of up to 2 blocks.
+	limit := 2*testonly.MemBlockSize - entryHeaderSize - len(sealedMagic) - 12 - 16
+	if err := s.Write(make([]byte, limit)); err != nil {
+		t.Errorf("Write(%d bytes): %v", limit, err)
+	}
+	if err := s.Write(make([]byte, limit+1)); err == nil {
+		t.Errorf("Write(%d bytes) succeeded, want error", limit+1)
+	}
+}
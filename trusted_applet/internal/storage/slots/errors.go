@@ -0,0 +1,79 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrConflict is returned by CheckAndWrite if the slot has been written to
+	// since the Read call which produced the passed-in token.
+	// The caller may read the slot again and retry.
+	ErrConflict = errors.New("invalid token, slot updated since then")
+
+	// ErrCorrupt is matched by every CorruptError, and can be used with
+	// errors.Is to tell corrupt data apart from other failures.
+	ErrCorrupt = errors.New("data is corrupt")
+)
+
+// CorruptError is returned when data stored in a slot is found to be corrupt,
+// as opposed to being unreadable, e.g. because ctx became done.
+// Retrying the operation is unlikely to help, but the slot may be repairable.
+type CorruptError struct {
+	// Slot is the index of the slot holding the corrupt data, or -1 if the
+	// journal was opened directly rather than via a partition.
+	Slot int
+	// LBA is the block at which the corrupt data starts, or zero if it's
+	// not known.
+	LBA uint
+	// Err describes the corruption.
+	Err error
+}
+
+func (e *CorruptError) Error() string {
+	s := "journal is corrupt"
+	if e.Slot >= 0 {
+		s = fmt.Sprintf("slot %d is corrupt", e.Slot)
+	}
+	if e.LBA > 0 {
+		s += fmt.Sprintf(" at block %d", e.LBA)
+	}
+	return fmt.Sprintf("%s: %v", s, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is ErrCorrupt.
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// corruptAt returns a CorruptError describing corruption found at the given
+// block of a journal. The slot is filled in by the Slot which opened it.
+func corruptAt(lba uint, err error) error {
+	return &CorruptError{Slot: -1, LBA: lba, Err: err}
+}
+
+// setCorruptSlot records the slot in any CorruptError wrapped by err.
+func setCorruptSlot(err error, slot uint) {
+	var ce *CorruptError
+	if errors.As(err, &ce) {
+		ce.Slot = int(slot)
+	}
+}
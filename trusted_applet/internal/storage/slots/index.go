@@ -0,0 +1,123 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// superblockSize is the size of a marshalled superblock: magic, revision,
+	// head block address, head data hash, and checksum.
+	superblockSize = 4 + 4 + 8 + 32 + 32
+)
+
+var (
+	// superblockMagic identifies a block as holding a journal index superblock.
+	superblockMagic = [4]byte{'T', 'F', 'I', '0'}
+
+	// errNoSuperblock is returned when an index block holds no superblock.
+	errNoSuperblock = errors.New("no superblock")
+)
+
+// journalIndex locates the superblock which records where the head of a
+// journal is, allowing the journal to be opened without scanning all of it.
+//
+// The superblock is only ever a hint: it's written after the entry it refers
+// to has been written and verified, and the entry it points to is checked when
+// the journal is opened. If the superblock is missing, corrupt, or refers to
+// an entry which is no longer there, the journal is scanned as usual.
+type journalIndex struct {
+	// lba is the address of the block holding the superblock.
+	lba uint
+}
+
+// superblock is the on-storage content of a journal index.
+type superblock struct {
+	// Revision is the revision of the head entry when the superblock was
+	// written.
+	Revision uint32
+	// Head is the address of the first block of the head entry.
+	Head uint
+	// DataSHA256 is the hash of the head entry's data.
+	DataSHA256 [32]byte
+}
+
+// marshal returns the serialised form of the superblock, padded to bs bytes.
+func (s superblock) marshal(bs uint) []byte {
+	b := make([]byte, 0, bs)
+	b = append(b, superblockMagic[:]...)
+	b = binary.BigEndian.AppendUint32(b, s.Revision)
+	b = binary.BigEndian.AppendUint64(b, uint64(s.Head))
+	b = append(b, s.DataSHA256[:]...)
+	h := sha256.Sum256(b)
+	b = append(b, h[:]...)
+	return append(b, make([]byte, bs-uint(len(b)))...)
+}
+
+// unmarshalSuperblock parses a superblock from the provided block.
+func unmarshalSuperblock(b []byte) (superblock, error) {
+	if len(b) < superblockSize {
+		return superblock{}, errors.New("short superblock")
+	}
+	if !bytes.Equal(b[:len(superblockMagic)], superblockMagic[:]) {
+		return superblock{}, errNoSuperblock
+	}
+	l := superblockSize - sha256.Size
+	if h := sha256.Sum256(b[:l]); !bytes.Equal(h[:], b[l:superblockSize]) {
+		return superblock{}, errors.New("superblock checksum mismatch")
+	}
+	s := superblock{
+		Revision: binary.BigEndian.Uint32(b[4:]),
+		Head:     uint(binary.BigEndian.Uint64(b[8:])),
+	}
+	copy(s.DataSHA256[:], b[16:])
+	return s, nil
+}
+
+// read returns the superblock stored in the index.
+func (x *journalIndex) read(ctx context.Context, dev BlockReaderWriter) (superblock, error) {
+	b := make([]byte, dev.BlockSize())
+	if err := readBlocks(ctx, dev, x.lba, b); err != nil {
+		return superblock{}, fmt.Errorf("failed to read index at block %d: %w", x.lba, err)
+	}
+	return unmarshalSuperblock(b)
+}
+
+// write stores the superblock in the index.
+func (x *journalIndex) write(ctx context.Context, dev BlockReaderWriter, s superblock) error {
+	return x.writeBlock(ctx, dev, s.marshal(dev.BlockSize()))
+}
+
+// clear removes any superblock stored in the index.
+func (x *journalIndex) clear(ctx context.Context, dev BlockReaderWriter) error {
+	return x.writeBlock(ctx, dev, make([]byte, dev.BlockSize()))
+}
+
+func (x *journalIndex) writeBlock(ctx context.Context, dev BlockReaderWriter, b []byte) error {
+	n, err := writeBlocks(ctx, dev, x.lba, b)
+	if err != nil {
+		return fmt.Errorf("failed to write index at block %d: %w", x.lba, err)
+	}
+	if n != 1 {
+		return fmt.Errorf("short write of index at block %d", x.lba)
+	}
+	return nil
+}
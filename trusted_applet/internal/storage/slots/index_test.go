@@ -0,0 +1,208 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+const (
+	// Layout of the indexed journal used by these tests.
+	testIndexLBA      = 0
+	testJournalStart  = 1
+	testJournalLength = 32
+)
+
+// openIndexed opens the test journal using its index.
+func openIndexed(t *testing.T, dev BlockReaderWriter) *Journal {
+	t.Helper()
+	j, err := openJournal(context.Background(), dev, testJournalStart, testJournalLength, journalOpts{index: &journalIndex{lba: testIndexLBA}})
+	if err != nil {
+		t.Fatalf("openJournal: %v", err)
+	}
+	return j
+}
+
+// checkJournal checks that the indexed and unindexed views of the test journal
+// both have the expected head, and that the journal can still be updated.
+func checkJournal(t *testing.T, md *testonly.MemDev, want []byte, wantRev uint32) {
+	t.Helper()
+	j := openIndexed(t, md)
+	if got, rev := j.Data(); !bytes.Equal(got, want) || rev != wantRev {
+		t.Errorf("Indexed open got rev %d %.20q, want rev %d %.20q", rev, got, wantRev, want)
+	}
+	scanned, err := OpenJournal(md, testJournalStart, testJournalLength)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	if j.nextBlock != scanned.nextBlock {
+		t.Errorf("Indexed open will write at block %d, but scan says %d", j.nextBlock, scanned.nextBlock)
+	}
+
+	next := []byte("after reopening")
+	if err := j.Update(next); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, rev := openIndexed(t, md).Data(); !bytes.Equal(got, next) || rev != wantRev+1 {
+		t.Errorf("After update got rev %d %q, want rev %d %q", rev, got, wantRev+1, next)
+	}
+}
+
+func TestIndexedOpenSkipsScan(t *testing.T) {
+	dev := &countingDev{MemDev: testonly.NewMemDev(t, testJournalStart+testJournalLength)}
+	j := openIndexed(t, dev)
+	for i := range 20 {
+		if err := j.Update([]byte(fmt.Sprintf("record %d", i))); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	dev.reads = 0
+	j = openIndexed(t, dev)
+	if got, rev := j.Data(); string(got) != "record 19" || rev != 20 {
+		t.Errorf("Got rev %d %q, want rev 20 %q", rev, got, "record 19")
+	}
+	// The superblock, the head entry, and the block following it.
+	if dev.reads > 3 {
+		t.Errorf("Indexed open made %d reads, want at most 3", dev.reads)
+	}
+	checkJournal(t, dev.MemDev, []byte("record 19"), 20)
+}
+
+func TestIndexFaults(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// hook returns the OnBlockWritten function to install on the device
+		// while making the hooked updates.
+		hook func(md *testonly.MemDev) func(lba uint)
+		// updates is the number of updates made with the hook installed.
+		updates int
+		// wantFail is true if the hooked updates are expected to fail.
+		wantFail bool
+	}{
+		{
+			name: "index write lost",
+			hook: func(md *testonly.MemDev) func(uint) {
+				prev := md.Storage[testIndexLBA]
+				return func(lba uint) {
+					if lba == testIndexLBA {
+						md.Storage[lba] = prev
+					}
+				}
+			},
+			updates: 1,
+		}, {
+			name: "index write torn",
+			hook: func(md *testonly.MemDev) func(uint) {
+				return func(lba uint) {
+					if lba == testIndexLBA {
+						md.Storage[lba][20] ^= 0xff
+					}
+				}
+			},
+			updates: 1,
+		}, {
+			name: "index lost while journal wraps over its head",
+			hook: func(md *testonly.MemDev) func(uint) {
+				prev := md.Storage[testIndexLBA]
+				return func(lba uint) {
+					if lba == testIndexLBA {
+						md.Storage[lba] = prev
+					}
+				}
+			},
+			updates: 30,
+		}, {
+			name: "entry write torn",
+			hook: func(md *testonly.MemDev) func(uint) {
+				return func(lba uint) {
+					if lba != testIndexLBA {
+						md.Storage[lba][0] ^= 0xff
+					}
+				}
+			},
+			updates:  1,
+			wantFail: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			md := testonly.NewMemDev(t, testJournalStart+testJournalLength)
+			j := openIndexed(t, md)
+			for i := range 5 {
+				if err := j.Update(fill(700, fmt.Sprintf("record %d", i))); err != nil {
+					t.Fatalf("Update: %v", err)
+				}
+			}
+			want, wantRev := j.Data()
+
+			md.OnBlockWritten = test.hook(md)
+			for i := range test.updates {
+				d := fill(700, fmt.Sprintf("hooked record %d", i))
+				err := j.Update(d)
+				if gotFail := err != nil; gotFail != test.wantFail {
+					t.Fatalf("Update: %v, want failure %t", err, test.wantFail)
+				}
+				if err == nil {
+					want, wantRev = d, wantRev+1
+				}
+			}
+			md.OnBlockWritten = nil
+
+			checkJournal(t, md, want, wantRev)
+		})
+	}
+}
+
+func TestEraseClearsIndex(t *testing.T) {
+	md := testonly.NewMemDev(t, 32)
+	geo := Geometry{Start: 2, Length: 20, TableBlocks: 2, IndexBlocks: 2, SlotLengths: []uint{4, 8}}
+	p, err := OpenPartition(md, geo)
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	s, err := p.Open(1)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := unmarshalSuperblock(md.Storage[geo.Start+geo.TableBlocks+1][:]); err != nil {
+		t.Fatalf("No superblock written for slot 1: %v", err)
+	}
+	if err := p.Erase(); err != nil {
+		t.Fatalf("Erase: %v", err)
+	}
+	if _, err := unmarshalSuperblock(md.Storage[geo.Start+geo.TableBlocks+1][:]); err != errNoSuperblock {
+		t.Errorf("Superblock for slot 1 after erase: %v, want errNoSuperblock", err)
+	}
+
+	p, err = OpenPartition(md, geo)
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	s, err = p.Open(1)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if b, rev, err := s.Read(); err != nil || len(b) != 0 || rev != 0 {
+		t.Errorf("Read after erase = %q, %d, %v, want empty", b, rev, err)
+	}
+}
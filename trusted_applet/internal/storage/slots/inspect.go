@@ -0,0 +1,187 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// JournalEntry describes an entry found in a slot's journal by Inspect.
+type JournalEntry struct {
+	// LBA is the address of the entry's first block.
+	LBA uint
+	// Revision is the revision recorded in the entry's header.
+	Revision uint32
+	// Blocks is the number of blocks occupied by the entry, or zero if its
+	// data is bad, since then its length can't be trusted.
+	Blocks uint
+	// Data is the data stored in the entry.
+	// This is the data as stored, so will be encrypted if the partition was.
+	Data []byte
+	// Head is true if this is the entry holding the slot's current data.
+	Head bool
+	// Torn is true if this is a bad entry at the location of the next write,
+	// which is expected following a power failure or reboot during a write.
+	Torn bool
+	// Error describes what is wrong with the entry, if anything.
+	Error string `json:",omitempty"`
+}
+
+// SlotExtent returns the address of the first block of the given slot, and its
+// length in blocks.
+func (p *Partition) SlotExtent(slot uint) (uint, uint, error) {
+	if l := uint(len(p.slots)); slot >= l {
+		return 0, 0, fmt.Errorf("invalid slot %d (partition has %d slots)", slot, l)
+	}
+	return p.slots[slot].start, p.slots[slot].length, nil
+}
+
+// Inspect returns every entry which can be found in the given slot's journal,
+// in block order, without modifying it.
+//
+// This is intended for examining the storage offline, and unlike Scrub, reads
+// the slot through to the device even if it's open.
+func (p *Partition) Inspect(ctx context.Context, slot uint) ([]JournalEntry, error) {
+	if l := uint(len(p.slots)); slot >= l {
+		return nil, fmt.Errorf("invalid slot %d (partition has %d slots)", slot, l)
+	}
+	s := &p.slots[slot]
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return p.inspectSlot(ctx, s)
+}
+
+// inspectSlot returns every entry found in the journal of s.
+// Must be called with s.mu at least read-locked.
+func (p *Partition) inspectSlot(ctx context.Context, s *Slot) ([]JournalEntry, error) {
+	// As with scrubbing, read the whole slot in one go rather than making
+	// many small reads while scanning the journal.
+	b := make([]byte, s.length*p.dev.BlockSize())
+	if err := readBlocks(ctx, p.dev, s.start, b); err != nil {
+		return nil, fmt.Errorf("failed to read slot: %w", err)
+	}
+	dev := &memBlocks{bs: p.dev.BlockSize(), start: s.start, b: b}
+	// The journal is opened without decrypting it, since the entries are
+	// reported as stored.
+	j, err := OpenJournalContext(ctx, dev, s.start, s.length)
+	if err != nil {
+		// Keep going, the entries will show what's wrong.
+		klog.Warningf("Failed to open journal at block %d: %v", s.start, err)
+	}
+
+	var ret []JournalEntry
+	scanEntries(ctx, dev, s.start, s.length, func(lba, next uint, e *entry, err error) {
+		je := JournalEntry{LBA: lba, Revision: e.Revision, Data: e.Data}
+		if err != nil {
+			je.Error = err.Error()
+			je.Torn = j != nil && lba == j.nextBlock && e.Revision == j.current.Revision+1
+			ret = append(ret, je)
+			return
+		}
+		if je.Blocks = (next + s.length - lba) % s.length; je.Blocks == 0 {
+			// The entry fills the whole journal.
+			je.Blocks = s.length
+		}
+		je.Head = j != nil && e.Revision == j.current.Revision && next == j.nextBlock
+		ret = append(ret, je)
+	})
+	if err := ContextErr(ctx); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// Repair rewrites the given slot's journal so that it holds only the entry
+// with the slot's current data, zeroing every other block, and updates the
+// slot's index to point at that entry.
+// If the journal holds no valid entries at all, the slot is erased.
+//
+// The current entry itself is never moved or rewritten, so a failed repair
+// does not risk losing it.
+// This is intended for cleaning up storage offline, and must not be called
+// while the slot is being used.
+func (p *Partition) Repair(ctx context.Context, slot uint) error {
+	if l := uint(len(p.slots)); slot >= l {
+		return fmt.Errorf("invalid slot %d (partition has %d slots)", slot, l)
+	}
+	s := &p.slots[slot]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty {
+		return errors.New("slot has unflushed writes")
+	}
+	entries, err := p.inspectSlot(ctx, s)
+	if err != nil {
+		return err
+	}
+	var head *JournalEntry
+	valid := 0
+	seen := make(map[uint32]bool)
+	for i := range entries {
+		if entries[i].Head {
+			head = &entries[i]
+		}
+		if entries[i].Error != "" {
+			continue
+		}
+		if seen[entries[i].Revision] {
+			return fmt.Errorf("found more than one entry with revision %d", entries[i].Revision)
+		}
+		seen[entries[i].Revision] = true
+		valid++
+	}
+	if head == nil && valid > 0 {
+		// Erasing the slot would lose data which might be recoverable.
+		return fmt.Errorf("found %d valid entries, but none could be identified as current", valid)
+	}
+	// Make sure the slot is reopened from the repaired journal.
+	s.journal, s.openErr = nil, nil
+
+	keep := func(lba uint) bool {
+		return head != nil && (lba+s.length-head.LBA)%s.length < head.Blocks
+	}
+	bs := p.dev.BlockSize()
+	for lba := s.start; lba < s.start+s.length; {
+		if keep(lba) {
+			lba++
+			continue
+		}
+		n := uint(1)
+		for lba+n < s.start+s.length && !keep(lba+n) {
+			n++
+		}
+		w, err := writeBlocks(ctx, p.dev, lba, make([]byte, n*bs))
+		if err != nil {
+			return fmt.Errorf("failed to zero blocks [%d, %d): %w", lba, lba+n, err)
+		}
+		if w != n {
+			return fmt.Errorf("short write zeroing blocks [%d, %d): wrote %d blocks", lba, lba+n, w)
+		}
+		lba += n
+	}
+
+	if s.index == nil {
+		return nil
+	}
+	if head == nil {
+		return s.index.clear(ctx, p.dev)
+	}
+	return s.index.write(ctx, p.dev, superblock{Revision: head.Revision, Head: head.LBA, DataSHA256: sha256.Sum256(head.Data)})
+}
@@ -0,0 +1,159 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+func TestInspectAndRepair(t *testing.T) {
+	ctx := context.Background()
+	// Slot 1 occupies blocks [10, 18), and has its superblock in block 5.
+	geo := Geometry{Start: 2, Length: 20, TableBlocks: 2, IndexBlocks: 2, SlotLengths: []uint{4, 8}}
+	const superblockLBA = 5
+
+	for _, test := range []struct {
+		name string
+		// size and n are the size and number of records written to the slot.
+		size, n int
+		// torn is true if a further write should be torn.
+		torn bool
+		// want is the expected sequence of entries found in the journal,
+		// excluding their data.
+		want []JournalEntry
+		// wantHead is the head entry, which is all that should remain
+		// after repair.
+		wantHead JournalEntry
+	}{
+		{
+			name: "torn write",
+			size: 700,
+			n:    5,
+			torn: true,
+			want: []JournalEntry{
+				{LBA: 10, Revision: 5, Blocks: 2, Head: true},
+				{LBA: 12, Revision: 6, Torn: true},
+				{LBA: 14, Revision: 3, Blocks: 2},
+				{LBA: 16, Revision: 4, Blocks: 2},
+			},
+			wantHead: JournalEntry{LBA: 10, Revision: 5, Blocks: 2, Head: true},
+		}, {
+			name: "head wraps around",
+			size: 1200,
+			n:    3,
+			// The head overwrote the first entry when it wrapped around.
+			want: []JournalEntry{
+				{LBA: 13, Revision: 2, Blocks: 3},
+				{LBA: 16, Revision: 3, Blocks: 3, Head: true},
+			},
+			wantHead: JournalEntry{LBA: 16, Revision: 3, Blocks: 3, Head: true},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			md := testonly.NewMemDev(t, 32)
+			p, err := OpenPartition(md, geo)
+			if err != nil {
+				t.Fatalf("OpenPartition: %v", err)
+			}
+			s, err := p.Open(1)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			var want []byte
+			for i := range test.n {
+				want = fill(test.size, fmt.Sprintf("record %d", i))
+				if err := s.Write(want); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if test.torn {
+				md.OnBlockWritten = func(lba uint) {
+					if lba == 13 {
+						md.Storage[lba][100] ^= 0xff
+					}
+				}
+				if err := s.Write(fill(test.size, "torn")); err == nil {
+					t.Fatal("Torn write succeeded")
+				}
+				md.OnBlockWritten = nil
+			}
+
+			checkEntries := func(want []JournalEntry) {
+				t.Helper()
+				got, err := p.Inspect(ctx, 1)
+				if err != nil {
+					t.Fatalf("Inspect: %v", err)
+				}
+				if len(got) != len(want) {
+					t.Fatalf("Inspect found %d entries, want %d: %+v", len(got), len(want), got)
+				}
+				for i := range got {
+					g := got[i]
+					if (g.Error != "") != (g.Blocks == 0) {
+						t.Errorf("Entry %d: got error %q with %d blocks", i, g.Error, g.Blocks)
+					}
+					w := want[i]
+					if g.LBA != w.LBA || g.Revision != w.Revision || g.Blocks != w.Blocks || g.Head != w.Head || g.Torn != w.Torn {
+						t.Errorf("Entry %d: got lba %d rev %d blocks %d head %t torn %t, want %+v", i, g.LBA, g.Revision, g.Blocks, g.Head, g.Torn, w)
+					}
+				}
+			}
+			checkEntries(test.want)
+
+			if err := p.Repair(ctx, 1); err != nil {
+				t.Fatalf("Repair: %v", err)
+			}
+			checkEntries([]JournalEntry{test.wantHead})
+			sb, err := unmarshalSuperblock(md.Storage[superblockLBA][:])
+			if err != nil || sb.Head != test.wantHead.LBA || sb.Revision != test.wantHead.Revision {
+				t.Errorf("Superblock after repair = %+v, %v, want head %d rev %d", sb, err, test.wantHead.LBA, test.wantHead.Revision)
+			}
+
+			for _, p := range []*Partition{p, mustReopen(t, p)} {
+				s, err := p.Open(1)
+				if err != nil {
+					t.Fatalf("Open: %v", err)
+				}
+				if got, rev, err := s.Read(); err != nil || !bytes.Equal(got, want) || rev != test.wantHead.Revision {
+					t.Errorf("Read after repair = %.10q, %d, %v, want %.10q, %d", got, rev, err, want, test.wantHead.Revision)
+				}
+			}
+		})
+	}
+}
+
+func TestRepairRefusesAmbiguousJournal(t *testing.T) {
+	p, md := memPartition(t)
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write([]byte("one")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// Duplicate the entry, which makes the journal impossible to open.
+	md.Storage[15] = md.Storage[14]
+	if err := p.Repair(context.Background(), 3); err == nil {
+		t.Fatal("Repair of journal with duplicate revisions succeeded")
+	}
+	if !bytes.Equal(md.Storage[14][:], md.Storage[15][:]) {
+		t.Error("Repair modified journal despite failing")
+	}
+}
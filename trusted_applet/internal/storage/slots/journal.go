@@ -17,12 +17,14 @@ package slots
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/ctxerr"
 	"k8s.io/klog/v2"
 )
 
@@ -47,6 +49,57 @@ type BlockReaderWriter interface {
 	WriteBlocks(lba uint, b []byte) (uint, error)
 }
 
+// ContextBlockReaderWriter is a BlockReaderWriter whose operations can be
+// cancelled, or bounded by a deadline, using a context.
+//
+// Implementations should return the error from ContextErr if an operation is
+// abandoned because its context is done.
+type ContextBlockReaderWriter interface {
+	BlockReaderWriter
+
+	// ReadBlocksContext behaves like ReadBlocks, but gives up if ctx becomes
+	// done before the read has completed.
+	ReadBlocksContext(ctx context.Context, lba uint, b []byte) error
+
+	// WriteBlocksContext behaves like WriteBlocks, but gives up if ctx becomes
+	// done before the write has completed. In that case some, but not all, of
+	// the blocks may have been written.
+	WriteBlocksContext(ctx context.Context, lba uint, b []byte) (uint, error)
+}
+
+// ErrTimeout is returned, wrapping context.DeadlineExceeded, when a storage
+// operation is abandoned because it didn't complete before its deadline.
+var ErrTimeout = ctxerr.ErrTimeout
+
+// ContextErr returns the error which should be reported for an operation which
+// is abandoned because ctx is done, or nil if ctx is not done.
+// See ctxerr.FromContext.
+func ContextErr(ctx context.Context) error {
+	return ctxerr.FromContext(ctx)
+}
+
+// readBlocks reads blocks from dev, honouring ctx if dev supports it.
+func readBlocks(ctx context.Context, dev BlockReaderWriter, lba uint, b []byte) error {
+	if d, ok := dev.(ContextBlockReaderWriter); ok {
+		return d.ReadBlocksContext(ctx, lba, b)
+	}
+	if err := ContextErr(ctx); err != nil {
+		return err
+	}
+	return dev.ReadBlocks(lba, b)
+}
+
+// writeBlocks writes blocks to dev, honouring ctx if dev supports it.
+func writeBlocks(ctx context.Context, dev BlockReaderWriter, lba uint, b []byte) (uint, error) {
+	if d, ok := dev.(ContextBlockReaderWriter); ok {
+		return d.WriteBlocksContext(ctx, lba, b)
+	}
+	if err := ContextErr(ctx); err != nil {
+		return 0, err
+	}
+	return dev.WriteBlocks(lba, b)
+}
+
 // Journal implements a record-based format which provides a resilient storage.
 // This structure is not thread-safe, so concurrent access must be enforced at
 // a higher level.
@@ -57,6 +110,25 @@ type Journal struct {
 	current      entry
 	nextBlock    uint
 	maxDataBytes uint
+	// index is the journal's index, or nil if it isn't indexed.
+	index *journalIndex
+	// seal encrypts the data stored in the journal, and is nil if data is
+	// stored in plaintext.
+	seal *sealer
+	// plaintext is true if the current entry's data was stored in plaintext
+	// despite seal being set, because it predates encryption.
+	plaintext bool
+}
+
+// journalOpts configures how a journal is opened.
+type journalOpts struct {
+	// readAhead configures the cache used to scan the journal.
+	readAhead ReadAhead
+	// index, if set, is used to find the head of the journal without
+	// scanning it, and is updated with each new entry.
+	index *journalIndex
+	// seal, if set, is used to encrypt and decrypt the data in the journal.
+	seal *sealer
 }
 
 // entry represents a single update in the journal.
@@ -86,20 +158,13 @@ const (
 
 	// minEntries is the minimum number of entries a journal must be able
 	// to store.
-	// At least 3 guarantees that a journal is always recoverable in the case of a
-	// failed write; imagine a journal of 10 blocks where it's permitted to write
-	// records of up to 50% of the available space (== 5 blocks), and that the user
-	// performs 3 writes in sequence with the following sizes:
-	//    1 block, 5 blocks, 5 blocks
-	// but the final write fails after having only stored 2 of the 5 blocks.
-	// In this case, due to the current implementation avoiding wrapping records
-	// which would go past the end of the journal, we would corrupt the first two
-	// entries.
-	//
-	// This trade-off, which currently favours simplicity over space efficiency,
-	// could be shifted in the other direction by adding support for wrapping the
-	// journal, at which point this value could be lowered to 2.
-	minEntries = 3
+	// At least 2 guarantees that a journal is always recoverable in the case of a
+	// failed write: records are written contiguously, wrapping around the end of
+	// the journal if necessary, so a new record only ever overwrites blocks which
+	// follow the current record. Limiting records to 50% of the available space
+	// means that even a failed write of the largest permitted record cannot reach
+	// the blocks holding the current record.
+	minEntries = 2
 )
 
 // Size returns the number of bytes used by this entry record.
@@ -111,14 +176,35 @@ func (e *entry) Size() int {
 // [start, start+length) range of blocks accessible via dev.
 // Journal ranges should not overlap with one another, or corruption will almost certainly occur.
 func OpenJournal(dev BlockReaderWriter, start, length uint) (*Journal, error) {
+	return OpenJournalContext(context.Background(), dev, start, length)
+}
+
+// OpenJournalContext behaves like OpenJournal, but gives up if ctx becomes done
+// before the journal has been scanned.
+func OpenJournalContext(ctx context.Context, dev BlockReaderWriter, start, length uint) (*Journal, error) {
+	return openJournal(ctx, dev, start, length, journalOpts{})
+}
+
+// openJournal opens the journal using the provided options.
+func openJournal(ctx context.Context, dev BlockReaderWriter, start, length uint, opts journalOpts) (*Journal, error) {
+	// Records are padded out to whole blocks, so make sure that minEntries
+	// of the largest permitted record will fit in the journal.
+	// Journals which are too small for that can still hold a single block
+	// record, but will not be resilient to failed writes.
+	maxBlocks := length / minEntries
+	if maxBlocks == 0 {
+		maxBlocks = 1
+	}
 	j := &Journal{
 		dev:          dev,
 		start:        start,
 		length:       length,
-		maxDataBytes: (length*dev.BlockSize())/minEntries - entryHeaderSize,
+		maxDataBytes: maxBlocks*dev.BlockSize() - entryHeaderSize,
+		index:        opts.index,
+		seal:         opts.seal,
 	}
 
-	if err := j.init(); err != nil {
+	if err := j.init(ctx, opts.readAhead); err != nil {
 		return nil, err
 	}
 
@@ -137,50 +223,156 @@ func (j *Journal) Data() ([]byte, uint32) {
 // The new record's revision will be one greater than the previous record (or 1
 // if no previous record exists).
 func (j *Journal) Update(data []byte) error {
-	if l := len(data); l > int(j.maxDataBytes) {
-		return fmt.Errorf("attemping to write %d bytes, larger than the max permitted in this journal (%d bytes)", l, j.maxDataBytes)
+	return j.UpdateContext(context.Background(), data)
+}
+
+// UpdateContext behaves like Update, but gives up if ctx becomes done before
+// the new record has been written and verified.
+// As with any other failed update, the journal's current data is unchanged
+// in that case.
+func (j *Journal) UpdateContext(ctx context.Context, data []byte) error {
+	if err := j.checkSize(len(data)); err != nil {
+		return err
+	}
+	rev := j.current.Revision + 1
+	stored := data
+	if j.seal != nil {
+		var err error
+		if stored, err = j.seal.seal(rev, data); err != nil {
+			return fmt.Errorf("failed to encrypt data: %v", err)
+		}
 	}
-	h := sha256.Sum256(data)
+	h := sha256.Sum256(stored)
 	e := entry{
 		Magic:      [4]byte{magic0[0], magic0[1], magic0[2], magic0[3]},
-		Revision:   j.current.Revision + 1,
-		DataLen:    uint64(len(data)),
+		Revision:   rev,
+		DataLen:    uint64(len(stored)),
 		DataSHA256: h,
-		Data:       data,
+		Data:       stored,
 	}
 
-	cap := (j.start + j.length - j.nextBlock) * j.dev.BlockSize()
-	if cap < uint(e.Size()) {
-		// The record won't fit in the remaining space, so wrap around and write at the beginning.
-		j.nextBlock = j.start
-	}
 	// TODO(al): consider making this more "streamy".
 	buf := &bytes.Buffer{}
 	if err := marshalEntry(e, buf); err != nil {
 		return fmt.Errorf("failed to marshal entry: %v", err)
 	}
-	numBlocks, err := j.dev.WriteBlocks(j.nextBlock, buf.Bytes())
+	// Pad the record out to a whole number of blocks.
+	bs := j.dev.BlockSize()
+	if r := uint(buf.Len()) % bs; r != 0 {
+		buf.Write(make([]byte, bs-r))
+	}
+
+	// If the record won't fit in the remaining space, write as much as we can
+	// and wrap the rest around to the beginning of the journal.
+	b := buf.Bytes()
+	lba := j.nextBlock
+	if cap := (j.start + j.length - lba) * bs; uint(len(b)) > cap {
+		if err := j.writeBlocks(ctx, lba, b[:cap]); err != nil {
+			return err
+		}
+		b = b[cap:]
+		lba = j.start
+	}
+	if err := j.writeBlocks(ctx, lba, b); err != nil {
+		return err
+	}
+
+	// Read the record back to make sure it was stored correctly.
+	br := newBlockReader(ctx, j.dev, j.start, j.length, j.nextBlock)
+	got, err := unmarshalEntry(br)
 	if err != nil {
-		return fmt.Errorf("failed to write blocks: %v", err)
+		if cErr := ContextErr(ctx); cErr != nil {
+			return fmt.Errorf("failed to verify written entry: %w", cErr)
+		}
+		return corruptAt(j.nextBlock, fmt.Errorf("failed to verify written entry: %v", err))
+	}
+	if got.Revision != e.Revision || got.DataSHA256 != e.DataSHA256 {
+		return corruptAt(j.nextBlock, fmt.Errorf("failed to verify written entry: read back rev %d (%x), want rev %d (%x)", got.Revision, got.DataSHA256, e.Revision, e.DataSHA256))
 	}
 
 	// Finally, update the journal state.
-	j.nextBlock += numBlocks
+	head := j.nextBlock
+	j.nextBlock = br.lba
 	j.current = e
+	j.current.Data = data
+	j.plaintext = false
 
+	// The new entry is safely stored, so a failure to update the index only
+	// means that the next open will be slower.
+	if j.index != nil {
+		if err := j.index.write(ctx, j.dev, superblock{Revision: e.Revision, Head: head, DataSHA256: e.DataSHA256}); err != nil {
+			klog.Warningf("Failed to update journal index: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// capacity returns the largest number of bytes of data which can be stored
+// in this journal.
+func (j *Journal) capacity() int {
+	limit := int(j.maxDataBytes)
+	if j.seal != nil {
+		limit -= j.seal.overhead()
+	}
+	return limit
+}
+
+// checkSize returns an error if l bytes of data are too large to be stored in
+// this journal.
+func (j *Journal) checkSize(l int) error {
+	if limit := j.capacity(); l > limit {
+		return fmt.Errorf("attemping to write %d bytes, larger than the max permitted in this journal (%d bytes)", l, limit)
+	}
+	return nil
+}
+
+// writeBlocks writes b to the device starting at lba, and ensures that all
+// of the blocks were written.
+func (j *Journal) writeBlocks(ctx context.Context, lba uint, b []byte) error {
+	want := uint(len(b)) / j.dev.BlockSize()
+	numBlocks, err := writeBlocks(ctx, j.dev, lba, b)
+	if err != nil {
+		return fmt.Errorf("failed to write blocks: %w", err)
+	}
+	if numBlocks != want {
+		return fmt.Errorf("short write at block %d: wrote %d blocks, want %d", lba, numBlocks, want)
+	}
 	return nil
 }
 
 // Init scans the journal to figure out the latest valid record, if any.
-func (j *Journal) init() error {
+func (j *Journal) init(ctx context.Context, ra ReadAhead) error {
+	// The scan may read the same blocks many times over, so read them via a
+	// cache if we're allowed. The cache is only needed for the scan, updates
+	// must always read back from the device to verify what was written.
+	dev := j.dev
+	if ra.enabled() {
+		dev = newBlockCache(j.dev, j.start, j.length, ra)
+	}
+
 	// Start where all good stories do: at the beginning!
 	lba := j.start
 	var lastEntry entry
+	// lastEntryLBA is the block at which lastEntry starts.
+	var lastEntryLBA uint
 	nextWriteLBA := j.start
+	if e, head, next, ok := j.indexedHead(ctx, dev); ok {
+		// Skip straight to the head recorded in the index, and carry on
+		// scanning from there in case the index is behind.
+		lastEntry, lastEntryLBA, lba, nextWriteLBA = *e, head, next, next
+	} else if err := ContextErr(ctx); err != nil {
+		return fmt.Errorf("abandoned journal scan: %w", err)
+	}
 	for lba < j.start+j.length {
-		br := newBlockReader(j.dev, lba)
+		br := newBlockReader(ctx, dev, j.start, j.length, lba)
 		e, err := unmarshalEntry(br)
 		if err != nil {
+			if cErr := ContextErr(ctx); cErr != nil {
+				// We gave up reading the storage, so can't tell where the
+				// current record ends.
+				return fmt.Errorf("abandoned journal scan at block %d: %w", lba, cErr)
+			}
 			if lastEntry.Revision > 0 {
 				klog.V(2).Infof("Scanned to invalid entry, using last good entry seen@rev %d (%v)", lastEntry.Revision, err)
 				// We already found the lastet record in the journal, so we're done.
@@ -192,7 +384,9 @@ func (j *Journal) init() error {
 			//  a) the journal is completely empty, or
 			//  b) the previously good entry/ies at the start of the journal
 			//     have been completely or partially overwritten during a
-			//     failed write attempt.
+			//     failed write attempt, or
+			//  c) the blocks at the start of the journal contain the tail
+			//     end of an entry which wrapped around from the end.
 			// Either way, we don't have a valid entry wth a length field we can
 			// rely on, so we we'll have to fall back to scanning all blocks to
 			// look for one.
@@ -202,9 +396,10 @@ func (j *Journal) init() error {
 		if e.Revision > lastEntry.Revision {
 			klog.V(3).Infof("Scan found rev %d(@ block %d), continuing", e.Revision, lba)
 			// We've found a(nother) good entry, so update our state
-			lastEntry = *e
-			// Skip past the blocks we've just read
-			lba = (br.pos-1)/br.dev.BlockSize() + 1
+			lastEntry, lastEntryLBA = *e, lba
+			// Skip past the blocks we've just read, this may wrap around to
+			// the start of the journal if the entry did.
+			lba = br.lba
 			// If this turns out to be the last good entry, then we'll write
 			// at the next block.
 			nextWriteLBA = lba
@@ -216,22 +411,58 @@ func (j *Journal) init() error {
 			// We've found an older revision following a newer one, so we're done.
 			nextWriteLBA = lba
 			break
+		} else if lba == lastEntryLBA {
+			// The last entry filled the whole journal, so we've wrapped
+			// around to it again.
+			break
 		} else {
-			return fmt.Errorf("journal is corrupt - found two entries with the same revision (%d)", e.Revision)
+			return corruptAt(lba, fmt.Errorf("found two entries with the same revision (%d)", e.Revision))
 		}
 	}
-	// In the case where the very last entry in the journal is the current one,
-	// and that entry extends into the final block, we'll need to wrap the
-	// nextBlock pointer to the start of the journal.
-	if nextWriteLBA >= j.start+j.length {
-		nextWriteLBA = j.start
-	}
 	j.nextBlock = nextWriteLBA
 	j.current = lastEntry
 
+	if j.seal != nil && lastEntry.Revision > 0 {
+		d, err := j.seal.open(lastEntry.Revision, lastEntry.Data)
+		if errors.Is(err, ErrPlaintext) {
+			return fmt.Errorf("failed to open data for revision %d: %w", lastEntry.Revision, err)
+		} else if err != nil {
+			return corruptAt(lastEntryLBA, fmt.Errorf("failed to open data for revision %d: %w", lastEntry.Revision, err))
+		}
+		j.plaintext = !bytes.HasPrefix(lastEntry.Data, sealedMagic)
+		j.current.Data = d
+	}
+
 	return nil
 }
 
+// indexedHead returns the entry which the journal's index records as the head,
+// along with the addresses of its first block and the block following it, if
+// the index is present and the entry it refers to is still intact.
+func (j *Journal) indexedHead(ctx context.Context, dev BlockReaderWriter) (*entry, uint, uint, bool) {
+	if j.index == nil {
+		return nil, 0, 0, false
+	}
+	sb, err := j.index.read(ctx, dev)
+	if err != nil {
+		if err != errNoSuperblock {
+			klog.V(2).Infof("Ignoring journal index: %v", err)
+		}
+		return nil, 0, 0, false
+	}
+	if sb.Head < j.start || sb.Head >= j.start+j.length {
+		klog.V(2).Infof("Ignoring journal index: head block %d is outside journal", sb.Head)
+		return nil, 0, 0, false
+	}
+	br := newBlockReader(ctx, dev, j.start, j.length, sb.Head)
+	e, err := unmarshalEntry(br)
+	if err != nil || e.Revision != sb.Revision || e.DataSHA256 != sb.DataSHA256 {
+		klog.V(2).Infof("Ignoring stale journal index for rev %d at block %d", sb.Revision, sb.Head)
+		return nil, 0, 0, false
+	}
+	return e, sb.Head, br.lba, true
+}
+
 // unmarshalEntry reads and deserialises an entry structure from the provided reader.
 func unmarshalEntry(r io.Reader) (*entry, error) {
 	e := &entry{}
@@ -291,30 +522,55 @@ func marshalEntry(e entry, w io.Writer) error {
 }
 
 // blockReader provides an io.Reader wrapper for BlockReaderWriter instances.
+// Reads which run off the end of the [start, start+length) range of blocks
+// wrap around to the beginning of the range.
 type blockReader struct {
-	dev BlockReaderWriter
+	ctx           context.Context
+	dev           BlockReaderWriter
+	start, length uint
+	// lba is the address of the next block to be read from dev.
+	lba uint
+	// n is the number of blocks read so far.
+	n   uint
 	buf []byte
-	pos uint
+	// off is the offset in buf of the next byte to be returned by Read.
+	off int
 }
 
-// newBlockReader creates a new reader for the given BlockReaderWriter, whose Read
-// function will start with the block address in lba.
-func newBlockReader(dev BlockReaderWriter, lba uint) *blockReader {
+// newBlockReader creates a new reader for the [start, start+length) range of
+// blocks in the given BlockReaderWriter, whose Read function will start with
+// the block address in lba.
+func newBlockReader(ctx context.Context, dev BlockReaderWriter, start, length, lba uint) *blockReader {
+	bs := dev.BlockSize()
 	return &blockReader{
-		dev: dev,
-		buf: make([]byte, dev.BlockSize()),
-		pos: lba * dev.BlockSize(),
+		ctx:    ctx,
+		dev:    dev,
+		start:  start,
+		length: length,
+		lba:    lba,
+		buf:    make([]byte, bs),
+		off:    int(bs),
 	}
 }
 
 // Read implements io.Reader.
 func (br *blockReader) Read(b []byte) (int, error) {
-	if br.pos%br.dev.BlockSize() == 0 {
-		if err := br.dev.ReadBlocks(br.pos/br.dev.BlockSize(), br.buf); err != nil {
+	if br.off == len(br.buf) {
+		if br.n == br.length {
+			// We've read every block in the range, going around again
+			// would only return data we've already seen.
+			return 0, io.EOF
+		}
+		if err := readBlocks(br.ctx, br.dev, br.lba, br.buf); err != nil {
 			return 0, err
 		}
+		br.off = 0
+		br.n++
+		if br.lba++; br.lba == br.start+br.length {
+			br.lba = br.start
+		}
 	}
-	l := copy(b, br.buf[br.pos%br.dev.BlockSize():])
-	br.pos += uint(l)
+	l := copy(b, br.buf[br.off:])
+	br.off += l
 	return l, nil
 }
@@ -16,10 +16,13 @@ package slots
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
@@ -177,7 +180,7 @@ func TestWriteSizeLimit(t *testing.T) {
 		t.Fatalf("OpenJournal: %v", err)
 	}
 
-	limit := int((storageBlocks * md.BlockSize() / 3) - entryHeaderSize)
+	limit := int((storageBlocks * md.BlockSize() / 2) - entryHeaderSize)
 	if err := j.Update(fill(limit, "ok...")); err != nil {
 		t.Fatalf("Update: %q, but expected write to succeed", err)
 	}
@@ -245,6 +248,24 @@ func TestPerfectlyFullJournal(t *testing.T) {
 	})
 }
 
+func TestSingleBlockJournal(t *testing.T) {
+	md := testonly.NewMemDev(t, 2)
+	start, length := uint(1), uint(1)
+	for i := range 3 {
+		j, err := OpenJournal(md, start, length)
+		if err != nil {
+			t.Fatalf("OpenJournal: %v", err)
+		}
+		if _, rev := j.Data(); rev != uint32(i) {
+			t.Errorf("Got revision %d, want %d", rev, i)
+		}
+		// Every entry fills the journal, so the scan wraps back around to it.
+		if err := j.Update([]byte(fmt.Sprintf("rev %d", i+1))); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+}
+
 func TestRoundTrip(t *testing.T) {
 	storageBlocks := uint(20)
 	md := testonly.NewMemDev(t, storageBlocks)
@@ -323,6 +344,210 @@ func TestUpdateVerifies(t *testing.T) {
 	}
 }
 
+// wedgedDev is a device whose context-aware operations never complete while
+// wedged is set, until their context is done.
+type wedgedDev struct {
+	*testonly.MemDev
+	wedged bool
+}
+
+func (d *wedgedDev) ReadBlocksContext(ctx context.Context, lba uint, b []byte) error {
+	if d.wedged {
+		<-ctx.Done()
+		return ContextErr(ctx)
+	}
+	return d.ReadBlocks(lba, b)
+}
+
+func (d *wedgedDev) WriteBlocksContext(ctx context.Context, lba uint, b []byte) (uint, error) {
+	if d.wedged {
+		<-ctx.Done()
+		return 0, ContextErr(ctx)
+	}
+	return d.WriteBlocks(lba, b)
+}
+
+func TestUpdateTimeout(t *testing.T) {
+	storageBlocks := uint(20)
+	dev := &wedgedDev{MemDev: testonly.NewMemDev(t, storageBlocks)}
+	start, length := uint(1), storageBlocks-1
+
+	j, err := OpenJournal(dev, start, length)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	if err := j.Update([]byte("one")); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	dev.wedged = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := j.UpdateContext(ctx, []byte("two")); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("UpdateContext: %v, want ErrTimeout", err)
+	}
+	if got, _ := j.Data(); string(got) != "one" {
+		t.Fatalf("Got data %q after timeout, want %q", got, "one")
+	}
+	if _, err := OpenJournalContext(ctx, dev, start, length); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("OpenJournalContext: %v, want ErrTimeout", err)
+	}
+
+	dev.wedged = false
+	if err := j.Update([]byte("three")); err != nil {
+		t.Fatalf("Update after timeout: %v", err)
+	}
+	j, err = OpenJournal(dev, start, length)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	if got, rev := j.Data(); string(got) != "three" || rev != 2 {
+		t.Errorf("Got data %q revision %d, want %q revision 2", got, rev, "three")
+	}
+}
+
+func TestCancelledBeforeIO(t *testing.T) {
+	md := testonly.NewMemDev(t, 20)
+	md.OnBlockWritten = func(lba uint) {
+		t.Errorf("Unexpected write to block %d", lba)
+	}
+	j, err := OpenJournal(md, 1, 19)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := j.UpdateContext(ctx, []byte("data")); !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("UpdateContext: %v, want context.Canceled", err)
+	}
+	if _, err := OpenJournalContext(ctx, md, 1, 19); !errors.Is(err, context.Canceled) {
+		t.Errorf("OpenJournalContext: %v, want context.Canceled", err)
+	}
+}
+
+func TestWrappedRecord(t *testing.T) {
+	storageBlocks := uint(11)
+	md := testonly.NewMemDev(t, storageBlocks)
+	start, length := uint(1), storageBlocks-1
+
+	var prevData []byte
+	for i, test := range []struct {
+		data               []byte
+		expectedWriteBlock uint
+	}{
+		{data: fill(1400, "One"), expectedWriteBlock: start},            // 3 blocks
+		{data: fill(1900, "Two"), expectedWriteBlock: start + 3},        // 4 blocks
+		{data: fill(1900, "Three"), expectedWriteBlock: start + 3 + 4},  // 4 blocks, wraps after 3
+		{data: fill(1400, "Four"), expectedWriteBlock: start + 1},       // 3 blocks
+		{data: fill(2400, "Five"), expectedWriteBlock: start + 1 + 3},   // 5 blocks
+		{data: fill(900, "Six"), expectedWriteBlock: start + 1 + 3 + 5}, // 2 blocks, wraps after 1
+		{data: fill(20, "All done!"), expectedWriteBlock: start + 1},    // 1 block
+	} {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			j, err := OpenJournal(md, start, length)
+			if err != nil {
+				t.Fatalf("OpenJournal: %v", err)
+			}
+
+			curData, rev := j.Data()
+			if rev != uint32(i) {
+				t.Errorf("Got revision %d, want %d", rev, i)
+			}
+			if got, want := j.nextBlock, test.expectedWriteBlock; got != want {
+				t.Errorf("nextBlock = %d, want %d", got, want)
+			}
+			if prevData != nil && !bytes.Equal(curData, prevData) {
+				t.Errorf("Got data %q, want %q", string(curData), string(prevData))
+			}
+			prevData = test.data
+
+			if err := j.Update(test.data); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+		})
+	}
+}
+
+func TestWrappedWritePowerLoss(t *testing.T) {
+	storageBlocks := uint(11)
+	start, length := uint(1), storageBlocks-1
+	initial := [][]byte{
+		fill(1400, "One"), // 3 blocks
+		fill(1900, "Two"), // 4 blocks
+	}
+	// This record will occupy 5 blocks, the first 3 of which will be at the
+	// end of the journal, and the remaining 2 at the start.
+	wrapped := fill(2400, "Wrapped")
+	const wrappedBlocks = 5
+
+	for failAfter := 1; failAfter <= wrappedBlocks; failAfter++ {
+		t.Run(fmt.Sprintf("failAfter-%d", failAfter), func(t *testing.T) {
+			md := testonly.NewMemDev(t, storageBlocks)
+			j, err := OpenJournal(md, start, length)
+			if err != nil {
+				t.Fatalf("OpenJournal: %v", err)
+			}
+			for _, d := range initial {
+				if err := j.Update(d); err != nil {
+					t.Fatalf("Update: %v", err)
+				}
+			}
+
+			// Take a snapshot of the storage as it would be if power were
+			// lost just after the failAfter-th block of the wrapped record
+			// was written.
+			var snapshot *testonly.MemDev
+			n := 0
+			md.OnBlockWritten = func(uint) {
+				if n++; n == failAfter {
+					snapshot = testonly.NewMemDev(t, storageBlocks)
+					copy(snapshot.Storage, md.Storage)
+				}
+			}
+			if err := j.Update(wrapped); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if snapshot == nil {
+				t.Fatal("Failed to take snapshot")
+			}
+
+			// The journal should recover either the previous record, or the new
+			// one if all of its blocks made it to storage.
+			wantRev, wantData, wantNext := uint32(2), initial[1], start+3+4
+			if failAfter == wrappedBlocks {
+				wantRev, wantData, wantNext = 3, wrapped, start+2
+			}
+			j, err = OpenJournal(snapshot, start, length)
+			if err != nil {
+				t.Fatalf("OpenJournal: %v", err)
+			}
+			gotData, gotRev := j.Data()
+			if gotRev != wantRev {
+				t.Errorf("Got revision %d, want %d", gotRev, wantRev)
+			}
+			if !bytes.Equal(gotData, wantData) {
+				t.Errorf("Got data %q, want %q", gotData, wantData)
+			}
+			if got := j.nextBlock; got != wantNext {
+				t.Errorf("nextBlock = %d, want %d", got, wantNext)
+			}
+
+			// And we should be able to carry on using the journal.
+			next := fill(1000, "Next")
+			if err := j.Update(next); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			j, err = OpenJournal(snapshot, start, length)
+			if err != nil {
+				t.Fatalf("OpenJournal: %v", err)
+			}
+			if gotData, gotRev := j.Data(); gotRev != wantRev+1 || !bytes.Equal(gotData, next) {
+				t.Errorf("Got rev %d data %q, want rev %d data %q", gotRev, gotData, wantRev+1, next)
+			}
+		})
+	}
+}
+
 // fill returns a slice of length n containing as many repeats of s as necessary
 // to fill it (including partial at the end if needed).
 func fill(n int, s string) []byte {
@@ -0,0 +1,225 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// SlotHealth describes the state of the journal in a single slot, as found by Scrub.
+type SlotHealth struct {
+	// Slot is the index of the slot in the partition.
+	Slot uint
+	// Revision is the revision of the current entry in the slot's journal, or
+	// zero if the slot has never been successfully written to.
+	Revision uint32
+	// Valid is the number of valid entries found in the journal.
+	Valid uint
+	// Torn is the number of entries found which were not completely written,
+	// i.e. an entry with an incorrect SHA256 at the location of the next write.
+	// This is expected following a power failure or reboot during a write.
+	Torn uint
+	// BadHash is the number of entries found whose data does not match the
+	// SHA256 in their header, other than those counted in Torn.
+	// This indicates that previously written data has become corrupt.
+	BadHash uint
+	// Unreadable is the number of entries whose data matches their SHA256,
+	// but which can't be decrypted and authenticated, or which are plaintext
+	// when the partition doesn't allow that. These aren't counted in Valid.
+	Unreadable uint
+	// DuplicateRevisions lists the revisions which were found in more than
+	// one valid entry.
+	DuplicateRevisions []uint32
+	// Error describes any problem encountered while reading or opening the slot.
+	Error string `json:",omitempty"`
+}
+
+// Healthy returns true if no signs of corruption were found in the slot.
+func (h SlotHealth) Healthy() bool {
+	return h.BadHash == 0 && h.Unreadable == 0 && len(h.DuplicateRevisions) == 0 && h.Error == ""
+}
+
+// Scrub checks the integrity of the journal in every slot in the partition,
+// and returns a report on the health of each slot.
+//
+// Whereas opening a slot will quietly fall back to the last good entry in its
+// journal, Scrub examines every entry it can find in order to surface
+// corruption which would otherwise go unnoticed.
+// Any writes to a slot which are pending write-back are flushed before it's
+// checked, so that the journal on storage is up to date.
+// If ctx becomes done before all slots have been checked, the reports for the
+// slots checked so far are returned along with the context's error.
+func (p *Partition) Scrub(ctx context.Context) ([]SlotHealth, error) {
+	ret := make([]SlotHealth, 0, len(p.slots))
+	for i := range p.slots {
+		if err := ContextErr(ctx); err != nil {
+			return ret, err
+		}
+		h, err := p.scrubSlot(ctx, i)
+		if err != nil {
+			return ret, err
+		}
+		if !h.Healthy() {
+			klog.Warningf("Scrub found problems with slot %d: %+v", i, h)
+		}
+		ret = append(ret, h)
+	}
+	return ret, nil
+}
+
+// scrubSlot checks the integrity of the journal in the given slot.
+// An error is only returned if ctx becomes done, any other problems are
+// described in the returned SlotHealth.
+func (p *Partition) scrubSlot(ctx context.Context, i int) (SlotHealth, error) {
+	s := &p.slots[i]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(ctx); err != nil {
+		if cErr := ContextErr(ctx); cErr != nil {
+			return SlotHealth{}, cErr
+		}
+		return SlotHealth{
+			Slot:  uint(i),
+			Error: fmt.Sprintf("failed to flush pending writes: %v", err),
+		}, nil
+	}
+	// Read the whole slot in one go, rather than making many small reads
+	// while scanning the journal.
+	b := make([]byte, s.length*p.dev.BlockSize())
+	if err := readBlocks(ctx, p.dev, s.start, b); err != nil {
+		if cErr := ContextErr(ctx); cErr != nil {
+			return SlotHealth{}, cErr
+		}
+		return SlotHealth{
+			Slot:  uint(i),
+			Error: fmt.Sprintf("failed to read slot: %v", err),
+		}, nil
+	}
+	// The journal is opened as it would be by the slot, so that its data is
+	// decrypted, except that it's scanned in full rather than starting from
+	// the index, and there's no need for read-ahead.
+	opts := p.journalOpts(uint(i))
+	opts.readAhead = ReadAhead{}
+	h := scrubJournal(&memBlocks{bs: p.dev.BlockSize(), start: s.start, b: b}, s.start, s.length, opts)
+	h.Slot = uint(i)
+	return h, nil
+}
+
+// scrubJournal checks the integrity of every entry in the journal stored in
+// the [start, start+length) range of blocks accessible via dev, which is
+// opened with opts.
+func scrubJournal(dev BlockReaderWriter, start, length uint, opts journalOpts) SlotHealth {
+	h := SlotHealth{}
+	j, err := openJournal(context.Background(), dev, start, length, opts)
+	if err != nil {
+		h.Error = err.Error()
+	} else {
+		h.Revision = j.current.Revision
+	}
+
+	seen := make(map[uint32]bool)
+	scanEntries(context.Background(), dev, start, length, func(lba, _ uint, e *entry, err error) {
+		if err != nil {
+			// The header is ok, but the data isn't. If this is where the next
+			// write would have gone, then it's a failed write.
+			if j != nil && lba == j.nextBlock && e.Revision == j.current.Revision+1 {
+				h.Torn++
+			} else {
+				h.BadHash++
+			}
+			return
+		}
+		if seen[e.Revision] {
+			h.DuplicateRevisions = append(h.DuplicateRevisions, e.Revision)
+		}
+		seen[e.Revision] = true
+		if opts.seal != nil {
+			if _, err := opts.seal.open(e.Revision, e.Data); err != nil {
+				h.Unreadable++
+				return
+			}
+		}
+		h.Valid++
+	})
+	return h
+}
+
+// scanEntries calls fn for every entry header found in the journal stored in
+// the [start, start+length) range of blocks accessible via dev, in block order.
+//
+// For a valid entry, next is the address of the block following it. If the
+// entry's data doesn't match the SHA256 in its header, err describes the
+// problem, and since the header's length field can't be trusted the scan
+// continues from the block following lba.
+func scanEntries(ctx context.Context, dev BlockReaderWriter, start, length uint, fn func(lba, next uint, e *entry, err error)) {
+	for lba := start; lba < start+length; {
+		br := newBlockReader(ctx, dev, start, length, lba)
+		e, err := unmarshalEntry(br)
+		if e == nil {
+			// Not an entry header, keep looking.
+			lba++
+			continue
+		}
+		if err != nil {
+			fn(lba, lba+1, e, err)
+			lba++
+			continue
+		}
+		fn(lba, br.lba, e, nil)
+		if br.lba <= lba {
+			// The entry wrapped around, and we've already scanned the blocks
+			// at the start of the journal.
+			break
+		}
+		lba = br.lba
+	}
+}
+
+// memBlocks is a read-only BlockReaderWriter which serves reads from an
+// in-memory copy of a contiguous range of blocks starting at start.
+type memBlocks struct {
+	bs    uint
+	start uint
+	b     []byte
+}
+
+// BlockSize returns the block size of the underlying storage system.
+func (m *memBlocks) BlockSize() uint {
+	return m.bs
+}
+
+// ReadBlocks reads len(b) bytes into b from contiguous storage blocks starting
+// at the given block address.
+func (m *memBlocks) ReadBlocks(lba uint, b []byte) error {
+	if lba < m.start {
+		return fmt.Errorf("lba (%d) is before the first block (%d)", lba, m.start)
+	}
+	o := (lba - m.start) * m.bs
+	if o+uint(len(b)) > uint(len(m.b)) {
+		return fmt.Errorf("read of %d bytes at lba %d is out of range", len(b), lba)
+	}
+	copy(b, m.b[o:])
+	return nil
+}
+
+// WriteBlocks always fails, since memBlocks is read-only.
+func (m *memBlocks) WriteBlocks(uint, []byte) (uint, error) {
+	return 0, errors.New("read-only")
+}
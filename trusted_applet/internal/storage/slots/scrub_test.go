@@ -0,0 +1,222 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+func TestScrub(t *testing.T) {
+	const (
+		start  = 1
+		length = 10
+	)
+	for _, test := range []struct {
+		name string
+		// setup writes some entries to the journal, and then optionally
+		// damages them.
+		setup func(t *testing.T, md *testonly.MemDev, j *Journal)
+		want  SlotHealth
+	}{
+		{
+			name:  "empty",
+			setup: func(*testing.T, *testonly.MemDev, *Journal) {},
+			want:  SlotHealth{},
+		}, {
+			name: "healthy",
+			setup: func(t *testing.T, _ *testonly.MemDev, j *Journal) {
+				mustUpdate(t, j, fill(1400, "one"), fill(1400, "two"), fill(1400, "three"))
+			},
+			want: SlotHealth{Revision: 3, Valid: 3},
+		}, {
+			name: "healthy, wrapped",
+			setup: func(t *testing.T, _ *testonly.MemDev, j *Journal) {
+				mustUpdate(t, j, fill(1400, "one"), fill(1400, "two"), fill(1400, "three"), fill(1400, "four"))
+			},
+			// "one" has been overwritten by the wrapped tail of "four".
+			want: SlotHealth{Revision: 4, Valid: 3},
+		}, {
+			name: "torn write",
+			setup: func(t *testing.T, md *testonly.MemDev, j *Journal) {
+				mustUpdate(t, j, fill(1400, "one"), fill(1400, "two"))
+				// Only the first block of the next write makes it to storage.
+				md.OnBlockWritten = func(lba uint) {
+					if lba != start+6 {
+						md.Storage[lba] = [testonly.MemBlockSize]byte{}
+					}
+				}
+				if err := j.Update(fill(1400, "three")); err == nil {
+					t.Fatal("Update succeeded, want error")
+				}
+			},
+			want: SlotHealth{Revision: 2, Valid: 2, Torn: 1},
+		}, {
+			name: "corrupt old entry",
+			setup: func(t *testing.T, md *testonly.MemDev, j *Journal) {
+				mustUpdate(t, j, fill(1400, "one"), fill(1400, "two"), fill(1400, "three"))
+				md.Storage[start+1][0] ^= 0x42
+			},
+			want: SlotHealth{Revision: 3, Valid: 2, BadHash: 1},
+		}, {
+			name: "duplicate revision",
+			setup: func(t *testing.T, md *testonly.MemDev, j *Journal) {
+				mustUpdate(t, j, fill(100, "one"), fill(100, "two"))
+				// Copy revision 2 into an otherwise unused block.
+				md.Storage[start+5] = md.Storage[start+1]
+			},
+			want: SlotHealth{
+				Revision:           2,
+				Valid:              3,
+				DuplicateRevisions: []uint32{2},
+			},
+		}, {
+			name: "duplicate current revision",
+			setup: func(t *testing.T, md *testonly.MemDev, j *Journal) {
+				mustUpdate(t, j, fill(100, "one"), fill(100, "two"))
+				// Copy revision 2 into the block where the next write would go.
+				md.Storage[start+2] = md.Storage[start+1]
+			},
+			want: SlotHealth{
+				Valid:              3,
+				DuplicateRevisions: []uint32{2},
+				Error:              "journal is corrupt at block 3: found two entries with the same revision (2)",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			md := testonly.NewMemDev(t, start+length)
+			p, err := OpenPartition(md, Geometry{Start: start, Length: length, SlotLengths: []uint{length}})
+			if err != nil {
+				t.Fatalf("OpenPartition: %v", err)
+			}
+			j, err := OpenJournal(md, start, length)
+			if err != nil {
+				t.Fatalf("OpenJournal: %v", err)
+			}
+			test.setup(t, md, j)
+
+			got, err := p.Scrub(context.Background())
+			if err != nil {
+				t.Fatalf("Scrub: %v", err)
+			}
+			if diff := cmp.Diff([]SlotHealth{test.want}, got); diff != "" {
+				t.Fatalf("Got diff: %s", diff)
+			}
+			if got, want := got[0].Healthy(), test.want.BadHash == 0 && test.want.Unreadable == 0 && test.want.DuplicateRevisions == nil && test.want.Error == ""; got != want {
+				t.Errorf("Healthy() = %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestScrubEncrypted(t *testing.T) {
+	p, md := memPartition(t)
+	// Slot 1 predates encryption.
+	mustWrite(t, p, 1, "plain")
+	e := Encryption{AEAD: mustAEAD(t, 1)}
+	mustWrite(t, encryptedPartition(t, md, e), 3, "one")
+	mustWrite(t, encryptedPartition(t, md, e), 3, "two")
+
+	type health struct {
+		Revision, Valid, Unreadable uint
+		Error                       bool
+	}
+	for _, test := range []struct {
+		name string
+		enc  Encryption
+		want map[uint]health
+	}{
+		{
+			name: "right key",
+			enc:  e,
+			want: map[uint]health{
+				1: {Unreadable: 1, Error: true},
+				3: {Revision: 2, Valid: 2},
+			},
+		}, {
+			name: "plaintext allowed",
+			enc:  Encryption{AEAD: e.AEAD, AllowPlaintext: true},
+			want: map[uint]health{
+				1: {Revision: 1, Valid: 1},
+				3: {Revision: 2, Valid: 2},
+			},
+		}, {
+			name: "wrong key",
+			enc:  Encryption{AEAD: mustAEAD(t, 2)},
+			want: map[uint]health{
+				3: {Unreadable: 2, Error: true},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := encryptedPartition(t, md, test.enc).Scrub(context.Background())
+			if err != nil {
+				t.Fatalf("Scrub: %v", err)
+			}
+			for i, want := range test.want {
+				h := r[i]
+				got := health{Revision: uint(h.Revision), Valid: h.Valid, Unreadable: h.Unreadable, Error: h.Error != ""}
+				if got != want {
+					t.Errorf("Slot %d: got %+v, want %+v", i, got, want)
+				}
+				if h.Healthy() != (want == health{Revision: want.Revision, Valid: want.Valid}) {
+					t.Errorf("Slot %d: Healthy() = %t for %+v", i, h.Healthy(), h)
+				}
+			}
+		})
+	}
+}
+
+func TestScrubCancelled(t *testing.T) {
+	p, _ := memPartition(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.Scrub(ctx); err != context.Canceled {
+		t.Fatalf("Scrub: %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestScrubFlushesWriteBack(t *testing.T) {
+	p, _ := memPartition(t)
+	p.EnableWriteBack(context.Background(), time.Hour)
+	mustWrite(t, p, 3, "one")
+	mustWrite(t, p, 3, "two")
+
+	r, err := p.Scrub(context.Background())
+	if err != nil {
+		t.Fatalf("Scrub: %v", err)
+	}
+	// The two writes are coalesced into a single journal entry.
+	if h := r[3]; h.Revision != 1 || h.Valid != 1 || !h.Healthy() {
+		t.Errorf("Slot 3: got %+v, want revision 1 with 1 valid entry", h)
+	}
+	if d, _ := openAndRead(t, mustReopen(t, p), 3); string(d) != "two" {
+		t.Errorf("Got %q from storage after scrub, want two", d)
+	}
+}
+
+func mustUpdate(t *testing.T, j *Journal, data ...[]byte) {
+	t.Helper()
+	for _, d := range data {
+		if err := j.Update(d); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+}
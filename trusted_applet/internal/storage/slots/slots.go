@@ -15,6 +15,8 @@
 package slots
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -36,16 +38,32 @@ type Geometry struct {
 	// to one or more slots, the values specified in this list at the time the data
 	// was written are changed.
 	SlotLengths []uint
+	// TableBlocks is the number of blocks at the start of the partition which
+	// are reserved for the partition table, with the slots following them.
+	// It must be even, since two copies of the table are kept.
+	//
+	// If zero, no partition table is used and the layout is trusted as given.
+	TableBlocks uint
+	// IndexBlocks is the number of blocks, following the partition table,
+	// which are reserved for journal index superblocks, with the slots
+	// following them. The superblock for slot i is stored in the i-th of these
+	// blocks, and any slots beyond the first IndexBlocks slots are not indexed.
+	//
+	// If zero, journals are not indexed and must be scanned when opened.
+	IndexBlocks uint
 }
 
 // Validate checks that the geometry is self-consistent.
 func (g Geometry) Validate() error {
-	t := uint(0)
+	if g.TableBlocks%2 != 0 {
+		return fmt.Errorf("invalid geometry: table blocks (%d) must be even", g.TableBlocks)
+	}
+	t := g.TableBlocks + g.IndexBlocks
 	for _, l := range g.SlotLengths {
 		t += l
 	}
 	if t > g.Length {
-		return fmt.Errorf("invalid geometry: total slot length (%d blocks) exceeds overall length (%d blocks)", t, g.Length)
+		return fmt.Errorf("invalid geometry: total slot, table, and index length (%d blocks) exceeds overall length (%d blocks)", t, g.Length)
 	}
 	return nil
 }
@@ -58,25 +76,50 @@ type Partition struct {
 
 	// slots describes the layout of the slot(s) stored within this partition.
 	slots []Slot
+
+	// wb coordinates deferred writes to the slots, and is nil unless
+	// EnableWriteBack has been called.
+	wb *writeBack
+
+	// readAhead configures the cache used when scanning slot journals.
+	readAhead ReadAhead
+	// lazy is true if opening slots is deferred until they're first used.
+	lazy bool
+	// enc configures the encryption of slot data, and is nil if slot data is
+	// stored in plaintext.
+	enc *Encryption
 }
 
 // OpenPartition returns a partition struct for accessing the slots described by the given
 // geometry using the provided read/write methods.
+//
+// If the geometry reserves space for a partition table, the table is checked
+// against the geometry: if no table is present one is created, a table for a
+// smaller but otherwise identical layout is grown to match, and any other
+// difference causes ErrGeometryMismatch to be returned.
 func OpenPartition(rw BlockReaderWriter, geo Geometry) (*Partition, error) {
 	if err := geo.Validate(); err != nil {
 		return nil, err
 	}
+	if geo.TableBlocks > 0 {
+		if err := checkTable(rw, geo); err != nil {
+			return nil, err
+		}
+	}
 
 	ret := &Partition{
-		dev: rw,
+		dev:       rw,
+		readAhead: DefaultReadAhead,
 	}
 
-	b := geo.Start
-	for _, l := range geo.SlotLengths {
-		ret.slots = append(ret.slots, Slot{
-			start:  b,
-			length: l,
-		})
+	b := geo.Start + geo.TableBlocks + geo.IndexBlocks
+	ret.slots = make([]Slot, len(geo.SlotLengths))
+	for i, l := range geo.SlotLengths {
+		s := &ret.slots[i]
+		s.number, s.start, s.length = uint(i), b, l
+		if uint(i) < geo.IndexBlocks {
+			s.index = &journalIndex{lba: geo.Start + geo.TableBlocks + uint(i)}
+		}
 		b += l
 	}
 
@@ -84,17 +127,20 @@ func OpenPartition(rw BlockReaderWriter, geo Geometry) (*Partition, error) {
 }
 
 // Erase destroys the data stored in all slots configured in this partition.
+// An attempt is made to erase every slot, even if some fail, and the returned
+// error describes each of the slots which could not be erased.
 // WARNING: Data Loss!
 func (p *Partition) Erase() error {
 	klog.Info("Erasing partition")
-	borked := false
+	var errs []error
 	for i := range p.slots {
 		if err := p.eraseSlot(i); err != nil {
 			klog.Warningf("Failed to erase slot %d: %v", i, err)
+			errs = append(errs, err)
 		}
 	}
-	if borked {
-		return errors.New("failed to erase one or more slots in partition")
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to erase %d of %d slots in partition: %w", len(errs), len(p.slots), errors.Join(errs...))
 	}
 	return nil
 }
@@ -103,76 +149,238 @@ func (p *Partition) eraseSlot(i int) error {
 	p.slots[i].mu.Lock()
 	defer p.slots[i].mu.Unlock()
 
-	// Invalidate journal since we're erasing data from underneath it.
-	p.slots[i].journal = nil
+	// Invalidate journal since we're erasing data from underneath it, and
+	// discard any writes which haven't made it to the journal yet.
+	p.slots[i].journal, p.slots[i].openErr = nil, nil
+	p.slots[i].discardPending()
 
 	klog.Infof("Erasing partition slot %d @ block %d len %d blocks", i, p.slots[i].start, p.slots[i].length)
-	length := p.slots[i].length * p.dev.BlockSize()
-	start := p.slots[i].start
-	b := make([]byte, length)
-	if _, err := p.dev.WriteBlocks(start, b); err != nil {
+	// Clear the index first, so that it can never point into erased data.
+	if idx := p.slots[i].index; idx != nil {
+		if err := idx.clear(context.Background(), p.dev); err != nil {
+			return fmt.Errorf("slot %d: %v", i, err)
+		}
+	}
+	start, length := p.slots[i].start, p.slots[i].length
+	b := make([]byte, length*p.dev.BlockSize())
+	n, err := p.dev.WriteBlocks(start, b)
+	if err != nil {
 		return fmt.Errorf("slot %d occupying blocks [%d, %d): %v", i, start, start+length, err)
 	}
+	if n != length {
+		return fmt.Errorf("slot %d occupying blocks [%d, %d): only erased %d blocks", i, start, start+length, n)
+	}
 	return nil
 }
 
 // Open opens the specified slot, returns an error if the slot is out of bounds.
 func (p *Partition) Open(slot uint) (*Slot, error) {
+	return p.OpenContext(context.Background(), slot)
+}
+
+// OpenContext behaves like Open, but gives up reading the slot's journal if
+// ctx becomes done.
+func (p *Partition) OpenContext(ctx context.Context, slot uint) (*Slot, error) {
 	if l := uint(len(p.slots)); slot >= l {
 		return nil, fmt.Errorf("invalid slot %d (partition has %d slots)", slot, l)
 	}
 	s := &p.slots[slot]
+	if p.lazy {
+		s.deferOpen(p.dev, p.journalOpts(slot))
+		return s, nil
+	}
 	klog.V(2).Infof("Opening slot %d", slot)
-	if err := s.Open(p.dev); err != nil {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(ctx, p.dev, p.journalOpts(slot)); err != nil {
 		klog.V(2).Infof("Failed to open slot %d: %v", slot, err)
 	}
 
 	return s, nil
 }
 
+// journalOpts returns the options used to open the journal in the given slot.
+func (p *Partition) journalOpts(slot uint) journalOpts {
+	o := journalOpts{readAhead: p.readAhead}
+	if p.enc != nil {
+		o.seal = &sealer{Encryption: *p.enc, slot: slot}
+	}
+	return o
+}
+
+// SetReadAhead configures the cache used to scan the journals of slots opened
+// after this call. The zero value disables read-ahead.
+//
+// Must not be called concurrently with other methods on the partition.
+func (p *Partition) SetReadAhead(ra ReadAhead) {
+	p.readAhead = ra
+}
+
+// SetLazyOpen configures whether Open defers scanning a slot's journal until
+// the slot is first read from or written to.
+// This makes Open cheap, at the cost of storage errors only surfacing on first
+// use, and of reads of lazily opened slots not being bounded by a context.
+//
+// Must not be called concurrently with other methods on the partition.
+func (p *Partition) SetLazyOpen(lazy bool) {
+	p.lazy = lazy
+}
+
 // NumSlots returns the number of slots configured in this partition.
 func (p *Partition) NumSlots() int {
 	return len(p.slots)
 }
 
+// errNotOpen is returned when attempting to use a slot which has not been
+// opened, or which has been erased since it was opened.
+var errNotOpen = errors.New("slot is not open")
+
 // Slot represents the current data in a slot.
 type Slot struct {
 	// mu guards access to this Slot.
 	mu sync.RWMutex
 
+	// number is the slot's index within its partition.
+	number uint
 	// start and length define the on-storage blocks assigned to this journal:
 	// [start, start+length).
 	start, length uint
+	// index locates the journal's index superblock, and is nil if the journal
+	// isn't indexed.
+	index *journalIndex
 
 	// journal is the underlying journal used to store the data in this slot.
 	// if it's nil, it hasn't yet been opened and will be opened upon first
 	// access.
 	journal *Journal
+	// openErr is the reason that the journal failed to open, if it did.
+	openErr error
+
+	// revision is the token returned by Read, and is incremented by every
+	// successful write to the slot.
+	// This usually matches the journal's revision, but runs ahead of it when
+	// writes are coalesced.
+	revision uint32
+
+	// wb is the partition's write-back state, or nil if writes go straight
+	// to the journal.
+	wb *writeBack
+	// dirty is true if pending holds data which has been written to the
+	// slot, but not yet flushed to the journal.
+	dirty   bool
+	pending []byte
+
+	// lazyDev is set if the partition deferred opening the slot until its
+	// first use, in which case the journal will be opened using lazyDev and
+	// lazyOpts.
+	lazyDev  BlockReaderWriter
+	lazyOpts journalOpts
+}
+
+// notOpen returns the error to report when the slot is used without having
+// been opened, which includes the reason that opening it failed, if it did.
+// Must be called with s.mu at least read-locked.
+func (s *Slot) notOpen() error {
+	if s.openErr != nil {
+		return fmt.Errorf("%w: %w", errNotOpen, s.openErr)
+	}
+	return errNotOpen
 }
 
 // Open prepares the slot for use.
 // This method is idempotent and will not return an error if called multiple times.
 func (s *Slot) Open(dev BlockReaderWriter) error {
+	return s.OpenContext(context.Background(), dev)
+}
+
+// OpenContext behaves like Open, but gives up reading the journal if ctx
+// becomes done. The slot remains unopened in that case, and may be opened
+// again later.
+func (s *Slot) OpenContext(ctx context.Context, dev BlockReaderWriter) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.open(ctx, dev, journalOpts{})
+}
 
+// open scans the slot's journal, if it's not already open.
+// Must be called with s.mu write-locked.
+func (s *Slot) open(ctx context.Context, dev BlockReaderWriter, opts journalOpts) error {
 	if s.journal != nil {
 		return nil
 	}
-	j, err := OpenJournal(dev, s.start, s.length)
+	opts.index = s.index
+	j, err := openJournal(ctx, dev, s.start, s.length, opts)
 	if err != nil {
-		return fmt.Errorf("failed to open journal: %v", err)
+		setCorruptSlot(err, s.number)
+		s.openErr = fmt.Errorf("failed to open journal: %w", err)
+		return s.openErr
 	}
-	s.journal = j
+	s.journal, s.openErr = j, nil
+	_, s.revision = j.Data()
 	return nil
 }
 
+// deferOpen arranges for the slot to be opened on first use, rather than now.
+func (s *Slot) deferOpen(dev BlockReaderWriter, opts journalOpts) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		s.lazyDev, s.lazyOpts = dev, opts
+	}
+}
+
+// openDeferred opens the slot if its opening was deferred until first use.
+// Must be called with s.mu write-locked.
+func (s *Slot) openDeferred(ctx context.Context) error {
+	if s.journal != nil || s.lazyDev == nil {
+		return nil
+	}
+	return s.open(ctx, s.lazyDev, s.lazyOpts)
+}
+
+// openLazily opens the slot ahead of a read, if its opening was deferred until
+// first use.
+func (s *Slot) openLazily() error {
+	s.mu.RLock()
+	deferred := s.journal == nil && s.lazyDev != nil
+	s.mu.RUnlock()
+	if !deferred {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.openDeferred(context.Background())
+}
+
 // Read returns the last data successfully written to the slot, along with a token
 // which can be used with CheckAndWrite.
 func (s *Slot) Read() ([]byte, uint32, error) {
+	if err := s.openLazily(); err != nil {
+		return nil, 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.journal == nil {
+		return nil, 0, s.notOpen()
+	}
+	if s.dirty {
+		return s.pending, s.revision, nil
+	}
+	return s.journal.current.Data, s.revision, nil
+}
+
+// Capacity returns the largest number of bytes which can be written to the
+// slot.
+func (s *Slot) Capacity() (int, error) {
+	if err := s.openLazily(); err != nil {
+		return 0, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.journal.current.Data, s.journal.current.Revision, nil
+	if s.journal == nil {
+		return 0, s.notOpen()
+	}
+	return s.journal.capacity(), nil
 }
 
 // Write writes the provided data to the slot.
@@ -180,20 +388,86 @@ func (s *Slot) Read() ([]byte, uint32, error) {
 // until another successful Write call is mode.
 // If the call to Write fails, future calls to Read will return the previous
 // successfully written data, if any.
+//
+// If write-back is enabled on the partition, the data may not be durably
+// stored until the next call to Sync or Partition.Flush.
 func (s *Slot) Write(p []byte) error {
+	return s.WriteContext(context.Background(), p)
+}
+
+// WriteContext behaves like Write, but gives up if ctx becomes done before the
+// data has been written to storage.
+func (s *Slot) WriteContext(ctx context.Context, p []byte) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.journal.Update(p)
+	if err := s.openDeferred(ctx); err != nil {
+		return err
+	}
+	if s.journal == nil {
+		return s.notOpen()
+	}
+	return s.write(ctx, p)
 }
 
 // CheckAndWrite behaves like Write, with the exception that it will immediately
-// return an error if the slot has been successfully written to since the Read call
-// which produced the passed-in token.
-func (s *Slot) CheckAndWrite(token uint32, p []byte) error {
+// return ErrConflict if the slot has been successfully written to since the Read
+// call which produced the passed-in token.
+// On success, it returns the token for the data just written, which can be
+// passed to a subsequent call to CheckAndWrite.
+func (s *Slot) CheckAndWrite(token uint32, p []byte) (uint32, error) {
+	return s.CheckAndWriteContext(context.Background(), token, p)
+}
+
+// CheckAndWriteContext behaves like CheckAndWrite, but gives up if ctx becomes
+// done before the data has been written to storage.
+func (s *Slot) CheckAndWriteContext(ctx context.Context, token uint32, p []byte) (uint32, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.journal.current.Revision != token {
-		return errors.New("invalid token, slot updated since then")
+	if err := s.openDeferred(ctx); err != nil {
+		return 0, err
+	}
+	if s.journal == nil {
+		return 0, s.notOpen()
+	}
+	if s.revision != token {
+		return 0, ErrConflict
+	}
+	if err := s.write(ctx, p); err != nil {
+		return 0, err
+	}
+	return s.revision, nil
+}
+
+// writeThrough stores p directly in the slot's journal, even if write-back is
+// enabled. The slot must not hold pending data.
+// Must be called with s.mu write-locked.
+func (s *Slot) writeThrough(ctx context.Context, p []byte) error {
+	if err := s.journal.UpdateContext(ctx, p); err != nil {
+		return err
+	}
+	s.revision++
+	return nil
+}
+
+// write stores p in the slot, either directly to the journal or as a pending
+// write if write-back is enabled.
+// Must be called with s.mu write-locked.
+func (s *Slot) write(ctx context.Context, p []byte) error {
+	if s.wb == nil {
+		if err := s.journal.UpdateContext(ctx, p); err != nil {
+			return err
+		}
+		s.revision++
+		return nil
 	}
-	return s.journal.Update(p)
+	// Fail early rather than at flush time, when it's too late to tell the caller.
+	if err := s.journal.checkSize(len(p)); err != nil {
+		return err
+	}
+	s.wb.markDirty(s, s.dirty)
+	// Take a copy, since the caller may reuse p after we return.
+	s.pending = bytes.Clone(p)
+	s.dirty = true
+	s.revision++
+	return nil
 }
@@ -15,7 +15,9 @@
 package slots
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -179,6 +181,109 @@ func TestErase(t *testing.T) {
 	}
 }
 
+func TestEraseReportsFailures(t *testing.T) {
+	// The last two slots extend beyond the end of the device, so erasing them
+	// will fail.
+	md := testonly.NewMemDev(t, 16)
+	p, err := OpenPartition(md, Geometry{
+		Start:       10,
+		Length:      10,
+		SlotLengths: []uint{1, 1, 2, 4, 2},
+	})
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	err = p.Erase()
+	if err == nil {
+		t.Fatal("Erase succeeded, want error")
+	}
+	for _, want := range []string{"2 of 5 slots", "slot 3 ", "slot 4 "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Erase: %q, want error containing %q", err, want)
+		}
+	}
+}
+
+func TestConflict(t *testing.T) {
+	p, _ := memPartition(t)
+	s, err := p.Open(2)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	_, tok, err := s.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	next, err := s.CheckAndWrite(tok, []byte("one"))
+	if err != nil {
+		t.Fatalf("CheckAndWrite: %v", err)
+	}
+	if _, err := s.CheckAndWrite(tok, []byte("two")); !errors.Is(err, ErrConflict) {
+		t.Errorf("CheckAndWrite with stale token: %v, want ErrConflict", err)
+	}
+	// The returned token can be used for the next write without reading the
+	// slot again.
+	if _, err := s.CheckAndWrite(next, []byte("two")); err != nil {
+		t.Fatalf("CheckAndWrite with returned token: %v", err)
+	}
+	if _, got, err := s.Read(); err != nil || got != next+1 {
+		t.Errorf("Read = %d, %v, want token %d", got, err, next+1)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	p, _ := memPartition(t)
+	s, err := p.Open(2)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	c, err := s.Capacity()
+	if err != nil {
+		t.Fatalf("Capacity: %v", err)
+	}
+	if err := s.Write(make([]byte, c)); err != nil {
+		t.Errorf("Write of %d bytes: %v", c, err)
+	}
+	if err := s.Write(make([]byte, c+1)); err == nil {
+		t.Errorf("Write of %d bytes succeeded, want error", c+1)
+	}
+}
+
+func TestCorruptError(t *testing.T) {
+	p, md := memPartition(t)
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, d := range []string{"one", "two"} {
+		if err := s.Write([]byte(d)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// Copy revision 2 into the block where the next write would go.
+	md.Storage[16] = md.Storage[15]
+
+	p, err = OpenPartition(md, Geometry{Start: 10, Length: 10, SlotLengths: []uint{1, 1, 2, 4}})
+	if err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+	s, err = p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	_, _, err = s.Read()
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Read: %v, want ErrCorrupt", err)
+	}
+	var ce *CorruptError
+	if !errors.As(err, &ce) || ce.Slot != 3 || ce.LBA != 16 {
+		t.Errorf("Read: %v, want CorruptError for slot 3 at block 16", err)
+	}
+	if err := s.Write([]byte("three")); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Write: %v, want ErrCorrupt", err)
+	}
+}
+
 func openAndRead(t *testing.T, p *Partition, i uint) ([]byte, uint32) {
 	t.Helper()
 	s, err := p.Open(uint(i))
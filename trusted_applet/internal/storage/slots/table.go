@@ -0,0 +1,273 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TableFormatVersion is the version of the partition table format written
+	// by this code.
+	//
+	// Version 2 added the number of index blocks.
+	TableFormatVersion = 2
+
+	// tableHeaderSize is the size of the fixed part of a marshalled table:
+	// magic, version, generation, start, length, table blocks, index blocks,
+	// and run count.
+	tableHeaderSize = 4 + 4 + 4 + 8 + 8 + 4 + 4 + 4
+	// tableHeaderSizeV1 is the size of the fixed part of a version 1 table,
+	// which has no index blocks field.
+	tableHeaderSizeV1 = tableHeaderSize - 4
+	// tableRunSize is the size of a single marshalled run of slot lengths.
+	tableRunSize = 4 + 4
+)
+
+var (
+	// tableMagic identifies a block as holding a partition table.
+	tableMagic = [4]byte{'T', 'F', 'P', '0'}
+
+	// ErrGeometryMismatch is returned by OpenPartition if the requested
+	// geometry is incompatible with the one recorded in the partition table.
+	ErrGeometryMismatch = errors.New("geometry does not match partition table")
+)
+
+// partitionTable is the on-storage record of a partition's Geometry.
+//
+// Two copies of the table are kept in the blocks reserved at the start of the
+// partition, each occupying half of them. Updates are written over the copy
+// with the older generation so that a failed write always leaves the other
+// copy intact.
+type partitionTable struct {
+	// Version is the format version of the table.
+	Version uint32
+	// Generation is incremented every time the table is rewritten.
+	Generation uint32
+	// Geometry is the layout of the partition described by the table.
+	Geometry Geometry
+}
+
+// marshal returns the serialised form of the table.
+//
+// Runs of identically sized slots are stored as a single (count, length) pair,
+// so the common case of a partition tiled with fixed size slots takes very
+// little space.
+func (t partitionTable) marshal() []byte {
+	type run struct{ count, length uint32 }
+	var runs []run
+	for _, l := range t.Geometry.SlotLengths {
+		if n := len(runs); n > 0 && runs[n-1].length == uint32(l) {
+			runs[n-1].count++
+			continue
+		}
+		runs = append(runs, run{count: 1, length: uint32(l)})
+	}
+
+	b := make([]byte, 0, tableHeaderSize+len(runs)*tableRunSize+sha256.Size)
+	b = append(b, tableMagic[:]...)
+	b = binary.BigEndian.AppendUint32(b, t.Version)
+	b = binary.BigEndian.AppendUint32(b, t.Generation)
+	b = binary.BigEndian.AppendUint64(b, uint64(t.Geometry.Start))
+	b = binary.BigEndian.AppendUint64(b, uint64(t.Geometry.Length))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Geometry.TableBlocks))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Geometry.IndexBlocks))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(runs)))
+	for _, r := range runs {
+		b = binary.BigEndian.AppendUint32(b, r.count)
+		b = binary.BigEndian.AppendUint32(b, r.length)
+	}
+	h := sha256.Sum256(b)
+	return append(b, h[:]...)
+}
+
+// unmarshalTable parses a table from the provided bytes, which may have
+// trailing padding.
+//
+// Returns errNoTable if the bytes do not start with the table magic.
+func unmarshalTable(b []byte) (partitionTable, error) {
+	if len(b) < len(tableMagic) || !bytes.Equal(b[:len(tableMagic)], tableMagic[:]) {
+		return partitionTable{}, errNoTable
+	}
+	if len(b) < tableHeaderSizeV1 {
+		return partitionTable{}, errors.New("short table header")
+	}
+	t := partitionTable{
+		Version:    binary.BigEndian.Uint32(b[4:]),
+		Generation: binary.BigEndian.Uint32(b[8:]),
+		Geometry: Geometry{
+			Start:       uint(binary.BigEndian.Uint64(b[12:])),
+			Length:      uint(binary.BigEndian.Uint64(b[20:])),
+			TableBlocks: uint(binary.BigEndian.Uint32(b[28:])),
+		},
+	}
+	hs := uint64(tableHeaderSizeV1)
+	switch {
+	case t.Version > TableFormatVersion:
+		// We can't parse the rest, but the caller needs to know it's there.
+		return t, nil
+	case t.Version >= 2:
+		if len(b) < tableHeaderSize {
+			return partitionTable{}, errors.New("short table header")
+		}
+		t.Geometry.IndexBlocks = uint(binary.BigEndian.Uint32(b[32:]))
+		hs = tableHeaderSize
+	}
+	numRuns := uint64(binary.BigEndian.Uint32(b[hs-4:]))
+	l := hs + numRuns*tableRunSize
+	if uint64(len(b)) < l+sha256.Size {
+		return partitionTable{}, fmt.Errorf("table with %d runs truncated at %d bytes", numRuns, len(b))
+	}
+	if h := sha256.Sum256(b[:l]); !bytes.Equal(h[:], b[l:l+sha256.Size]) {
+		return partitionTable{}, errors.New("table checksum mismatch")
+	}
+	total := uint64(0)
+	for r := b[hs:l]; len(r) > 0; r = r[tableRunSize:] {
+		count, length := binary.BigEndian.Uint32(r), binary.BigEndian.Uint32(r[4:])
+		if total += uint64(count) * uint64(length); total > uint64(t.Geometry.Length) {
+			return partitionTable{}, fmt.Errorf("table slots exceed partition length of %d blocks", t.Geometry.Length)
+		}
+		for range count {
+			t.Geometry.SlotLengths = append(t.Geometry.SlotLengths, uint(length))
+		}
+	}
+	return t, nil
+}
+
+// errNoTable is returned when no partition table is present.
+var errNoTable = errors.New("no partition table")
+
+// tableCopy returns the location and size in blocks of the given copy of the
+// partition table.
+func (g Geometry) tableCopy(i uint) (uint, uint) {
+	l := g.TableBlocks / 2
+	return g.Start + i*l, l
+}
+
+// readTable reads both copies of the partition table described by geo, and
+// returns the newest valid one along with the index of the copy it came from.
+//
+// Returns errNoTable if neither copy is present.
+func readTable(dev BlockReaderWriter, geo Geometry) (partitionTable, uint, error) {
+	var (
+		best    partitionTable
+		bestIdx uint
+		found   bool
+		errs    []error
+	)
+	for i := range uint(2) {
+		lba, n := geo.tableCopy(i)
+		b := make([]byte, n*dev.BlockSize())
+		if err := dev.ReadBlocks(lba, b); err != nil {
+			errs = append(errs, fmt.Errorf("failed to read table copy %d at block %d: %v", i, lba, err))
+			continue
+		}
+		t, err := unmarshalTable(b)
+		if err == errNoTable {
+			continue
+		} else if err != nil {
+			klog.Warningf("Ignoring invalid partition table copy %d at block %d: %v", i, lba, err)
+			errs = append(errs, fmt.Errorf("table copy %d: %v", i, err))
+			continue
+		}
+		if t.Version > TableFormatVersion {
+			// Never fall back to an older copy, as we'd risk undoing changes
+			// made by newer code.
+			return partitionTable{}, 0, fmt.Errorf("partition table copy %d has version %d, newer than supported version %d", i, t.Version, TableFormatVersion)
+		}
+		if !found || t.Generation > best.Generation {
+			best, bestIdx, found = t, i, true
+		}
+	}
+	if found {
+		return best, bestIdx, nil
+	}
+	if len(errs) > 0 {
+		// There's something there, but we can't use it, so we must not
+		// overwrite it with a new table.
+		return partitionTable{}, 0, errors.Join(errs...)
+	}
+	return partitionTable{}, 0, errNoTable
+}
+
+// writeTable writes the table to the given copy slot.
+func writeTable(dev BlockReaderWriter, geo Geometry, i uint, t partitionTable) error {
+	lba, n := geo.tableCopy(i)
+	bs := dev.BlockSize()
+	b := t.marshal()
+	if l := uint(len(b)); l > n*bs {
+		return fmt.Errorf("partition table is %d bytes, but only %d blocks of %d bytes are reserved for it", l, n, bs)
+	}
+	b = append(b, make([]byte, n*bs-uint(len(b)))...)
+	w, err := dev.WriteBlocks(lba, b)
+	if err != nil {
+		return fmt.Errorf("failed to write table copy %d at block %d: %v", i, lba, err)
+	}
+	if w != n {
+		return fmt.Errorf("short write of table copy %d at block %d: wrote %d of %d blocks", i, lba, w, n)
+	}
+	return nil
+}
+
+// checkTable ensures that the partition table stored on dev is compatible
+// with the requested geometry, creating or updating the table as necessary.
+//
+// A compatible geometry has the same start, table size, and index size, is no
+// shorter than the recorded one, and only appends slots to those already
+// recorded.
+func checkTable(dev BlockReaderWriter, geo Geometry) error {
+	t, idx, err := readTable(dev, geo)
+	switch {
+	case err == errNoTable:
+		klog.Infof("No partition table found, creating one for %d slots", len(geo.SlotLengths))
+		// Write both copies so that there's always one to fall back to.
+		nt := partitionTable{Version: TableFormatVersion, Geometry: geo}
+		for i := range uint(2) {
+			if err := writeTable(dev, geo, i, nt); err != nil {
+				return err
+			}
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to read partition table: %v", err)
+	}
+
+	old := t.Geometry
+	switch {
+	case old.Start != geo.Start || old.TableBlocks != geo.TableBlocks:
+		return fmt.Errorf("%w: requested partition at block %d with %d table blocks, but table records block %d with %d table blocks", ErrGeometryMismatch, geo.Start, geo.TableBlocks, old.Start, old.TableBlocks)
+	case old.IndexBlocks != geo.IndexBlocks:
+		// Slots start after the index, so changing its size would move them.
+		return fmt.Errorf("%w: requested %d index blocks, but table records %d", ErrGeometryMismatch, geo.IndexBlocks, old.IndexBlocks)
+	case geo.Length < old.Length:
+		return fmt.Errorf("%w: partition length %d blocks is shorter than recorded %d blocks", ErrGeometryMismatch, geo.Length, old.Length)
+	case len(geo.SlotLengths) < len(old.SlotLengths) || !slices.Equal(old.SlotLengths, geo.SlotLengths[:len(old.SlotLengths)]):
+		return fmt.Errorf("%w: requested slot layout does not extend the recorded %d slots", ErrGeometryMismatch, len(old.SlotLengths))
+	}
+	if t.Version == TableFormatVersion && geo.Length == old.Length && len(geo.SlotLengths) == len(old.SlotLengths) {
+		return nil
+	}
+
+	klog.Infof("Updating partition table from version %d with %d blocks and %d slots to version %d with %d blocks and %d slots", t.Version, old.Length, len(old.SlotLengths), TableFormatVersion, geo.Length, len(geo.SlotLengths))
+	nt := partitionTable{Version: TableFormatVersion, Generation: t.Generation + 1, Geometry: geo}
+	return writeTable(dev, geo, 1-idx, nt)
+}
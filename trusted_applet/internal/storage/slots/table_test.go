@@ -0,0 +1,228 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/testonly"
+)
+
+func TestTableRoundTrip(t *testing.T) {
+	want := partitionTable{
+		Version:    TableFormatVersion,
+		Generation: 3,
+		Geometry: Geometry{
+			Start:       10,
+			Length:      100,
+			TableBlocks: 2,
+			IndexBlocks: 7,
+			SlotLengths: []uint{1, 1, 1, 4, 4, 2, 1},
+		},
+	}
+	got, err := unmarshalTable(append(want.marshal(), make([]byte, 20)...))
+	if err != nil {
+		t.Fatalf("unmarshalTable: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Got diff: %s", diff)
+	}
+}
+
+func TestUnmarshalTableV1(t *testing.T) {
+	// A version 1 table has no index blocks field.
+	b := append([]byte{}, tableMagic[:]...)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint32(b, 5)
+	b = binary.BigEndian.AppendUint64(b, 10)
+	b = binary.BigEndian.AppendUint64(b, 100)
+	b = binary.BigEndian.AppendUint32(b, 2)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint32(b, 3)
+	b = binary.BigEndian.AppendUint32(b, 8)
+	h := sha256.Sum256(b)
+	b = append(b, h[:]...)
+
+	want := partitionTable{
+		Version:    1,
+		Generation: 5,
+		Geometry: Geometry{
+			Start:       10,
+			Length:      100,
+			TableBlocks: 2,
+			SlotLengths: []uint{8, 8, 8},
+		},
+	}
+	got, err := unmarshalTable(b)
+	if err != nil {
+		t.Fatalf("unmarshalTable: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Got diff: %s", diff)
+	}
+}
+
+func TestOpenPartitionTable(t *testing.T) {
+	base := Geometry{
+		Start:       2,
+		Length:      20,
+		TableBlocks: 2,
+		SlotLengths: []uint{2, 2, 4},
+	}
+	with := func(f func(g *Geometry)) Geometry {
+		g := base
+		g.SlotLengths = append([]uint{}, base.SlotLengths...)
+		f(&g)
+		return g
+	}
+
+	for _, test := range []struct {
+		name    string
+		geo     Geometry
+		wantErr error
+	}{
+		{
+			name: "same",
+			geo:  base,
+		}, {
+			name: "grown length",
+			geo:  with(func(g *Geometry) { g.Length = 24 }),
+		}, {
+			name: "appended slot",
+			geo:  with(func(g *Geometry) { g.SlotLengths = append(g.SlotLengths, 8) }),
+		}, {
+			name:    "shrunk length",
+			geo:     with(func(g *Geometry) { g.Length = 18 }),
+			wantErr: ErrGeometryMismatch,
+		}, {
+			name:    "resized slot",
+			geo:     with(func(g *Geometry) { g.SlotLengths[1] = 3 }),
+			wantErr: ErrGeometryMismatch,
+		}, {
+			name:    "removed slot",
+			geo:     with(func(g *Geometry) { g.SlotLengths = g.SlotLengths[:2] }),
+			wantErr: ErrGeometryMismatch,
+		}, {
+			name:    "different table size",
+			geo:     with(func(g *Geometry) { g.TableBlocks = 4 }),
+			wantErr: ErrGeometryMismatch,
+		}, {
+			name:    "added index",
+			geo:     with(func(g *Geometry) { g.IndexBlocks = 3 }),
+			wantErr: ErrGeometryMismatch,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			md := testonly.NewMemDev(t, 32)
+			if _, err := OpenPartition(md, base); err != nil {
+				t.Fatalf("OpenPartition(base): %v", err)
+			}
+			p, err := OpenPartition(md, test.geo)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("OpenPartition: %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got, want := p.slots[0].start, test.geo.Start+test.geo.TableBlocks; got != want {
+				t.Errorf("Slot 0 starts at %d, want %d", got, want)
+			}
+			// The table should now record the requested geometry.
+			tbl, _, err := readTable(md, test.geo)
+			if err != nil {
+				t.Fatalf("readTable: %v", err)
+			}
+			if diff := cmp.Diff(test.geo, tbl.Geometry); diff != "" {
+				t.Errorf("Got table diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestPartitionTableSurvivesTornUpdate(t *testing.T) {
+	md := testonly.NewMemDev(t, 32)
+	geo := Geometry{Start: 2, Length: 20, TableBlocks: 2, SlotLengths: []uint{2, 2}}
+	if _, err := OpenPartition(md, geo); err != nil {
+		t.Fatalf("OpenPartition: %v", err)
+	}
+
+	// Grow the partition, but corrupt the copy of the table being updated.
+	grown := geo
+	grown.SlotLengths = []uint{2, 2, 4}
+	md.OnBlockWritten = func(lba uint) {
+		md.Storage[lba][40] ^= 0xff
+	}
+	if _, err := OpenPartition(md, grown); err != nil {
+		t.Fatalf("OpenPartition(grown): %v", err)
+	}
+	md.OnBlockWritten = nil
+
+	// We should fall back to the intact copy, and be able to grow again.
+	tbl, _, err := readTable(md, geo)
+	if err != nil {
+		t.Fatalf("readTable: %v", err)
+	}
+	if diff := cmp.Diff(geo, tbl.Geometry); diff != "" {
+		t.Errorf("Got table diff: %s", diff)
+	}
+	if _, err := OpenPartition(md, grown); err != nil {
+		t.Fatalf("OpenPartition(grown) after torn update: %v", err)
+	}
+	tbl, _, err = readTable(md, geo)
+	if err != nil {
+		t.Fatalf("readTable: %v", err)
+	}
+	if diff := cmp.Diff(grown, tbl.Geometry); diff != "" {
+		t.Errorf("Got table diff: %s", diff)
+	}
+}
+
+func TestPartitionTableRefusesUnreadable(t *testing.T) {
+	geo := Geometry{Start: 2, Length: 20, TableBlocks: 2, SlotLengths: []uint{2, 2}}
+	for _, test := range []struct {
+		name  string
+		table func() []byte
+	}{
+		{
+			name: "corrupt",
+			table: func() []byte {
+				b := partitionTable{Version: TableFormatVersion, Geometry: geo}.marshal()
+				b[len(b)-1] ^= 0xff
+				return b
+			},
+		}, {
+			name: "future version",
+			table: func() []byte {
+				return partitionTable{Version: TableFormatVersion + 1, Geometry: geo}.marshal()
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			md := testonly.NewMemDev(t, 32)
+			copy(md.Storage[geo.Start][:], test.table())
+			if _, err := OpenPartition(md, geo); err == nil {
+				t.Fatal("OpenPartition succeeded, want error")
+			}
+			// The unreadable table must not have been overwritten.
+			if _, err := unmarshalTable(md.Storage[geo.Start+1][:]); err != errNoTable {
+				t.Errorf("Second table copy was written: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"k8s.io/klog/v2"
+)
+
+// intentMagic identifies an intent record, which lists the writes to be made
+// by a transaction.
+var intentMagic = []byte("TFX0")
+
+// TxnWrite is a write to a slot made as part of a transaction.
+type TxnWrite struct {
+	// Slot is the index of the slot to write to.
+	Slot uint
+	// Token is the token returned by the Read of the slot on which this write
+	// is based, as would be passed to CheckAndWrite.
+	Token uint32
+	// Data is the data to store in the slot.
+	Data []byte
+}
+
+// intentWrite is a write recorded in an intent record.
+type intentWrite struct {
+	slot uint
+	// base is the revision of the slot's journal which the write follows,
+	// which tells Recover whether the write has been made.
+	base uint32
+	data []byte
+}
+
+// Commit writes to several slots such that, even if interrupted by a crash,
+// either all of the writes are made or none of them are.
+//
+// The writes are first stored in an intent record in the given intent slot,
+// which must not be written to by anything else. If the writes are then
+// interrupted, they're completed by the next call to Recover for the intent
+// slot, which must be made before the slots are used after the partition is
+// reopened. The intent record holds all of the data written, so must fit
+// within the intent slot.
+//
+// Like CheckAndWrite, ErrConflict is returned, and nothing is written, if any
+// of the slots has been written to since its token was read. Otherwise, the
+// new token for each slot is returned in the same order as writes.
+// The writes are durable once Commit returns, even if write-back is enabled.
+// If Commit fails after storing the intent record, some of the writes may
+// have been made, and the rest will be made by Recover.
+func (p *Partition) Commit(ctx context.Context, intent uint, writes []TxnWrite) ([]uint32, error) {
+	idx := []uint{intent}
+	for _, w := range writes {
+		idx = append(idx, w.Slot)
+	}
+	ss, err := p.lockSlots(ctx, idx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockSlots(ss)
+
+	iw := make([]intentWrite, len(writes))
+	for i, w := range writes {
+		s := ss[w.Slot]
+		if s.revision != w.Token {
+			return nil, fmt.Errorf("slot %d: %w", w.Slot, ErrConflict)
+		}
+		// The base revision must be durable for Recover to compare against.
+		if err := s.flush(ctx); err != nil {
+			return nil, err
+		}
+		iw[i] = intentWrite{slot: w.Slot, base: s.journal.current.Revision, data: w.Data}
+	}
+
+	is := ss[intent]
+	if err := is.flush(ctx); err != nil {
+		return nil, err
+	}
+	if err := is.writeThrough(ctx, marshalIntent(iw)); err != nil {
+		return nil, fmt.Errorf("failed to store intent record in slot %d: %w", intent, err)
+	}
+	tokens := make([]uint32, len(writes))
+	for i, w := range iw {
+		s := ss[w.slot]
+		if err := s.writeThrough(ctx, w.data); err != nil {
+			return nil, fmt.Errorf("failed to write slot %d: %w", w.slot, err)
+		}
+		tokens[i] = s.revision
+	}
+	if err := is.writeThrough(ctx, nil); err != nil {
+		// All of the writes have been made, so Recover will find nothing
+		// left to do.
+		klog.Warningf("Failed to clear intent record in slot %d: %v", intent, err)
+	}
+	return tokens, nil
+}
+
+// Recover completes any transaction which was interrupted after storing its
+// intent record in the given slot, and returns true if it found one.
+func (p *Partition) Recover(ctx context.Context, intent uint) (bool, error) {
+	ss, err := p.lockSlots(ctx, []uint{intent})
+	if err != nil {
+		return false, err
+	}
+	is := ss[intent]
+	b := is.journal.current.Data
+	unlockSlots(ss)
+	if len(b) == 0 {
+		return false, nil
+	}
+	iw, err := unmarshalIntent(b)
+	if err != nil {
+		return false, &CorruptError{Slot: int(intent), Err: err}
+	}
+
+	idx := []uint{intent}
+	for _, w := range iw {
+		idx = append(idx, w.slot)
+	}
+	if ss, err = p.lockSlots(ctx, idx); err != nil {
+		return false, err
+	}
+	defer unlockSlots(ss)
+	for _, w := range iw {
+		s := ss[w.slot]
+		if err := s.flush(ctx); err != nil {
+			return false, err
+		}
+		if rev := s.journal.current.Revision; rev != w.base {
+			// The write was made before the transaction was interrupted.
+			klog.V(1).Infof("Slot %d is at revision %d, so transaction write following revision %d has been made", w.slot, rev, w.base)
+			continue
+		}
+		klog.Infof("Completing interrupted transaction write to slot %d", w.slot)
+		if err := s.writeThrough(ctx, w.data); err != nil {
+			return false, fmt.Errorf("failed to write slot %d: %w", w.slot, err)
+		}
+	}
+	if err := ss[intent].writeThrough(ctx, nil); err != nil {
+		return false, fmt.Errorf("failed to clear intent record in slot %d: %w", intent, err)
+	}
+	return true, nil
+}
+
+// lockSlots write-locks and opens the slots with the given indices, which
+// must be distinct, and returns them keyed by index.
+// Slots are always locked in order of their index, so that concurrent
+// transactions can't deadlock.
+func (p *Partition) lockSlots(ctx context.Context, idx []uint) (map[uint]*Slot, error) {
+	sorted := slices.Clone(idx)
+	slices.Sort(sorted)
+	for i, n := range sorted {
+		if l := uint(len(p.slots)); n >= l {
+			return nil, fmt.Errorf("invalid slot %d (partition has %d slots)", n, l)
+		}
+		if i > 0 && n == sorted[i-1] {
+			return nil, fmt.Errorf("slot %d is used more than once in transaction", n)
+		}
+	}
+	ss := make(map[uint]*Slot, len(sorted))
+	for _, n := range sorted {
+		s := &p.slots[n]
+		s.mu.Lock()
+		ss[n] = s
+		var err error
+		if s.lazyDev != nil {
+			err = s.openDeferred(ctx)
+		} else {
+			err = s.open(ctx, p.dev, p.journalOpts(n))
+		}
+		if err == nil && s.journal == nil {
+			err = s.notOpen()
+		}
+		if err != nil {
+			unlockSlots(ss)
+			return nil, fmt.Errorf("failed to open slot %d: %w", n, err)
+		}
+	}
+	return ss, nil
+}
+
+// unlockSlots unlocks slots locked by lockSlots.
+func unlockSlots(ss map[uint]*Slot) {
+	for _, s := range ss {
+		s.mu.Unlock()
+	}
+}
+
+// marshalIntent returns the intent record for the given writes.
+// Data written to more than one slot is only stored once.
+func marshalIntent(iw []intentWrite) []byte {
+	var data [][]byte
+	ref := make([]uint32, len(iw))
+	for i, w := range iw {
+		j := slices.IndexFunc(data, func(d []byte) bool { return bytes.Equal(d, w.data) })
+		if j < 0 {
+			j = len(data)
+			data = append(data, w.data)
+		}
+		ref[i] = uint32(j)
+	}
+
+	b := bytes.Clone(intentMagic)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	for _, d := range data {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(d)))
+		b = append(b, d...)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(len(iw)))
+	for i, w := range iw {
+		b = binary.BigEndian.AppendUint32(b, uint32(w.slot))
+		b = binary.BigEndian.AppendUint32(b, w.base)
+		b = binary.BigEndian.AppendUint32(b, ref[i])
+	}
+	return b
+}
+
+// unmarshalIntent parses an intent record created by marshalIntent.
+func unmarshalIntent(b []byte) ([]intentWrite, error) {
+	b, ok := bytes.CutPrefix(b, intentMagic)
+	if !ok {
+		return nil, errors.New("invalid intent record magic")
+	}
+	next := func() (uint32, bool) {
+		if len(b) < 4 {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		return v, true
+	}
+	errShort := errors.New("intent record truncated")
+
+	n, ok := next()
+	if !ok {
+		return nil, errShort
+	}
+	var data [][]byte
+	for range n {
+		l, ok := next()
+		if !ok || uint32(len(b)) < l {
+			return nil, errShort
+		}
+		data, b = append(data, b[:l]), b[l:]
+	}
+	if n, ok = next(); !ok {
+		return nil, errShort
+	}
+	var iw []intentWrite
+	for range n {
+		slot, ok1 := next()
+		base, ok2 := next()
+		ref, ok3 := next()
+		if !ok1 || !ok2 || !ok3 {
+			return nil, errShort
+		}
+		if ref >= uint32(len(data)) {
+			return nil, fmt.Errorf("intent record refers to data %d of %d", ref, len(data))
+		}
+		iw = append(iw, intentWrite{slot: uint(slot), base: base, data: data[ref]})
+	}
+	if len(b) > 0 {
+		return nil, fmt.Errorf("unexpected %d trailing bytes in intent record", len(b))
+	}
+	return iw, nil
+}
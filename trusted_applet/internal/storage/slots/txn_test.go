@@ -0,0 +1,125 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// intentSlot is the slot of memPartition used for intent records, since it's
+// the only one large enough to hold them.
+const intentSlot = 3
+
+func mustSlotData(t *testing.T, p *Partition, i uint, want string) {
+	t.Helper()
+	if got, _ := openAndRead(t, p, i); string(got) != want {
+		t.Errorf("Slot %d holds %q, want %q", i, got, want)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	ctx := context.Background()
+	for _, writeBack := range []bool{false, true} {
+		p, _ := memPartition(t)
+		if writeBack {
+			p.EnableWriteBack(ctx, time.Hour)
+		}
+		s1, _ := p.Open(1)
+		if err := s1.Write([]byte("one")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		_, t1 := openAndRead(t, p, 1)
+		_, t2 := openAndRead(t, p, 2)
+
+		tokens, err := p.Commit(ctx, intentSlot, []TxnWrite{{Slot: 1, Token: t1, Data: []byte("two")}, {Slot: 2, Token: t2, Data: []byte("two")}})
+		if err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		if tokens[0] != t1+1 || tokens[1] != t2+1 {
+			t.Errorf("Commit returned tokens %v, want [%d %d]", tokens, t1+1, t2+1)
+		}
+		// The writes are durable without flushing.
+		p = mustReopen(t, p)
+		mustSlotData(t, p, 1, "two")
+		mustSlotData(t, p, 2, "two")
+		mustSlotData(t, p, intentSlot, "")
+
+		// A stale token prevents any of the writes from being made.
+		_, t2 = openAndRead(t, p, 2)
+		if _, err := p.Commit(ctx, intentSlot, []TxnWrite{{Slot: 2, Token: t2, Data: []byte("three")}, {Slot: 1, Token: t1, Data: []byte("three")}}); !errors.Is(err, ErrConflict) {
+			t.Errorf("Commit with stale token: %v, want ErrConflict", err)
+		}
+		mustSlotData(t, p, 1, "two")
+		mustSlotData(t, p, 2, "two")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	p, _ := memPartition(t)
+	if found, err := p.Recover(ctx, intentSlot); err != nil || found {
+		t.Fatalf("Recover with no intent record = %t, %v, want false, nil", found, err)
+	}
+
+	// Store an intent record, and make the first of its writes, as if the
+	// transaction was interrupted.
+	s0, _ := p.Open(0)
+	s1, _ := p.Open(1)
+	if err := s1.Write([]byte("one")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	s3, _ := p.Open(intentSlot)
+	iw := []intentWrite{{slot: 0, base: 0, data: []byte("new")}, {slot: 1, base: 1, data: []byte("new")}}
+	if err := s3.Write(marshalIntent(iw)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := s0.Write([]byte("new")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	p = mustReopen(t, p)
+	if found, err := p.Recover(ctx, intentSlot); err != nil || !found {
+		t.Fatalf("Recover = %t, %v, want true, nil", found, err)
+	}
+	mustSlotData(t, p, 0, "new")
+	mustSlotData(t, p, 1, "new")
+	mustSlotData(t, p, intentSlot, "")
+	// The write which had already been made isn't repeated.
+	if _, tok := openAndRead(t, p, 0); tok != 1 {
+		t.Errorf("Slot 0 at revision %d, want 1", tok)
+	}
+}
+
+func TestIntentRoundtrip(t *testing.T) {
+	iw := []intentWrite{{slot: 4, base: 7, data: []byte("a")}, {slot: 2, base: 1, data: []byte("b")}, {slot: 9, base: 0, data: []byte("a")}}
+	b := marshalIntent(iw)
+	got, err := unmarshalIntent(b)
+	if err != nil {
+		t.Fatalf("unmarshalIntent: %v", err)
+	}
+	for i := range iw {
+		if got[i].slot != iw[i].slot || got[i].base != iw[i].base || string(got[i].data) != string(iw[i].data) {
+			t.Errorf("Write %d = %+v, want %+v", i, got[i], iw[i])
+		}
+	}
+	for i := range b {
+		if _, err := unmarshalIntent(b[:i]); err == nil {
+			t.Errorf("unmarshalIntent succeeded with record truncated to %d bytes", i)
+		}
+	}
+}
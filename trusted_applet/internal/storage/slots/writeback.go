@@ -0,0 +1,193 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// WriteBackStats holds cumulative counts of the activity of a partition's
+// write-back layer.
+type WriteBackStats struct {
+	// Writes is the number of writes made to slots.
+	Writes uint64
+	// Coalesced is the number of writes which were superseded by a later write
+	// to the same slot before being flushed, and so never hit the storage.
+	Coalesced uint64
+	// Flushes is the number of journal writes made to flush pending data.
+	Flushes uint64
+	// FlushErrors is the number of journal writes which failed while flushing.
+	FlushErrors uint64
+}
+
+// writeBack tracks the slots in a partition which have pending writes.
+type writeBack struct {
+	mu sync.Mutex
+	// dirty is the set of slots which hold data not yet flushed to their
+	// journals.
+	dirty map[*Slot]bool
+	stats WriteBackStats
+}
+
+// markDirty records a write to s, noting whether it supersedes an earlier
+// write which hasn't been flushed.
+func (wb *writeBack) markDirty(s *Slot, coalesced bool) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.stats.Writes++
+	if coalesced {
+		wb.stats.Coalesced++
+	}
+	wb.dirty[s] = true
+}
+
+// markFlushed records the result of an attempt to flush s.
+func (wb *writeBack) markFlushed(s *Slot, err error) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if err != nil {
+		wb.stats.FlushErrors++
+		return
+	}
+	wb.stats.Flushes++
+	delete(wb.dirty, s)
+}
+
+// EnableWriteBack causes writes to the partition's slots to be held in memory
+// and flushed to storage in the background at least every interval, so that
+// repeated writes to the same slot are coalesced into a single journal update.
+//
+// Data written to a slot is immediately visible via Read, and CheckAndWrite
+// tokens behave exactly as before, but writes are not durable until they're
+// flushed: callers which need a durability guarantee must use Slot.Sync or
+// Flush.
+//
+// Pending writes are flushed one final time when ctx is done.
+// This must be called before the partition's slots are used.
+func (p *Partition) EnableWriteBack(ctx context.Context, interval time.Duration) {
+	if p.wb != nil {
+		return
+	}
+	p.wb = &writeBack{dirty: make(map[*Slot]bool)}
+	for i := range p.slots {
+		s := &p.slots[i]
+		s.mu.Lock()
+		s.wb = p.wb
+		s.mu.Unlock()
+	}
+	klog.Infof("Enabled write-back with flush interval %v", interval)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				if err := p.Flush(); err != nil {
+					klog.Errorf("Final write-back flush: %v", err)
+				}
+				return
+			}
+			if err := p.Flush(); err != nil {
+				klog.Warningf("Write-back flush: %v", err)
+			}
+		}
+	}()
+}
+
+// Flush writes all pending data to storage, acting as a durability barrier
+// for all writes to the partition which completed before it was called.
+// It's a no-op unless write-back is enabled.
+func (p *Partition) Flush() error {
+	if p.wb == nil {
+		return nil
+	}
+	p.wb.mu.Lock()
+	dirty := make([]*Slot, 0, len(p.wb.dirty))
+	for s := range p.wb.dirty {
+		dirty = append(dirty, s)
+	}
+	p.wb.mu.Unlock()
+
+	var errs []error
+	for _, s := range dirty {
+		if err := s.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to flush %d of %d slots: %w", len(errs), len(dirty), errors.Join(errs...))
+	}
+	return nil
+}
+
+// WriteBackStats returns the cumulative write-back statistics for the
+// partition, which will be zero if write-back is not enabled.
+func (p *Partition) WriteBackStats() WriteBackStats {
+	if p.wb == nil {
+		return WriteBackStats{}
+	}
+	p.wb.mu.Lock()
+	defer p.wb.mu.Unlock()
+	return p.wb.stats
+}
+
+// Sync ensures that any data written to the slot has been durably stored.
+// It's a no-op unless write-back is enabled on the slot's partition.
+func (s *Slot) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush(context.Background())
+}
+
+// flush writes any pending data to the slot's journal.
+// Must be called with s.mu write-locked.
+func (s *Slot) flush(ctx context.Context) error {
+	if !s.dirty {
+		return nil
+	}
+	if s.journal == nil {
+		return s.notOpen()
+	}
+	err := s.journal.UpdateContext(ctx, s.pending)
+	s.wb.markFlushed(s, err)
+	if err != nil {
+		return fmt.Errorf("failed to flush slot at block %d: %w", s.start, err)
+	}
+	s.dirty = false
+	s.pending = nil
+	return nil
+}
+
+// discardPending drops any data which has been written to the slot but not
+// flushed.
+// Must be called with s.mu write-locked.
+func (s *Slot) discardPending() {
+	if !s.dirty {
+		return
+	}
+	s.dirty = false
+	s.pending = nil
+	s.wb.mu.Lock()
+	delete(s.wb.dirty, s)
+	s.wb.mu.Unlock()
+}
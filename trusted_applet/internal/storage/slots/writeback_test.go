@@ -0,0 +1,179 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteBackCoalesces(t *testing.T) {
+	p, md := memPartition(t)
+	p.EnableWriteBack(context.Background(), time.Hour)
+	writes := 0
+	md.OnBlockWritten = func(uint) { writes++ }
+
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := range 10 {
+		if err := s.Write([]byte(fmt.Sprintf("data %d", i))); err != nil {
+			t.Fatalf("Write(%d): %v", i, err)
+		}
+	}
+	if writes != 0 {
+		t.Fatalf("Got %d block writes before flush, want 0", writes)
+	}
+	if d, r, err := s.Read(); err != nil || string(d) != "data 9" || r != 10 {
+		t.Fatalf("Read() = %q, %d, %v, want data 9, 10", d, r, err)
+	}
+
+	// Nothing should be durable yet, so a fresh view of the storage sees nothing.
+	if d, _ := openAndRead(t, mustReopen(t, p), 3); len(d) != 0 {
+		t.Fatalf("Got unflushed data %q from storage", d)
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if writes != 1 {
+		t.Errorf("Got %d block writes after flush, want 1", writes)
+	}
+	if d, _ := openAndRead(t, mustReopen(t, p), 3); string(d) != "data 9" {
+		t.Errorf("Got %q from storage after flush, want data 9", d)
+	}
+	// The token must be unaffected by the flush.
+	if _, r, _ := s.Read(); r != 10 {
+		t.Errorf("Got revision %d after flush, want 10", r)
+	}
+
+	want := WriteBackStats{Writes: 10, Coalesced: 9, Flushes: 1}
+	if diff := cmp.Diff(want, p.WriteBackStats()); diff != "" {
+		t.Errorf("Got stats diff: %s", diff)
+	}
+
+	// Flushing again should be a no-op.
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if writes != 1 {
+		t.Errorf("Got %d block writes after second flush, want 1", writes)
+	}
+}
+
+func TestWriteBackCheckAndWrite(t *testing.T) {
+	p, _ := memPartition(t)
+	p.EnableWriteBack(context.Background(), time.Hour)
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	_, stale, _ := s.Read()
+	if _, err := s.CheckAndWrite(stale, []byte("one")); err != nil {
+		t.Fatalf("CheckAndWrite: %v", err)
+	}
+	_, tok, _ := s.Read()
+	if _, err := s.CheckAndWrite(stale, []byte("two")); err == nil {
+		t.Fatal("CheckAndWrite with stale token succeeded before flush")
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if _, err := s.CheckAndWrite(stale, []byte("two")); err == nil {
+		t.Fatal("CheckAndWrite with stale token succeeded after flush")
+	}
+	if _, err := s.CheckAndWrite(tok, []byte("two")); err != nil {
+		t.Fatalf("CheckAndWrite with current token: %v", err)
+	}
+	if d, _, _ := s.Read(); string(d) != "two" {
+		t.Errorf("Got %q, want two", d)
+	}
+}
+
+func TestWriteBackRejectsOversizedWrite(t *testing.T) {
+	p, _ := memPartition(t)
+	p.EnableWriteBack(context.Background(), time.Hour)
+	s, err := p.Open(0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write(make([]byte, 1024)); err == nil {
+		t.Fatal("Oversized write succeeded")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+}
+
+func TestWriteBackErase(t *testing.T) {
+	p, _ := memPartition(t)
+	p.EnableWriteBack(context.Background(), time.Hour)
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write([]byte("doomed")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := p.Erase(); err != nil {
+		t.Fatalf("Erase: %v", err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if d, r := openAndRead(t, p, 3); len(d) != 0 || r != 0 {
+		t.Errorf("Got %q@%d after erase, want nothing", d, r)
+	}
+}
+
+func TestWriteBackFlushesInBackground(t *testing.T) {
+	p, md := memPartition(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.EnableWriteBack(ctx, time.Hour)
+	flushed := make(chan struct{})
+	md.OnBlockWritten = func(uint) { close(flushed) }
+
+	s, err := p.Open(3)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := s.Write([]byte("data")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// Cancelling the context should cause a final flush.
+	cancel()
+	select {
+	case <-flushed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for flush")
+	}
+}
+
+// mustReopen returns a new partition backed by the same storage as p, as would
+// be seen following a reboot.
+func mustReopen(t *testing.T, p *Partition) *Partition {
+	t.Helper()
+	r := &Partition{dev: p.dev}
+	for i := range p.slots {
+		r.slots = append(r.slots, Slot{number: uint(i), start: p.slots[i].start, length: p.slots[i].length})
+	}
+	return r
+}
@@ -0,0 +1,330 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logfmt "github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// snapshotHeader is the first line of a state snapshot note.
+	snapshotHeader = "ArmoredWitness state snapshot v1"
+)
+
+// errSnapshotRollback is returned by importLog if the snapshot holds an older
+// checkpoint for the log than the one already stored.
+var errSnapshotRollback = errors.New("snapshot checkpoint is older than the stored one")
+
+// SnapshotSummary describes the outcome of importing a state snapshot.
+type SnapshotSummary struct {
+	// Source identifies the device the snapshot was exported from.
+	Source string
+	// Time is when the snapshot was exported.
+	Time time.Time
+	// Imported is the number of logs whose checkpoints were restored.
+	Imported int
+	// Skipped is the number of logs which were left alone because this
+	// witness already holds a checkpoint for them.
+	Skipped int
+	// Rejected is the number of logs which weren't imported because they're
+	// not witnessed by this device, their checkpoints failed to verify, or
+	// their checkpoints are older than the ones held by this witness.
+	Rejected int
+}
+
+// snapshotLog is the state of a single log held in a snapshot.
+type snapshotLog struct {
+	ID         string
+	Slot       uint
+	Retired    time.Time
+	Checkpoint []byte
+}
+
+// Export returns a note, signed by signer, holding the directory and the latest
+// checkpoint of every log known to the witness, which may be restored onto
+// another device using Import.
+//
+// The snapshot note text is formatted like so:
+//
+//	"ArmoredWitness state snapshot v1"
+//	<Source device identifier string>
+//	<Export time in decimal Unix seconds>
+//	<Number of logs in decimal>
+//	<Log ID> <Slot in decimal> <Retirement time in decimal Unix seconds, or 0> <Base64 checkpoint>
+//	...
+//
+// Logs which have no checkpoint are omitted.
+func (p *SlotPersistence) Export(ctx context.Context, source string, signer note.Signer) ([]byte, error) {
+	if strings.Contains(source, "\n") {
+		return nil, errors.New("source must not contain newlines")
+	}
+	p.mu.RLock()
+	if err := p.rollbackErr; err != nil {
+		p.mu.RUnlock()
+		return nil, err
+	}
+	logs := make([]snapshotLog, 0, len(p.idToSlot))
+	for id, i := range p.idToSlot {
+		logs = append(logs, snapshotLog{ID: id, Slot: i, Retired: p.retired[id]})
+	}
+	p.mu.RUnlock()
+	sort.Slice(logs, func(i, j int) bool { return logs[i].ID < logs[j].ID })
+
+	var out []snapshotLog
+	for _, l := range logs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s, err := p.part.OpenContext(ctx, l.Slot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open slot %d associated with log ID %q: %v", l.Slot, l.ID, err)
+		}
+		b, _, err := s.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read slot %d associated with log ID %q: %w", l.Slot, l.ID, readErr(ctx, err))
+		}
+		if l.Checkpoint, err = unmarshalCheckpoint(b); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal checkpoint for log ID %q: %v", l.ID, err)
+		}
+		if len(l.Checkpoint) == 0 {
+			continue
+		}
+		out = append(out, l)
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s\n%s\n%d\n%d\n", snapshotHeader, source, time.Now().Unix(), len(out))
+	for _, l := range out {
+		r := int64(0)
+		if !l.Retired.IsZero() {
+			r = l.Retired.Unix()
+		}
+		fmt.Fprintf(sb, "%s %d %d %s\n", l.ID, l.Slot, r, base64.StdEncoding.EncodeToString(l.Checkpoint))
+	}
+	return note.Sign(&note.Note{Text: sb.String()}, signer)
+}
+
+// Import restores the checkpoints held in a snapshot created by Export.
+//
+// The snapshot must carry valid signatures from all of the provided verifiers.
+// logVerifiers maps the origin of each log witnessed by this device to its
+// verifier, and each imported checkpoint must be correctly signed by its log;
+// checkpoints for any other logs are rejected.
+// Only logs for which this witness holds no checkpoint are restored, since
+// this witness' own view of any other logs must take precedence. Checkpoints
+// smaller than those already held are rejected rather than skipped, since they
+// suggest an attempted rollback.
+// Logs are assigned slots afresh, rather than using the slots recorded in
+// the snapshot.
+func (p *SlotPersistence) Import(ctx context.Context, snapshot []byte, logVerifiers map[string]note.Verifier, verifiers ...note.Verifier) (SnapshotSummary, error) {
+	if len(verifiers) == 0 {
+		return SnapshotSummary{}, errors.New("at least one verifier is required")
+	}
+	n, err := note.Open(snapshot, note.VerifierList(verifiers...))
+	if err != nil {
+		return SnapshotSummary{}, fmt.Errorf("failed to verify snapshot: %v", err)
+	}
+	for _, v := range verifiers {
+		if !signedBy(n, v) {
+			return SnapshotSummary{}, fmt.Errorf("snapshot not signed by %q", v.Name())
+		}
+	}
+	sum, logs, err := parseSnapshot(n.Text)
+	if err != nil {
+		return SnapshotSummary{}, err
+	}
+	klog.Infof("Importing %d logs from snapshot of %q taken at %v", len(logs), sum.Source, sum.Time)
+
+	origins := make(map[string]string, len(logVerifiers))
+	for o := range logVerifiers {
+		origins[logfmt.ID(o)] = o
+	}
+	retired := false
+	for _, l := range logs {
+		if err := ctx.Err(); err != nil {
+			return sum, err
+		}
+		o, ok := origins[l.ID]
+		if !ok {
+			klog.Warningf("Not importing log ID %q: it isn't witnessed by this device", l.ID)
+			sum.Rejected++
+			continue
+		}
+		cp, _, _, err := logfmt.ParseCheckpoint(l.Checkpoint, o, logVerifiers[o])
+		if err != nil {
+			klog.Warningf("Not importing checkpoint for %q: %v", o, err)
+			sum.Rejected++
+			continue
+		}
+		ok, err = p.importLog(ctx, l, cp.Size)
+		if errors.Is(err, errSnapshotRollback) {
+			klog.Warningf("Not importing checkpoint for %q: %v", o, err)
+			sum.Rejected++
+			continue
+		} else if err != nil {
+			return sum, err
+		}
+		if !ok {
+			klog.Infof("Not importing log ID %q: it already has a checkpoint", l.ID)
+			sum.Skipped++
+			continue
+		}
+		sum.Imported++
+		if !l.Retired.IsZero() {
+			p.mu.Lock()
+			p.retired[l.ID] = l.Retired
+			p.mu.Unlock()
+			retired = true
+		}
+	}
+	if retired {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err := p.storeDirectory(); err != nil {
+			return sum, err
+		}
+	}
+	return sum, nil
+}
+
+// importLog stores the log's checkpoint, which has the given tree size, if
+// there isn't one already, and returns whether it did so.
+// errSnapshotRollback is returned if the stored checkpoint is larger.
+func (p *SlotPersistence) importLog(ctx context.Context, l snapshotLog, size uint64) (bool, error) {
+	i, err := p.logSlot(l.ID, true)
+	if err != nil {
+		return false, err
+	}
+	s, err := p.part.OpenContext(ctx, i)
+	if err != nil {
+		return false, fmt.Errorf("internal error opening slot %d associated with log ID %q: %v", i, l.ID, err)
+	}
+	b, t, err := s.Read()
+	if err != nil {
+		return false, fmt.Errorf("failed to read slot %d associated with log ID %q: %w", i, l.ID, readErr(ctx, err))
+	}
+	cp, err := unmarshalCheckpoint(b)
+	if err != nil {
+		return false, err
+	}
+	if len(cp) > 0 {
+		_, stored, err := checkpointHead(cp)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse stored checkpoint for log ID %q: %v", l.ID, err)
+		}
+		if size < stored {
+			return false, fmt.Errorf("%w: size %d is smaller than %d", errSnapshotRollback, size, stored)
+		}
+		return false, nil
+	}
+	if err := p.writeCheckpoint(ctx, l.ID, s, t, marshalCheckpoint(l.Checkpoint), l.Checkpoint); err != nil {
+		// Most likely the log has been updated concurrently, in which case we
+		// need to leave it alone anyway.
+		klog.Warningf("Failed to import checkpoint for log ID %q: %v", l.ID, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// signedBy returns true if n carries a verified signature from v.
+func signedBy(n *note.Note, v note.Verifier) bool {
+	for _, s := range n.Sigs {
+		if s.Name == v.Name() && s.Hash == v.KeyHash() {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSnapshot parses the text of a snapshot note.
+func parseSnapshot(text string) (SnapshotSummary, []snapshotLog, error) {
+	var sum SnapshotSummary
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(nil, len(text)+1)
+	line := func() (string, bool) {
+		if !sc.Scan() {
+			return "", false
+		}
+		return sc.Text(), true
+	}
+
+	if h, _ := line(); h != snapshotHeader {
+		return sum, nil, fmt.Errorf("unknown snapshot header %q", h)
+	}
+	var ok bool
+	if sum.Source, ok = line(); !ok {
+		return sum, nil, errors.New("snapshot missing source")
+	}
+	ts, _ := line()
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return sum, nil, fmt.Errorf("invalid snapshot time %q: %v", ts, err)
+	}
+	sum.Time = time.Unix(secs, 0)
+	ns, _ := line()
+	num, err := strconv.ParseUint(ns, 10, 32)
+	if err != nil {
+		return sum, nil, fmt.Errorf("invalid snapshot log count %q: %v", ns, err)
+	}
+
+	logs := make([]snapshotLog, 0, min(num, 1024))
+	for i := range num {
+		l, ok := line()
+		if !ok {
+			return sum, nil, fmt.Errorf("snapshot truncated after %d of %d logs", i, num)
+		}
+		f := strings.Split(l, " ")
+		if len(f) != 4 {
+			return sum, nil, fmt.Errorf("invalid snapshot log line %d", i)
+		}
+		if id, err := hex.DecodeString(f[0]); err != nil || len(id) != 32 {
+			return sum, nil, fmt.Errorf("invalid log ID %q on snapshot log line %d", f[0], i)
+		}
+		slot, err := strconv.ParseUint(f[1], 10, 32)
+		if err != nil {
+			return sum, nil, fmt.Errorf("invalid slot %q on snapshot log line %d: %v", f[1], i, err)
+		}
+		r, err := strconv.ParseInt(f[2], 10, 64)
+		if err != nil {
+			return sum, nil, fmt.Errorf("invalid retirement time %q on snapshot log line %d: %v", f[2], i, err)
+		}
+		cp, err := base64.StdEncoding.DecodeString(f[3])
+		if err != nil || len(cp) == 0 {
+			return sum, nil, fmt.Errorf("invalid checkpoint on snapshot log line %d: %v", i, err)
+		}
+		sl := snapshotLog{ID: f[0], Slot: uint(slot), Checkpoint: cp}
+		if r != 0 {
+			sl.Retired = time.Unix(r, 0)
+		}
+		logs = append(logs, sl)
+	}
+	if _, ok := line(); ok {
+		return sum, nil, errors.New("unexpected trailing data in snapshot")
+	}
+	return sum, logs, sc.Err()
+}
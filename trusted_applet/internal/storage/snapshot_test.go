@@ -0,0 +1,292 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	logfmt "github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+)
+
+func mustKey(t *testing.T, name string) (note.Signer, note.Verifier) {
+	t.Helper()
+	sk, vk, err := note.GenerateKey(rand.Reader, name)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	s, err := note.NewSigner(sk)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := note.NewVerifier(vk)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	return s, v
+}
+
+// mustCheckpoint returns a checkpoint of the given size, signed by the log.
+func mustCheckpoint(t *testing.T, log note.Signer, size uint64) string {
+	t.Helper()
+	cp := logfmt.Checkpoint{Origin: log.Name(), Size: size, Hash: make([]byte, 32)}
+	b, err := note.Sign(&note.Note{Text: string(cp.Marshal())}, log)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return string(b)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	signer, verifier := mustKey(t, "device")
+	log1, log1Verifier := mustKey(t, "log1")
+	log2, log2Verifier := mustKey(t, "log2")
+	logs := map[string]note.Verifier{"log1": log1Verifier, "log2": log2Verifier}
+
+	src := newTestPersistence(t)
+	// Checkpoints are notes, so make sure their blank lines and signatures
+	// survive being embedded in the snapshot note.
+	cps := map[string]string{
+		"log1": mustCheckpoint(t, log1, 11),
+		"log2": mustCheckpoint(t, log2, 20),
+	}
+	for o, cp := range cps {
+		mustStore(t, src, o, cp)
+	}
+	if err := src.Retire(ctx, "log2"); err != nil {
+		t.Fatalf("Retire: %v", err)
+	}
+	snap, err := src.Export(ctx, "device-serial", signer)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	dst := newTestPersistence(t)
+	// The destination already has its own view of log1, which must be kept.
+	own := mustCheckpoint(t, log1, 10)
+	mustStore(t, dst, "log1", own)
+	sum, err := dst.Import(ctx, snap, logs, verifier)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if sum.Source != "device-serial" || sum.Imported != 1 || sum.Skipped != 1 || sum.Rejected != 0 {
+		t.Errorf("Got summary %+v, want 1 imported and 1 skipped from device-serial", sum)
+	}
+	for o, want := range map[string]string{"log1": own, "log2": cps["log2"]} {
+		got, err := dst.Latest(ctx, o)
+		if err != nil {
+			t.Fatalf("Latest(%q): %v", o, err)
+		}
+		if string(got) != want {
+			t.Errorf("Latest(%q) = %q, want %q", o, got, want)
+		}
+	}
+	if _, ok := dst.retired[logfmt.ID("log2")]; !ok {
+		t.Error("Retirement of log2 was not imported")
+	}
+}
+
+func TestSnapshotImportVerifies(t *testing.T) {
+	ctx := context.Background()
+	signer, verifier := mustKey(t, "device")
+	operator, operatorVerifier := mustKey(t, "operator")
+	_, otherVerifier := mustKey(t, "device")
+	log, logVerifier := mustKey(t, "log")
+	logs := map[string]note.Verifier{"log": logVerifier}
+
+	src := newTestPersistence(t)
+	mustStore(t, src, "log", mustCheckpoint(t, log, 1))
+	snap, err := src.Export(ctx, "serial", signer)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	cosigned, err := note.Sign(mustOpen(t, snap, verifier), signer, operator)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		snap      []byte
+		verifiers []note.Verifier
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			snap:      snap,
+			verifiers: []note.Verifier{verifier},
+		}, {
+			name:      "all required signers",
+			snap:      cosigned,
+			verifiers: []note.Verifier{verifier, operatorVerifier},
+		}, {
+			name:      "missing required signer",
+			snap:      snap,
+			verifiers: []note.Verifier{verifier, operatorVerifier},
+			wantErr:   true,
+		}, {
+			name:      "wrong key",
+			snap:      snap,
+			verifiers: []note.Verifier{otherVerifier},
+			wantErr:   true,
+		}, {
+			name:      "tampered",
+			snap:      bytes.Replace(snap, []byte("serial"), []byte("lairse"), 1),
+			verifiers: []note.Verifier{verifier},
+			wantErr:   true,
+		}, {
+			name:    "no verifiers",
+			snap:    snap,
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dst := newTestPersistence(t)
+			_, err := dst.Import(ctx, test.snap, logs, test.verifiers...)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Import: %v, wantErr %t", err, test.wantErr)
+			}
+			_, err = dst.Latest(ctx, "log")
+			if gotStored := err == nil; gotStored == test.wantErr {
+				t.Errorf("Latest: %v, but wantErr %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSnapshotImportRejectsCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	signer, verifier := mustKey(t, "device")
+	log, logVerifier := mustKey(t, "log")
+	_, otherLogVerifier := mustKey(t, "log")
+	fake, _ := mustKey(t, "fake")
+
+	for _, test := range []struct {
+		name string
+		// stored is the checkpoint already held by the destination, if any.
+		stored string
+		// cp is the checkpoint held in the snapshot.
+		cp   string
+		logs map[string]note.Verifier
+	}{
+		{
+			name: "unknown log",
+			cp:   mustCheckpoint(t, log, 10),
+			logs: map[string]note.Verifier{"other": logVerifier},
+		}, {
+			name: "wrong log key",
+			cp:   mustCheckpoint(t, log, 10),
+			logs: map[string]note.Verifier{"log": otherLogVerifier},
+		}, {
+			name: "wrong origin",
+			cp:   strings.Replace(mustCheckpoint(t, fake, 10), "fake\n", "log\n", 1),
+			logs: map[string]note.Verifier{"log": logVerifier},
+		}, {
+			name:   "rollback",
+			stored: mustCheckpoint(t, log, 11),
+			cp:     mustCheckpoint(t, log, 10),
+			logs:   map[string]note.Verifier{"log": logVerifier},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := newTestPersistence(t)
+			mustStore(t, src, "log", test.cp)
+			snap, err := src.Export(ctx, "serial", signer)
+			if err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+
+			dst := newTestPersistence(t)
+			if test.stored != "" {
+				mustStore(t, dst, "log", test.stored)
+			}
+			sum, err := dst.Import(ctx, snap, test.logs, verifier)
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if sum.Imported != 0 || sum.Rejected != 1 {
+				t.Errorf("Got summary %+v, want 1 rejected", sum)
+			}
+			got, err := dst.Latest(ctx, "log")
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Latest: %v", err)
+			}
+			if string(got) != test.stored {
+				t.Errorf("Latest = %q, want %q", got, test.stored)
+			}
+		})
+	}
+}
+
+func TestParseSnapshot(t *testing.T) {
+	const id = "0000000000000000000000000000000000000000000000000000000000000000"
+	for _, test := range []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			text: snapshotHeader + "\nsrc\n1\n1\n" + id + " 1 0 Q1A=\n",
+		}, {
+			name: "empty",
+			text: snapshotHeader + "\nsrc\n1\n0\n",
+		}, {
+			name:    "bad header",
+			text:    "something else\nsrc\n1\n0\n",
+			wantErr: true,
+		}, {
+			name:    "too few logs",
+			text:    snapshotHeader + "\nsrc\n1\n2\n" + id + " 1 0 Q1A=\n",
+			wantErr: true,
+		}, {
+			name:    "too many logs",
+			text:    snapshotHeader + "\nsrc\n1\n0\n" + id + " 1 0 Q1A=\n",
+			wantErr: true,
+		}, {
+			name:    "bad log ID",
+			text:    snapshotHeader + "\nsrc\n1\n1\nabc 1 0 Q1A=\n",
+			wantErr: true,
+		}, {
+			name:    "bad checkpoint",
+			text:    snapshotHeader + "\nsrc\n1\n1\n" + id + " 1 0 !!!\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := parseSnapshot(test.text)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("parseSnapshot: %v, wantErr %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func mustOpen(t *testing.T, b []byte, v note.Verifier) *note.Note {
+	t.Helper()
+	n, err := note.Open(b, note.VerifierList(v))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	// Drop the existing signatures so that the note can be re-signed.
+	n.Sigs = nil
+	return n
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testonly
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RPMBSectorSize is the number of bytes in a single RPMB data sector.
+const RPMBSectorSize = 256
+
+// RPMB is a software emulation of a single sector of an eMMC Replay Protected
+// Memory Block partition, as made available to the applet by the OS.
+//
+// As with real RPMB, each write is authenticated with a MAC over the data and
+// the device's write counter, so a write can neither be forged without the key
+// nor replayed once the counter has moved on.
+type RPMB struct {
+	// OnWrite, if set, is called before each write with the value of the
+	// write counter. If it returns an error, the write fails with that error
+	// and the sector is left unchanged.
+	OnWrite func(counter uint32) error
+
+	mu      sync.Mutex
+	key     []byte
+	data    [RPMBSectorSize]byte
+	counter uint32
+}
+
+// NewRPMB creates a new emulated RPMB sector, which has never been written.
+func NewRPMB() *RPMB {
+	return &RPMB{key: []byte("testonly RPMB authentication key")}
+}
+
+// ReadAnchor returns the contents of the sector.
+func (r *RPMB) ReadAnchor(_ context.Context) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.data[:]...), nil
+}
+
+// WriteAnchor replaces the contents of the sector, padding b with zeroes.
+func (r *RPMB) WriteAnchor(_ context.Context, b []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(b) > RPMBSectorSize {
+		return fmt.Errorf("%d bytes is larger than the RPMB sector size of %d", len(b), RPMBSectorSize)
+	}
+	if r.OnWrite != nil {
+		if err := r.OnWrite(r.counter); err != nil {
+			return err
+		}
+	}
+	var d [RPMBSectorSize]byte
+	copy(d[:], b)
+	// This is the request the OS would send to the device on our behalf.
+	return r.program(d, r.counter, r.mac(d, r.counter))
+}
+
+// Counter returns the device's write counter, which is incremented by every
+// successful write.
+func (r *RPMB) Counter() uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counter
+}
+
+// mac returns the authentication code for a write of d with the given counter.
+func (r *RPMB) mac(d [RPMBSectorSize]byte, counter uint32) []byte {
+	h := hmac.New(sha256.New, r.key)
+	h.Write(d[:])
+	h.Write([]byte{byte(counter >> 24), byte(counter >> 16), byte(counter >> 8), byte(counter)})
+	return h.Sum(nil)
+}
+
+// program emulates the device's handling of an authenticated write request.
+// Must be called with r.mu locked.
+func (r *RPMB) program(d [RPMBSectorSize]byte, counter uint32, mac []byte) error {
+	if counter != r.counter {
+		return fmt.Errorf("write counter %d does not match device counter %d", counter, r.counter)
+	}
+	if !hmac.Equal(mac, r.mac(d, counter)) {
+		return errors.New("authentication failure")
+	}
+	if r.counter == ^uint32(0) {
+		return errors.New("write counter expired")
+	}
+	r.data = d
+	r.counter++
+	return nil
+}
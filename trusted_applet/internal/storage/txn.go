@@ -0,0 +1,179 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
+	"k8s.io/klog/v2"
+)
+
+// transactionSlot returns the index of the slot reserved for the intent
+// records of transactions which update more than one slot, which precedes the
+// mirrors of the directory. It returns false if the partition is too small to
+// reserve one.
+func (p *SlotPersistence) transactionSlot() (uint, bool) {
+	r := uint(directoryMirrors + 1)
+	if p.eventSlot() <= mappingConfigSlot+r {
+		return 0, false
+	}
+	return p.eventSlot() - r, true
+}
+
+// recoverTransaction completes any transaction which was interrupted, and
+// rereads the directory if it was changed as a result.
+// Must be called with p.mu write-locked.
+func (p *SlotPersistence) recoverTransaction(ctx context.Context) error {
+	i, ok := p.transactionSlot()
+	if !ok || p.directoryVersion < reservedSlotsVersion {
+		// Until the directory is migrated, the slot may hold a log's state.
+		return nil
+	}
+	found, err := p.part.Recover(ctx, i)
+	if err != nil || !found {
+		return err
+	}
+	klog.Infof("Completed interrupted transaction recorded in slot %d", i)
+	p.directoryStale = false
+	return p.populateMap()
+}
+
+// createLog assigns a slot to a log which doesn't have one, and stores the
+// checkpoint returned by f in it.
+//
+// The directory recording the new slot and the log's checkpoint are written in
+// a single transaction, so that a crash can't leave the log assigned a slot
+// which holds no checkpoint.
+//
+// It returns false, having done nothing, if the log has already been assigned
+// a slot or there's no slot reserved for transactions, in which case the log
+// must be updated in the usual way.
+func (p *SlotPersistence) createLog(ctx context.Context, logID string, f func(current []byte) ([]byte, error)) (bool, error) {
+	p.mu.RLock()
+	_, exists := p.idToSlot[logID]
+	txnSlot, ok := p.transactionSlot()
+	h := p.history
+	p.mu.RUnlock()
+	if exists || !ok {
+		return false, nil
+	}
+
+	// Call f without holding the lock, then check that nobody else added the
+	// log in the meantime.
+	cp, err := f(nil)
+	if err != nil {
+		return true, err
+	}
+	r, err := h.appendHistory(nil, 0, cp)
+	if err != nil {
+		return true, fmt.Errorf("failed to update history: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rollbackErr != nil {
+		return true, p.rollbackErr
+	}
+	if _, exists := p.idToSlot[logID]; exists {
+		return false, nil
+	}
+	if len(p.freeSlots) == 0 {
+		return true, fmt.Errorf("unable to assign slot for log ID %q: %w", logID, ErrPartitionFull)
+	}
+	i := p.freeSlots[0]
+	s, err := p.part.OpenContext(ctx, i)
+	if err != nil {
+		return true, fmt.Errorf("failed to open slot %d: %v", i, err)
+	}
+	// Any stale state left in the free slot is overwritten by the transaction.
+	_, t, err := s.Read()
+	if err != nil {
+		return true, fmt.Errorf("failed to read slot %d: %w", i, readErr(ctx, err))
+	}
+
+	idToSlot := maps.Clone(p.idToSlot)
+	idToSlot[logID] = i
+	d, err := marshalDirectory(directory{Generation: p.directoryGeneration + 1, Slots: idToSlot, Retired: p.retired, Releases: p.releases})
+	if err != nil {
+		return true, err
+	}
+	// The generation is used up even if the transaction fails, since some
+	// copies of the directory may have been written.
+	p.directoryGeneration++
+
+	inUse := make(map[uint]bool)
+	for _, j := range idToSlot {
+		inUse[j] = true
+	}
+	var writes []slots.TxnWrite
+	var copies []*directoryCopy
+	for j := range p.directoryCopies {
+		c := &p.directoryCopies[j]
+		if inUse[c.index] {
+			// As in storeDirectory, a log is yet to be moved out of this
+			// mirror slot.
+			continue
+		}
+		writes = append(writes, slots.TxnWrite{Slot: c.index, Token: c.token, Data: d})
+		copies = append(copies, c)
+	}
+	writes = append(writes, slots.TxnWrite{Slot: i, Token: t, Data: r})
+
+	var tokens []uint32
+	err = p.rollback.update(ctx,
+		func(st witnessState) { st.set(logID, cp) },
+		func() error {
+			var err error
+			if tokens, err = p.part.Commit(ctx, txnSlot, writes); err == nil {
+				return nil
+			}
+			// If the intent record was stored, the transaction will be
+			// completed when the witness restarts, so it can't be abandoned.
+			// Try to complete it now instead.
+			found, rErr := p.part.Recover(ctx, txnSlot)
+			if rErr != nil {
+				return fmt.Errorf("%w: %w", errIndeterminate, errors.Join(err, rErr))
+			}
+			if !found {
+				return err
+			}
+			klog.Warningf("Completed transaction adding log ID %q after commit failed: %v", logID, err)
+			return nil
+		})
+	if tokens == nil {
+		// Pick up the current tokens of any copies which were written.
+		for _, c := range copies {
+			if _, t, err := c.slot.Read(); err == nil {
+				c.token = t
+			}
+		}
+	} else {
+		for j, c := range copies {
+			c.token = tokens[j]
+		}
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to add log ID %q: %w", logID, err)
+	}
+	p.idToSlot = idToSlot
+	p.freeSlots = p.freeSlots[1:]
+	p.directoryStale = len(copies) < len(p.directoryCopies)
+	klog.V(1).Infof("Added new mapping %q -> %d", logID, i)
+	return true, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"testing"
+
+	logfmt "github.com/transparency-dev/formats/log"
+)
+
+func TestCreateLogTransaction(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// fails is the number of writes to the new log's slot which are
+		// corrupted, or -1 for all of them.
+		fails   int
+		wantErr bool
+	}{
+		{name: "healthy"},
+		{name: "transient failure", fails: 1},
+		{name: "interrupted", fails: -1, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := newAnchoredDevice(t)
+			p := d.mustBoot(t)
+			if i, ok := p.transactionSlot(); !ok || i != 4 {
+				t.Fatalf("transactionSlot = %d, %t, want 4, true", i, ok)
+			}
+			start, length, err := p.part.SlotExtent(p.freeSlots[0])
+			if err != nil {
+				t.Fatalf("SlotExtent: %v", err)
+			}
+			fails := test.fails
+			d.md.OnBlockWritten = func(lba uint) {
+				if lba >= start && lba < start+length && fails != 0 {
+					d.md.Storage[lba][0] ^= 0xff
+					fails--
+				}
+			}
+
+			err = p.Update(ctx, "log", func([]byte) ([]byte, error) { return []byte("log\n1\nroot\n"), nil })
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Update: %v, want error %t", err, test.wantErr)
+			}
+			d.md.OnBlockWritten = nil
+			if test.wantErr {
+				// The log's state must not be served until the transaction
+				// is completed.
+				if _, err := p.Latest(ctx, "log"); err == nil {
+					t.Error("Latest succeeded for log which failed to be added")
+				}
+			}
+
+			// Any interrupted transaction is completed when the witness
+			// restarts, which the rollback anchor must accept.
+			r := d.mustBoot(t)
+			checkLatest(t, r, "log", "log\n1\nroot\n")
+			checkCopies(t, r, slotMap{logfmt.ID("log"): 1})
+		})
+	}
+}
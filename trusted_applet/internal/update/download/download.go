@@ -0,0 +1,321 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package download fetches large files over HTTP in chunks, resuming from
+// where it left off when a request fails rather than starting over.
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/machinebox/progress"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultChunkSize  = 1 << 20
+	defaultTimeout    = time.Minute
+	defaultRetries    = 5
+	defaultRetryDelay = 5 * time.Second
+)
+
+// ErrDigestMismatch is returned by Get when the downloaded file doesn't have
+// the expected SHA256 digest.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// Opts configures a Downloader.
+// Fields left unset take sensible defaults.
+type Opts struct {
+	// Client is used to make requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+	// ChunkSize is the number of bytes requested at once.
+	ChunkSize int64
+	// Timeout bounds each request, rather than the download as a whole.
+	Timeout time.Duration
+	// Retries is the number of consecutive requests which may fail without
+	// making any progress before Get gives up.
+	Retries int
+	// RetryDelay is how long to wait before retrying a failed request.
+	RetryDelay time.Duration
+	// LogProgress enables periodic logging of the download's progress.
+	LogProgress bool
+}
+
+// Downloader fetches files using HTTP Range requests.
+//
+// If Get gives up on a download, the data received so far is kept so that the
+// next call to Get for the same URL picks up where it left off. If the server
+// reports that the file has changed in the meantime, the download starts over.
+type Downloader struct {
+	opts Opts
+
+	mu sync.Mutex
+	// partial is the incomplete download left by the last call to Get, if
+	// it failed.
+	partial *state
+}
+
+// state is the progress of a download.
+type state struct {
+	url string
+	// etag identifies the version of the file being downloaded, if the
+	// server provided one.
+	etag string
+	// total is the size of the file, or -1 if it's not yet known.
+	total int64
+	data  []byte
+}
+
+// New returns a Downloader configured with the given options.
+func New(opts Opts) *Downloader {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = defaultRetries
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	return &Downloader{opts: opts}
+}
+
+// Get returns the contents of the file at u.
+//
+// If wantSHA256 is not empty, the download is only returned if its SHA256
+// digest matches, and ErrDigestMismatch is returned otherwise.
+// If the server reports that the file doesn't exist, os.ErrNotExist is
+// returned.
+func (d *Downloader) Get(ctx context.Context, u *url.URL, wantSHA256 []byte) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := d.partial
+	d.partial = nil
+	if s == nil || s.url != u.String() {
+		s = &state{url: u.String(), total: -1}
+	} else {
+		klog.Infof("Resuming download of %q from byte %d", s.url, len(s.data))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pw := progress.NewWriter(writerFunc(func(p []byte) (int, error) {
+		s.data = append(s.data, p...)
+		return len(p), nil
+	}))
+	logStarted := false
+
+	failures := 0
+	for s.total < 0 || int64(len(s.data)) < s.total {
+		n := len(s.data)
+		err := d.fetchChunk(ctx, u, s, pw)
+		if d.opts.LogProgress && !logStarted && s.total > 0 {
+			logStarted = true
+			go logProgress(ctx, s.url, pw, s.total-int64(n))
+		}
+		if err == nil {
+			failures = 0
+			continue
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			klog.Infof("Not found: %q", s.url)
+			return nil, err
+		}
+		if len(s.data) > n {
+			// The request failed part way through, but the data it
+			// delivered has been kept.
+			failures = 0
+		}
+		failures++
+		if failures > d.opts.Retries || ctx.Err() != nil {
+			d.partial = s
+			return nil, fmt.Errorf("download of %q stopped after %d bytes: %w", s.url, len(s.data), err)
+		}
+		klog.Warningf("Download of %q failed after %d bytes, retrying in %v: %v", s.url, len(s.data), d.opts.RetryDelay, err)
+		select {
+		case <-ctx.Done():
+			d.partial = s
+			return nil, fmt.Errorf("download of %q stopped after %d bytes: %w", s.url, len(s.data), ctx.Err())
+		case <-time.After(d.opts.RetryDelay):
+		}
+	}
+	if d.opts.LogProgress {
+		klog.Infof("Downloading %q: finished", s.url)
+	}
+
+	if len(wantSHA256) > 0 {
+		if got := sha256.Sum256(s.data); !bytes.Equal(got[:], wantSHA256) {
+			// The data is discarded, since there's no telling which part of
+			// it is bad.
+			return nil, fmt.Errorf("%q has SHA256 %x, want %x: %w", s.url, got, wantSHA256, ErrDigestMismatch)
+		}
+	}
+	return s.data, nil
+}
+
+// fetchChunk requests the next chunk of the file, and writes whatever part of
+// it is received to w, which appends to s.data.
+func (d *Downloader) fetchChunk(ctx context.Context, u *url.URL, s *state, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+
+	start := int64(len(s.data))
+	end := start + d.opts.ChunkSize - 1
+	if s.total >= 0 && end >= s.total {
+		end = s.total - 1
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if start > 0 && s.etag != "" {
+		// Have the server send the whole file instead if it has changed.
+		req.Header.Set("If-Range", s.etag)
+	}
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http.Client.Do(): %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			klog.Errorf("resp.Body.Close(): %v", err)
+		}
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return os.ErrNotExist
+	case http.StatusOK:
+		// The server doesn't support ranges, or the file has changed, so
+		// the whole file is being sent.
+		if start > 0 {
+			klog.Warningf("Server sent all of %q rather than a range, restarting download", s.url)
+		}
+		s.data = s.data[:0]
+		s.total = -1
+		s.etag = resp.Header.Get("ETag")
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			// Without range support, the partial data is of no use.
+			s.data = s.data[:0]
+			return fmt.Errorf("failed to read response: %v", err)
+		}
+		if n := int64(len(s.data)); resp.ContentLength >= 0 && n != resp.ContentLength {
+			s.data = s.data[:0]
+			return fmt.Errorf("got %d bytes, want %d", n, resp.ContentLength)
+		}
+		s.total = int64(len(s.data))
+		return nil
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// This is expected if the file is empty, in which case the server
+		// reports its size as "bytes */0".
+		if t, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes */"); ok && start == 0 {
+			if n, err := strconv.ParseInt(t, 10, 64); err == nil && n == 0 {
+				s.total = 0
+				return nil
+			}
+		}
+		// Otherwise the file must have shrunk since the download started.
+		s.data, s.total, s.etag = s.data[:0], -1, ""
+		return fmt.Errorf("range %d-%d not satisfiable, restarting download", start, end)
+	default:
+		return fmt.Errorf("unexpected http status %q", resp.Status)
+	}
+
+	first, last, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if first != start || last > end {
+		return fmt.Errorf("got range %d-%d, want %d-%d", first, last, start, end)
+	}
+	if s.total >= 0 && total != s.total {
+		err := fmt.Errorf("size changed from %d to %d, restarting download", s.total, total)
+		s.data, s.total, s.etag = s.data[:0], -1, ""
+		return err
+	}
+	if start == 0 {
+		s.etag = resp.Header.Get("ETag")
+	}
+	s.total = total
+	want := last - first + 1
+	n, err := io.Copy(w, io.LimitReader(resp.Body, want))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if n < want {
+		return fmt.Errorf("got %d bytes of range %d-%d: %w", n, first, last, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// parseContentRange parses a Content-Range header of the form
+// "bytes first-last/total".
+func parseContentRange(h string) (first, last, total int64, err error) {
+	r, ok := strings.CutPrefix(h, "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+	}
+	r, t, ok1 := strings.Cut(r, "/")
+	f, l, ok2 := strings.Cut(r, "-")
+	if !ok1 || !ok2 {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+	}
+	if t == "*" {
+		return 0, 0, 0, fmt.Errorf("server didn't report size in Content-Range %q", h)
+	}
+	var errs [3]error
+	first, errs[0] = strconv.ParseInt(f, 10, 64)
+	last, errs[1] = strconv.ParseInt(l, 10, 64)
+	total, errs[2] = strconv.ParseInt(t, 10, 64)
+	if err := errors.Join(errs[:]...); err != nil || first < 0 || last < first || last >= total {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", h)
+	}
+	return first, last, total, nil
+}
+
+// logProgress logs the progress of a download until ctx is done or the
+// given number of bytes have been written to c.
+func logProgress(ctx context.Context, url string, c progress.Counter, size int64) {
+	for p := range progress.NewTicker(ctx, c, size, time.Second) {
+		klog.Infof("Downloading %q: %d%%, %v remaining...", url, int(p.Percent()), p.Remaining().Round(time.Second))
+	}
+}
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
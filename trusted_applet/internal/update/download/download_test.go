@@ -0,0 +1,272 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server serves a single file, supporting range requests, and can be made to
+// fail requests.
+type server struct {
+	mu      sync.Mutex
+	content []byte
+	etag    string
+	// noRanges makes the server ignore Range headers.
+	noRanges bool
+	// cuts makes the server hang up on the next len(cuts) requests, after
+	// sending the given number of bytes of the response body.
+	cuts []int
+	// ranges records the Range header of each request.
+	ranges []string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path != "/file" {
+		http.NotFound(w, r)
+		return
+	}
+	rng := r.Header.Get("Range")
+	s.ranges = append(s.ranges, rng)
+	if ir := r.Header.Get("If-Range"); ir != "" && ir != s.etag {
+		rng = ""
+	}
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+	}
+
+	body, status := s.content, http.StatusOK
+	if rng != "" && !s.noRanges {
+		var first, last int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &first, &last); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if first >= len(s.content) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(s.content)))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		last = min(last, len(s.content)-1)
+		body, status = s.content[first:last+1], http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(s.content)))
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	w.WriteHeader(status)
+	if len(s.cuts) > 0 {
+		n := min(s.cuts[0], len(body))
+		s.cuts = s.cuts[1:]
+		w.Write(body[:n])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(body)
+}
+
+func newServer(t *testing.T, s *server) *url.URL {
+	t.Helper()
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	u, err := url.Parse(ts.URL + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func testContent(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func testOpts() Opts {
+	return Opts{
+		ChunkSize:  100,
+		Timeout:    5 * time.Second,
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	}
+}
+
+func TestGet(t *testing.T) {
+	content := testContent(1050)
+	for _, test := range []struct {
+		desc     string
+		srv      *server
+		wantReqs int
+	}{
+		{
+			desc:     "chunked",
+			srv:      &server{content: content},
+			wantReqs: 11,
+		}, {
+			desc:     "no range support",
+			srv:      &server{content: content, noRanges: true},
+			wantReqs: 1,
+		}, {
+			// Each of the first 3 requests is cut short, and the retry
+			// carries on from where it stopped.
+			desc:     "resumes after failures",
+			srv:      &server{content: content, cuts: []int{30, 30, 30}},
+			wantReqs: 13,
+		}, {
+			desc:     "empty",
+			srv:      &server{content: []byte{}},
+			wantReqs: 1,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			want := test.srv.content
+			sum := sha256.Sum256(want)
+			d := New(testOpts())
+			got, err := d.Get(context.Background(), newServer(t, test.srv), sum[:])
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Get returned %d bytes which differ from the %d expected", len(got), len(want))
+			}
+			if got := len(test.srv.ranges); got != test.wantReqs {
+				t.Errorf("Got %d requests, want %d: %q", got, test.wantReqs, test.srv.ranges)
+			}
+		})
+	}
+}
+
+func TestGetResumesAcrossCalls(t *testing.T) {
+	content := testContent(1000)
+	sum := sha256.Sum256(content)
+	// Part of the first chunk is delivered, then requests fail without
+	// making any progress until Get gives up.
+	srv := &server{content: content, etag: `"v1"`, cuts: []int{400, 0, 0}}
+	u := newServer(t, srv)
+	d := New(testOpts())
+	d.opts.ChunkSize = 600
+
+	_, err := d.Get(context.Background(), u, sum[:])
+	if err == nil {
+		t.Fatal("Get succeeded despite server failing")
+	}
+	if got, want := len(d.partial.data), 400; got != want {
+		t.Fatalf("Kept %d bytes of failed download, want %d", got, want)
+	}
+
+	srv.mu.Lock()
+	srv.ranges = nil
+	srv.mu.Unlock()
+	got, err := d.Get(context.Background(), u, sum[:])
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Resumed download returned wrong content")
+	}
+	if got, want := srv.ranges, []string{"bytes=400-999"}; !slices.Equal(got, want) {
+		t.Errorf("Resumed download made requests %q, want %q", got, want)
+	}
+}
+
+func TestGetRestartsIfChanged(t *testing.T) {
+	old, content := testContent(500), bytes.Repeat([]byte("new"), 200)
+	sum := sha256.Sum256(content)
+	srv := &server{content: content, etag: `"v2"`}
+	u := newServer(t, srv)
+	d := New(testOpts())
+	// Leave a partial download of an older version of the file.
+	d.partial = &state{url: u.String(), etag: `"v1"`, total: int64(len(old)), data: bytes.Clone(old[:200])}
+
+	got, err := d.Get(context.Background(), u, sum[:])
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Get returned old content")
+	}
+	if got, want := srv.ranges[0], "bytes=200-299"; got != want {
+		t.Errorf("First request had range %q, want %q", got, want)
+	}
+}
+
+func TestGetErrors(t *testing.T) {
+	content := testContent(300)
+	srv := &server{content: content}
+	u := newServer(t, srv)
+	d := New(testOpts())
+
+	missing, err := u.Parse("/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(context.Background(), missing, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get of missing file returned %v, want %v", err, os.ErrNotExist)
+	}
+	if got := len(srv.ranges); got != 0 {
+		t.Errorf("Missing file was requested %d times", got)
+	}
+
+	bad := sha256.Sum256([]byte("something else"))
+	if _, err := d.Get(context.Background(), u, bad[:]); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("Get with wrong digest returned %v, want %v", err, ErrDigestMismatch)
+	}
+	if d.partial != nil {
+		t.Error("Download with wrong digest was kept for resumption")
+	}
+	if got, err := d.Get(context.Background(), u, nil); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("Get without digest returned (%d bytes, %v), want content", len(got), err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, test := range []struct {
+		h                  string
+		first, last, total int64
+		wantErr            bool
+	}{
+		{h: "bytes 0-99/1000", first: 0, last: 99, total: 1000},
+		{h: "bytes 900-999/1000", first: 900, last: 999, total: 1000},
+		{h: "bytes 0-99/*", wantErr: true},
+		{h: "bytes 0-1000/1000", wantErr: true},
+		{h: "bytes 10-9/1000", wantErr: true},
+		{h: "0-99/1000", wantErr: true},
+		{h: "bytes a-b/c", wantErr: true},
+		{h: "", wantErr: true},
+	} {
+		first, last, total, err := parseContentRange(test.h)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("parseContentRange(%q): got err %v, want err %t", test.h, err, test.wantErr)
+			continue
+		}
+		if first != test.first || last != test.last || total != test.total {
+			t.Errorf("parseContentRange(%q) = (%d, %d, %d), want (%d, %d, %d)", test.h, first, last, total, test.first, test.last, test.total)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rollout staggers the installation of firmware updates across a
+// fleet of devices, so that a bad release doesn't reach them all at once.
+package rollout
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/transparency-dev/armored-witness-common/release/firmware/ftlog"
+	"github.com/transparency-dev/armored-witness-common/release/firmware/update"
+	"k8s.io/klog/v2"
+)
+
+// Policy decides when a release found in the firmware log may be installed.
+// The zero value allows releases to be installed as soon as they're found.
+type Policy struct {
+	// MinAge is how long a release must have been in the log before any
+	// device installs it. This is measured from when the device first found
+	// the release in the log.
+	MinAge time.Duration
+	// MaxDelay is the longest a device waits after MinAge has passed before
+	// installing a release. Each device waits a fixed fraction of this
+	// derived from its serial number, so that a fleet updates gradually.
+	MaxDelay time.Duration
+	// Windows are the times of day during which releases may be installed.
+	// If empty, releases may be installed at any time.
+	Windows []Window
+	// PinOS and PinApplet, if set, are the only versions of the OS and
+	// applet which may be installed.
+	//
+	// Only the latest release of each component in the log can be installed,
+	// so a pin can hold a device back until the pinned version is released,
+	// but it can't move a device to an older release once a newer one has
+	// been logged. Such pins are rejected with ErrStalePin.
+	PinOS, PinApplet *semver.Version
+}
+
+// ErrStalePin is returned by Check if a component is pinned to a version
+// older than its latest release, which can never be installed.
+var ErrStalePin = errors.New("pinned version is older than the latest release")
+
+// Delay returns how long the device with the given serial number waits after
+// a release is MinAge old before installing it.
+func (p Policy) Delay(serial string) time.Duration {
+	if p.MaxDelay <= 0 {
+		return 0
+	}
+	h := sha256.Sum256([]byte(serial))
+	return time.Duration(binary.BigEndian.Uint64(h[:]) % uint64(p.MaxDelay))
+}
+
+// Check returns an error describing why a release which was first found in
+// the log at the given time may not be installed now, or nil if it may.
+// The pin is the version which the release's component is pinned to, if any.
+func (p Policy) Check(v semver.Version, pin *semver.Version, found, now time.Time, serial string) error {
+	if pin != nil && !v.Equal(*pin) {
+		if pin.LessThan(v) {
+			return fmt.Errorf("%w: pinned to version %s, but only the latest release, %s, can be installed", ErrStalePin, pin, v)
+		}
+		return fmt.Errorf("pinned to version %s", pin)
+	}
+	if due := found.Add(p.MinAge + p.Delay(serial)); now.Before(due) {
+		return fmt.Errorf("not due to be installed until %s", due.UTC().Format(time.RFC3339))
+	}
+	if len(p.Windows) == 0 {
+		return nil
+	}
+	for _, w := range p.Windows {
+		if w.Contains(now) {
+			return nil
+		}
+	}
+	return fmt.Errorf("outside maintenance windows %s", FormatWindows(p.Windows))
+}
+
+// Window is a daily period of time, in UTC.
+type Window struct {
+	// Start and End are the times since midnight at which the window starts
+	// and ends. If End is before Start, the window spans midnight.
+	Start, End time.Duration
+}
+
+// Contains returns true if t is within the window.
+func (w Window) Contains(t time.Time) bool {
+	t = t.UTC()
+	d := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	if w.Start <= w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+func (w Window) String() string {
+	hm := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return hm(w.Start) + "-" + hm(w.End)
+}
+
+// ParseWindows parses a comma separated list of windows of the form
+// "HH:MM-HH:MM", e.g. "02:00-04:00,22:30-23:00". An empty string is parsed
+// as no windows.
+func ParseWindows(s string) ([]Window, error) {
+	var r []Window
+	if s == "" {
+		return r, nil
+	}
+	for _, ws := range strings.Split(s, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(ws), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid window %q, want HH:MM-HH:MM", ws)
+		}
+		var w Window
+		var err error
+		if w.Start, err = parseTimeOfDay(start); err != nil {
+			return nil, fmt.Errorf("invalid window %q: %v", ws, err)
+		}
+		if w.End, err = parseTimeOfDay(end); err != nil {
+			return nil, fmt.Errorf("invalid window %q: %v", ws, err)
+		}
+		r = append(r, w)
+	}
+	return r, nil
+}
+
+// FormatWindows returns windows in the form parsed by ParseWindows.
+func FormatWindows(ws []Window) string {
+	s := make([]string, len(ws))
+	for i, w := range ws {
+		s[i] = w.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// parseTimeOfDay parses a time of the form "HH:MM" as the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Releases records when releases were first found, so that they can be aged
+// across restarts. It's implemented by storage.SlotPersistence.
+type Releases interface {
+	// ReleaseFound returns the time at which the named release was first
+	// found, and whether it has been.
+	ReleaseFound(ctx context.Context, release string) (time.Time, bool, error)
+	// FoundRelease records that the named release was found at time t,
+	// unless it has been found before, and returns the time at which it was
+	// first found.
+	FoundRelease(ctx context.Context, release string, t time.Time) (time.Time, error)
+}
+
+// Remote is an update.Remote which hides releases from the update.Updater
+// until the policy allows them to be installed.
+//
+// Releases are aged from when they were first found by this device, since the
+// log doesn't record when they were added. These times are kept in Releases,
+// so that the wait for a pending release isn't restarted whenever the device
+// restarts.
+type Remote struct {
+	update.Remote
+	policy          Policy
+	releases        Releases
+	serial          string
+	installedOS     semver.Version
+	installedApplet semver.Version
+	now             func() time.Time
+}
+
+// NewRemote returns a Remote which applies the given policy to the releases
+// found by r, on the device with the given serial number and installed
+// versions.
+func NewRemote(r update.Remote, p Policy, releases Releases, serial string, installedOS, installedApplet semver.Version) *Remote {
+	return &Remote{
+		Remote:          r,
+		policy:          p,
+		releases:        releases,
+		serial:          serial,
+		installedOS:     installedOS,
+		installedApplet: installedApplet,
+		now:             time.Now,
+	}
+}
+
+// GetLatestVersions returns the latest versions of the OS and applet which
+// may be installed now. For components with a newer release which may not
+// yet be installed, the installed version is returned instead.
+func (r *Remote) GetLatestVersions(ctx context.Context) (semver.Version, semver.Version, error) {
+	osVer, appVer, err := r.Remote.GetLatestVersions(ctx)
+	if err != nil {
+		return osVer, appVer, err
+	}
+	return r.allowed(ctx, ftlog.ComponentOS, osVer, r.installedOS),
+		r.allowed(ctx, ftlog.ComponentApplet, appVer, r.installedApplet),
+		nil
+}
+
+// allowed returns latest if it may be installed now, or installed otherwise.
+func (r *Remote) allowed(ctx context.Context, component string, latest, installed semver.Version) semver.Version {
+	if !installed.LessThan(latest) {
+		return latest
+	}
+	if err := r.Check(ctx, component, latest); errors.Is(err, ErrStalePin) {
+		klog.Errorf("Holding back %s update to %s, the pin must be updated: %v", component, latest, err)
+		return installed
+	} else if err != nil {
+		klog.Infof("Holding back %s update to %s: %v", component, latest, err)
+		return installed
+	}
+	return latest
+}
+
+// Check returns an error describing why the given release of a component,
+// ftlog.ComponentOS or ftlog.ComponentApplet, may not be installed now, or
+// nil if it may. Releases are considered found from the first time they're
+// checked.
+func (r *Remote) Check(ctx context.Context, component string, v semver.Version) error {
+	pin, err := r.pin(component)
+	if err != nil {
+		return err
+	}
+	now := r.now()
+	found, err := r.releases.FoundRelease(ctx, releaseName(component, v), now)
+	if err != nil {
+		return fmt.Errorf("failed to record release: %v", err)
+	}
+	if found.Equal(now) {
+		klog.Infof("Found %s release %s", component, v)
+	}
+	return r.policy.Check(v, pin, found, now, r.serial)
+}
+
+// Peek is like Check, but doesn't record the release as found, so is suitable
+// for reporting what would happen without affecting it. A release which
+// hasn't been found before is treated as being found now.
+func (r *Remote) Peek(ctx context.Context, component string, v semver.Version) error {
+	pin, err := r.pin(component)
+	if err != nil {
+		return err
+	}
+	now := r.now()
+	found, ok, err := r.releases.ReleaseFound(ctx, releaseName(component, v))
+	if err != nil {
+		return fmt.Errorf("failed to look up release: %v", err)
+	}
+	if !ok {
+		found = now
+	}
+	return r.policy.Check(v, pin, found, now, r.serial)
+}
+
+// pin returns the version which the given component is pinned to, if any.
+func (r *Remote) pin(component string) (*semver.Version, error) {
+	switch component {
+	case ftlog.ComponentOS:
+		return r.policy.PinOS, nil
+	case ftlog.ComponentApplet:
+		return r.policy.PinApplet, nil
+	}
+	return nil, fmt.Errorf("non updatable component %q", component)
+}
+
+// releaseName returns the name under which the release is recorded in
+// Releases.
+func releaseName(component string, v semver.Version) string {
+	return component + " " + v.String()
+}
@@ -0,0 +1,256 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/transparency-dev/armored-witness-common/release/firmware"
+	"github.com/transparency-dev/armored-witness-common/release/firmware/ftlog"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{MaxDelay: 24 * time.Hour}
+	if a, b := p.Delay("serial-a"), p.Delay("serial-a"); a != b {
+		t.Errorf("Delay isn't deterministic: %v != %v", a, b)
+	}
+	// The delays should be spread across the range.
+	var early, late int
+	for i := range 100 {
+		d := p.Delay(fmt.Sprintf("serial-%d", i))
+		if d < 0 || d >= p.MaxDelay {
+			t.Fatalf("Delay = %v, want in [0, %v)", d, p.MaxDelay)
+		}
+		if d < p.MaxDelay/2 {
+			early++
+		} else {
+			late++
+		}
+	}
+	if early < 25 || late < 25 {
+		t.Errorf("Delays are lopsided: %d in the first half, %d in the second", early, late)
+	}
+	if d := (Policy{}).Delay("serial-a"); d != 0 {
+		t.Errorf("Delay with no MaxDelay = %v, want 0", d)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	ws, err := ParseWindows("02:00-04:00, 22:30-01:00")
+	if err != nil {
+		t.Fatalf("ParseWindows: %v", err)
+	}
+	if got, want := FormatWindows(ws), "02:00-04:00,22:30-01:00"; got != want {
+		t.Errorf("FormatWindows = %q, want %q", got, want)
+	}
+	for _, test := range []struct {
+		at   string
+		want bool
+	}{
+		{at: "01:59", want: false},
+		{at: "02:00", want: true},
+		{at: "03:59", want: true},
+		{at: "04:00", want: false},
+		{at: "22:29", want: false},
+		{at: "23:59", want: true},
+		{at: "00:30", want: true},
+		{at: "01:00", want: false},
+	} {
+		at, err := time.Parse("2006-01-02 15:04", "2026-03-04 "+test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := false
+		for _, w := range ws {
+			got = got || w.Contains(at)
+		}
+		if got != test.want {
+			t.Errorf("Windows contain %s = %t, want %t", test.at, got, test.want)
+		}
+	}
+
+	for _, bad := range []string{"02:00", "2-4", "02:00-25:00", "02:00-04:00,"} {
+		if _, err := ParseWindows(bad); err == nil {
+			t.Errorf("ParseWindows(%q) succeeded, want error", bad)
+		}
+	}
+	if ws, err := ParseWindows(""); err != nil || len(ws) != 0 {
+		t.Errorf("ParseWindows(\"\") = %v, %v, want no windows", ws, err)
+	}
+}
+
+// fakeRemote reports fixed latest versions.
+type fakeRemote struct {
+	os, applet semver.Version
+}
+
+func (f fakeRemote) GetLatestVersions(context.Context) (semver.Version, semver.Version, error) {
+	return f.os, f.applet, nil
+}
+
+func (f fakeRemote) GetOS(context.Context) (firmware.Bundle, error) {
+	return firmware.Bundle{}, nil
+}
+
+func (f fakeRemote) GetApplet(context.Context) (firmware.Bundle, error) {
+	return firmware.Bundle{}, nil
+}
+
+// memReleases records when releases were found in memory.
+type memReleases map[string]time.Time
+
+func (m memReleases) ReleaseFound(_ context.Context, release string) (time.Time, bool, error) {
+	t, ok := m[release]
+	return t, ok, nil
+}
+
+func (m memReleases) FoundRelease(_ context.Context, release string, t time.Time) (time.Time, error) {
+	if f, ok := m[release]; ok {
+		return f, nil
+	}
+	m[release] = t
+	return t, nil
+}
+
+func TestRemote(t *testing.T) {
+	v := func(s string) semver.Version { return *semver.New(s) }
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	const serial = "serial-a"
+	p := Policy{MinAge: time.Hour, MaxDelay: 2 * time.Hour}
+	due := start.Add(p.MinAge + p.Delay(serial))
+
+	for _, test := range []struct {
+		desc       string
+		policy     Policy
+		at         time.Time
+		wantOS     string
+		wantApplet string
+	}{
+		{
+			desc:       "no policy",
+			at:         start,
+			wantOS:     "1.1.0",
+			wantApplet: "2.1.0",
+		}, {
+			desc:       "too soon",
+			policy:     p,
+			at:         due.Add(-time.Second),
+			wantOS:     "1.0.0",
+			wantApplet: "2.0.0",
+		}, {
+			desc:       "due",
+			policy:     p,
+			at:         due,
+			wantOS:     "1.1.0",
+			wantApplet: "2.1.0",
+		}, {
+			desc:       "pinned",
+			policy:     Policy{PinOS: semver.New("1.0.5"), PinApplet: semver.New("2.1.0")},
+			at:         start,
+			wantOS:     "1.0.0",
+			wantApplet: "2.1.0",
+		}, {
+			desc:       "outside window",
+			policy:     Policy{Windows: []Window{{Start: 13 * time.Hour, End: 14 * time.Hour}}},
+			at:         start.Add(30 * time.Minute),
+			wantOS:     "1.0.0",
+			wantApplet: "2.0.0",
+		}, {
+			desc:       "inside window",
+			policy:     Policy{Windows: []Window{{Start: 13 * time.Hour, End: 14 * time.Hour}}},
+			at:         start.Add(90 * time.Minute),
+			wantOS:     "1.1.0",
+			wantApplet: "2.1.0",
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			releases := memReleases{}
+			remote := fakeRemote{os: v("1.1.0"), applet: v("2.1.0")}
+			r := NewRemote(remote, test.policy, releases, serial, v("1.0.0"), v("2.0.0"))
+			// The releases are first found at the start time.
+			r.now = func() time.Time { return start }
+			if _, _, err := r.GetLatestVersions(context.Background()); err != nil {
+				t.Fatalf("GetLatestVersions: %v", err)
+			}
+			// The time they were found must survive a restart.
+			r = NewRemote(remote, test.policy, releases, serial, v("1.0.0"), v("2.0.0"))
+			r.now = func() time.Time { return test.at }
+			osVer, appVer, err := r.GetLatestVersions(context.Background())
+			if err != nil {
+				t.Fatalf("GetLatestVersions: %v", err)
+			}
+			if got := osVer.String(); got != test.wantOS {
+				t.Errorf("Got OS version %s, want %s", got, test.wantOS)
+			}
+			if got := appVer.String(); got != test.wantApplet {
+				t.Errorf("Got applet version %s, want %s", got, test.wantApplet)
+			}
+			// Check agrees with what GetLatestVersions reports.
+			if err := r.Check(context.Background(), ftlog.ComponentOS, v("1.1.0")); (err == nil) != (test.wantOS == "1.1.0") {
+				t.Errorf("Check(OS 1.1.0) = %v, want error %t", err, test.wantOS != "1.1.0")
+			}
+		})
+	}
+}
+
+func TestPeek(t *testing.T) {
+	v := *semver.New("1.1.0")
+	start := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	releases := memReleases{}
+	r := NewRemote(fakeRemote{}, Policy{MinAge: time.Hour}, releases, "serial", v, v)
+	r.now = func() time.Time { return start }
+	if err := r.Peek(context.Background(), ftlog.ComponentOS, v); err == nil {
+		t.Error("Peek of a new release succeeded, want too soon")
+	}
+	if len(releases) != 0 {
+		t.Errorf("Peek recorded releases %v, want none", releases)
+	}
+
+	if err := r.Check(context.Background(), ftlog.ComponentOS, v); err == nil {
+		t.Error("Check of a new release succeeded, want too soon")
+	}
+	r.now = func() time.Time { return start.Add(time.Hour) }
+	if err := r.Peek(context.Background(), ftlog.ComponentOS, v); err != nil {
+		t.Errorf("Peek once the release is old enough: %v", err)
+	}
+}
+
+func TestStalePin(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		pin       string
+		wantErr   bool
+		wantStale bool
+	}{
+		{pin: "1.1.0"},
+		{pin: "1.2.0", wantErr: true},
+		{pin: "1.0.5", wantErr: true, wantStale: true},
+	} {
+		t.Run(test.pin, func(t *testing.T) {
+			err := Policy{}.Check(*semver.New("1.1.0"), semver.New(test.pin), now, now, "serial")
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Check: %v, wantErr %t", err, test.wantErr)
+			}
+			if got := errors.Is(err, ErrStalePin); got != test.wantStale {
+				t.Errorf("Check: %v, want ErrStalePin %t", err, test.wantStale)
+			}
+		})
+	}
+}
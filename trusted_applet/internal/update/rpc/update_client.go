@@ -29,6 +29,9 @@ const fwUpdateChunkSize = 1 << 20
 // Client is an implementation of the Local interface which uses RPCs to the TrustedOS
 // to perform the updates.
 type Client struct {
+	// BeforeReboot, if set, is called just before the device is rebooted, e.g.
+	// to flush any pending writes to storage.
+	BeforeReboot func()
 }
 
 // GetInstalledVersions returns the semantic versions of the OS and Applet
@@ -82,5 +85,8 @@ func sendChunkedUpdate(t string, rpcName string, fb firmware.Bundle) error {
 // Reboot instructs the device to reboot after new firmware is installed.
 // This call will not return and deferred functions will not be run.
 func (r Client) Reboot() {
+	if r.BeforeReboot != nil {
+		r.BeforeReboot()
+	}
 	_ = syscall.Call("RPC.Reboot", nil, nil)
 }
@@ -0,0 +1,112 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package witnessed requires checkpoints from the firmware transparency log to
+// be cosigned by witnesses, so that the log can't present this device with a
+// split view of its contents.
+package witnessed
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	f_note "github.com/transparency-dev/formats/note"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"github.com/transparency-dev/serverless-log/client"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// Policy requires checkpoints to be signed by the log and cosigned by at
+// least Threshold of Witnesses. A zero Threshold requires only the log's
+// signature.
+type Policy struct {
+	// Log verifies the log's own signature on checkpoints.
+	Log note.Verifier
+	// Witnesses verify the cosignatures of the trusted witnesses.
+	Witnesses []note.Verifier
+	// Threshold is the number of distinct witnesses which must have
+	// cosigned a checkpoint.
+	Threshold int
+	// Self, if set, is called with the body of a checkpoint which doesn't
+	// meet the threshold, and returns true if this device has witnessed it
+	// itself. If so, that counts as one more cosignature.
+	Self func(body string) bool
+}
+
+// ParseWitnesses parses a whitespace separated list of witness verifier
+// keys. These are standard Ed25519 keys, which are used to verify
+// cosignature/v1 signatures.
+func ParseWitnesses(s string) ([]note.Verifier, error) {
+	var r []note.Verifier
+	for _, k := range strings.Fields(s) {
+		v, err := f_note.NewVerifierForCosignatureV1(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid witness key %q: %v", k, err)
+		}
+		r = append(r, v)
+	}
+	return r, nil
+}
+
+// Verify checks that the checkpoint meets the policy, and returns it opened.
+func (p Policy) Verify(cp []byte) (*note.Note, error) {
+	possible := len(p.Witnesses)
+	if p.Self != nil {
+		possible++
+	}
+	if p.Threshold > possible {
+		return nil, fmt.Errorf("threshold of %d cosignatures can never be met by %d witnesses", p.Threshold, possible)
+	}
+	n, err := note.Open(cp, note.VerifierList(append([]note.Verifier{p.Log}, p.Witnesses...)...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %v", err)
+	}
+
+	logSigned := false
+	cosigned := make(map[string]bool)
+	for _, s := range n.Sigs {
+		if s.Name == p.Log.Name() && s.Hash == p.Log.KeyHash() {
+			logSigned = true
+			continue
+		}
+		cosigned[fmt.Sprintf("%s+%08x", s.Name, s.Hash)] = true
+	}
+	if !logSigned {
+		return nil, fmt.Errorf("checkpoint isn't signed by log %q", p.Log.Name())
+	}
+	got := len(cosigned)
+	if got < p.Threshold && p.Self != nil && p.Self(n.Text) {
+		got++
+	}
+	if got < p.Threshold {
+		return nil, fmt.Errorf("checkpoint has %d of the %d witness cosignatures required", got, p.Threshold)
+	}
+	return n, nil
+}
+
+// Fetcher returns a client.Fetcher which fetches from the log using f, and
+// rejects any checkpoint which doesn't meet the policy.
+func (p Policy) Fetcher(f client.Fetcher) client.Fetcher {
+	return func(ctx context.Context, path string) ([]byte, error) {
+		r, err := f(ctx, path)
+		if err != nil || path != layout.CheckpointPath {
+			return r, err
+		}
+		if _, err := p.Verify(r); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+}
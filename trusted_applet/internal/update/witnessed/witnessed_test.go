@@ -0,0 +1,170 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package witnessed
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	f_note "github.com/transparency-dev/formats/note"
+	"github.com/transparency-dev/serverless-log/api/layout"
+	"golang.org/x/mod/sumdb/note"
+)
+
+const body = "example.com/log\n42\nqINS1GRFhWHwdkUeqLEoP4yEMkTBBzxBkGwGQlVlVcs=\n"
+
+// newSigner returns a signer and the verifier key for it. Witness signers
+// produce cosignature/v1 signatures.
+func newSigner(t *testing.T, name string, witness bool) (note.Signer, string) {
+	t.Helper()
+	skey, vkey, err := note.GenerateKey(rand.Reader, name)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if witness {
+		s, err := f_note.NewSignerForCosignatureV1(skey)
+		if err != nil {
+			t.Fatalf("NewSignerForCosignatureV1: %v", err)
+		}
+		return s, vkey
+	}
+	s, err := note.NewSigner(skey)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	return s, vkey
+}
+
+func TestVerify(t *testing.T) {
+	logSigner, logKey := newSigner(t, "log", false)
+	logVerifier, err := note.NewVerifier(logKey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	var witnessSigners []note.Signer
+	var witnessKeys []string
+	for _, name := range []string{"w1", "w2", "w3"} {
+		s, k := newSigner(t, name, true)
+		witnessSigners = append(witnessSigners, s)
+		witnessKeys = append(witnessKeys, k)
+	}
+	witnesses, err := ParseWitnesses(strings.Join(witnessKeys, "\n"))
+	if err != nil {
+		t.Fatalf("ParseWitnesses: %v", err)
+	}
+	stranger, _ := newSigner(t, "stranger", true)
+
+	sign := func(signers ...note.Signer) []byte {
+		cp, err := note.Sign(&note.Note{Text: body}, signers...)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return cp
+	}
+	self := func(b string) bool { return b == body }
+
+	for _, test := range []struct {
+		desc    string
+		cp      []byte
+		policy  Policy
+		wantErr bool
+	}{
+		{
+			desc:   "no threshold",
+			cp:     sign(logSigner),
+			policy: Policy{Log: logVerifier, Witnesses: witnesses},
+		}, {
+			desc:   "threshold met",
+			cp:     sign(logSigner, witnessSigners[0], witnessSigners[2]),
+			policy: Policy{Log: logVerifier, Witnesses: witnesses, Threshold: 2},
+		}, {
+			desc:    "threshold not met",
+			cp:      sign(logSigner, witnessSigners[0], stranger),
+			policy:  Policy{Log: logVerifier, Witnesses: witnesses, Threshold: 2},
+			wantErr: true,
+		}, {
+			desc:    "not signed by log",
+			cp:      sign(witnessSigners...),
+			policy:  Policy{Log: logVerifier, Witnesses: witnesses, Threshold: 2},
+			wantErr: true,
+		}, {
+			desc:   "self witnessed",
+			cp:     sign(logSigner, witnessSigners[1]),
+			policy: Policy{Log: logVerifier, Witnesses: witnesses, Threshold: 2, Self: self},
+		}, {
+			desc:    "not self witnessed",
+			cp:      sign(logSigner, witnessSigners[1]),
+			policy:  Policy{Log: logVerifier, Witnesses: witnesses, Threshold: 2, Self: func(string) bool { return false }},
+			wantErr: true,
+		}, {
+			desc:    "impossible threshold",
+			cp:      sign(append([]note.Signer{logSigner}, witnessSigners...)...),
+			policy:  Policy{Log: logVerifier, Witnesses: witnesses, Threshold: 4},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			n, err := test.policy.Verify(test.cp)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Verify = %v, want error %t", err, test.wantErr)
+			}
+			if err == nil && n.Text != body {
+				t.Errorf("Verify returned note %q, want %q", n.Text, body)
+			}
+		})
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	logSigner, logKey := newSigner(t, "log", false)
+	logVerifier, err := note.NewVerifier(logKey)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	_, witnessKey := newSigner(t, "w1", true)
+	witnesses, err := ParseWitnesses(witnessKey)
+	if err != nil {
+		t.Fatalf("ParseWitnesses: %v", err)
+	}
+	cp, err := note.Sign(&note.Note{Text: body}, logSigner)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	f := Policy{Log: logVerifier, Witnesses: witnesses, Threshold: 1}.Fetcher(func(_ context.Context, path string) ([]byte, error) {
+		if path == layout.CheckpointPath {
+			return cp, nil
+		}
+		return []byte("tile"), nil
+	})
+
+	ctx := context.Background()
+	if _, err := f(ctx, layout.CheckpointPath); err == nil {
+		t.Error("Fetching uncosigned checkpoint succeeded")
+	}
+	if got, err := f(ctx, "tile/0/000"); err != nil || string(got) != "tile" {
+		t.Errorf("Fetching tile = %q, %v, want tile", got, err)
+	}
+}
+
+func TestParseWitnesses(t *testing.T) {
+	if ws, err := ParseWitnesses(" "); err != nil || len(ws) != 0 {
+		t.Errorf("ParseWitnesses(\" \") = %v, %v, want no witnesses", ws, err)
+	}
+	if _, err := ParseWitnesses("not-a-key"); err == nil {
+		t.Error("ParseWitnesses of invalid key succeeded")
+	}
+}
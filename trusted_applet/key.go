@@ -16,6 +16,7 @@ package main
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/binary"
@@ -108,6 +109,38 @@ func deriveIdentityKeys() {
 
 }
 
+// deriveStorageAEAD creates the cipher used to encrypt data stored in the slots
+// partition.
+//
+// Like the attestation identity, this key uses a static diversifier so that it's
+// stable for the lifetime of the device; the diversifier MUST NOT be changed, or
+// all stored data will become unreadable.
+func deriveStorageAEAD() cipher.AEAD {
+	var status api.Status
+	if err := syscall.Call("RPC.Status", nil, &status); err != nil {
+		log.Fatalf("Failed to fetch Status: %v", err)
+	}
+	prefix := ""
+	if !status.HAB {
+		prefix = "DEV:"
+	}
+
+	r := deriveHKDF(fmt.Sprintf("%sStorageKey-id:0", prefix), status.Serial)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(r, key); err != nil {
+		log.Fatalf("Failed to derive storage key: %v", err)
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalf("Failed to create storage cipher: %v", err)
+	}
+	a, err := cipher.NewGCM(b)
+	if err != nil {
+		log.Fatalf("Failed to create storage AEAD: %v", err)
+	}
+	return a
+}
+
 // attestID uses attestSigningKey to sign a note which binds the passed in witness ID to this device's
 // serial number and current identity counter.
 //
@@ -16,6 +16,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -40,9 +42,10 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 
 	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/layout"
 	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/mmc"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/remap"
 	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage/slots"
-	"github.com/transparency-dev/armored-witness-common/release/firmware/update"
 	"github.com/transparency-dev/armored-witness-os/api"
 	"github.com/transparency-dev/armored-witness-os/api/rpc"
 
@@ -56,24 +59,84 @@ import (
 )
 
 const (
-	// slotsPartitionStartBlock defines where our witness data storage partition starts.
-	// Changing this location is overwhelmingly likely to result in data loss.
-	slotsPartitionStartBlock = 0x400000
-	// slotsPartitionLengthBlocks specifies the size of the slots partition.
-	// Increasing this value is relatively safe, if you're sure there is no data
-	// stored in blocks which follow the current partition.
+	// storageWriteBackInterval is the maximum time checkpoint updates are held
+	// in memory, coalescing repeated updates for the same log, before being
+	// written to the MMC. Zero disables write-back.
 	//
-	// We're starting with enough space for 4096 slots of 512KB each, which should be plenty.
-	slotsPartitionLengthBlocks = 0x400000
+	// Enabling this reduces MMC wear, but updates which have not been flushed
+	// when power is lost are forgotten despite having been cosigned.
+	storageWriteBackInterval = 0 * time.Second
 
-	// slotSizeBytes is the size of each individual slot in the partition.
-	// Changing this is overwhelmingly likely to result in data loss.
-	slotSizeBytes = 512 << 10
+	// storageEncryption enables authenticated encryption of the data stored
+	// in slots, using a key derived from the hardware.
+	//
+	// Once enabled, storage written by this firmware cannot be read by
+	// releases which predate encryption, so rolling back is not possible
+	// without losing witness state.
+	storageEncryption = false
+	// storageAllowPlaintext permits slots written before encryption was
+	// enabled to be read, and causes them to be encrypted in the background.
+	// This should be disabled once all devices have been migrated, as
+	// otherwise unauthenticated data can be written to storage.
+	storageAllowPlaintext = true
+
+	// storageLazyOpen defers scanning each slot's journal until the slot is
+	// first used, rather than scanning all of them at boot. Most slots are
+	// never used, so this greatly reduces the time taken to start, but
+	// problems with a slot's journal are only reported when it's first used.
+	storageLazyOpen = true
+
+	// rollbackProtection records the witness state in the eMMC RPMB
+	// partition, and refuses to serve or update checkpoints if the MMC is
+	// found to have been rolled back to an earlier state, e.g. by restoring an
+	// old image of it, until the storage is reset.
+	//
+	// This requires an OS which provides the RPC.ReadRPMB and RPC.WriteRPMB
+	// calls. Each checkpoint update then costs two RPMB writes, and is always
+	// written through to the MMC regardless of storageWriteBackInterval.
+	rollbackProtection = false
+
+	// storageReconstructDirectory rebuilds the logID → slot directory from
+	// the checkpoints stored in each slot if too few of its copies can be
+	// read, rather than refusing to start. Which logs had been retired is
+	// lost, so they'll be witnessed again until they're next retired.
+	storageReconstructDirectory = false
+
+	// mmcOperationTimeout bounds the time taken by each read or write of the
+	// MMC, so that a wedged card surfaces as an error rather than hanging the
+	// witness. Since each transfer is serviced by the OS with the applet
+	// suspended, this is only checked between transfers.
+	mmcOperationTimeout = 10 * time.Second
+	// mmcVerifyWrites reads back every block written to the MMC, and remaps
+	// blocks which persistently fail to hold their data to spare blocks.
+	// This doubles the cost of writes, but catches an ageing MMC silently
+	// corrupting journals.
+	//
+	// Blocks which have already been remapped stay remapped regardless.
+	mmcVerifyWrites = false
+
+	// checkpointHistoryEntries is the number of checkpoints to retain for each
+	// log, including the latest, for later retrieval via the admin API.
+	// Values less than 2 disable history.
+	//
+	// Every retained checkpoint is rewritten with each update, so this
+	// multiplies the MMC writes made per checkpoint.
+	checkpointHistoryEntries = 0
+	// checkpointHistoryBytes bounds the total size of the checkpoints retained
+	// for each log.
+	checkpointHistoryBytes = 16 << 10
 
 	// updateCheckInterval is the time between checking the FT Log for firmware
 	// updates.
 	updateCheckInterval = 5 * time.Minute
 
+	// retiredLogGracePeriod is how long the state of a log which is no longer
+	// witnessed is kept before its slot is reclaimed.
+	retiredLogGracePeriod = 30 * 24 * time.Hour
+	// retiredLogCheckInterval is the time between attempts to reclaim slots
+	// from retired logs.
+	retiredLogCheckInterval = 24 * time.Hour
+
 	// rateLimit is the maximum number of requests per second to serve.
 	rateLimit = float64(30)
 )
@@ -87,6 +150,7 @@ var (
 
 	cfg *api.Configuration
 
+	part        *slots.Partition
 	persistence *storage.SlotPersistence
 )
 
@@ -111,6 +175,42 @@ func initMetrics() {
 	})
 }
 
+// registerStorageMetrics exports the write-back statistics for the partition,
+// and the error counts of the MMC.
+func registerStorageMetrics(p *slots.Partition, dev *remap.Device) {
+	for name, f := range map[string]struct {
+		help string
+		v    func(slots.WriteBackStats) uint64
+	}{
+		"storage_writes":           {"Number of writes made to storage slots", func(s slots.WriteBackStats) uint64 { return s.Writes }},
+		"storage_coalesced_writes": {"Number of slot writes superseded before being flushed to storage", func(s slots.WriteBackStats) uint64 { return s.Coalesced }},
+		"storage_flushes":          {"Number of journal writes made to flush pending slot writes", func(s slots.WriteBackStats) uint64 { return s.Flushes }},
+		"storage_flush_errors":     {"Number of failed attempts to flush pending slot writes", func(s slots.WriteBackStats) uint64 { return s.FlushErrors }},
+	} {
+		prom.MustRegister(prom.NewCounterFunc(prom.CounterOpts{Name: "omniwitness_" + name, Help: f.help}, func() float64 {
+			return float64(f.v(p.WriteBackStats()))
+		}))
+	}
+	for name, f := range map[string]struct {
+		help string
+		v    func(remap.Stats) uint64
+	}{
+		"mmc_read_errors":     {"Number of failed reads from the MMC", func(s remap.Stats) uint64 { return s.ReadErrors }},
+		"mmc_write_errors":    {"Number of failed writes to the MMC", func(s remap.Stats) uint64 { return s.WriteErrors }},
+		"mmc_verify_failures": {"Number of blocks written to the MMC which read back differently", func(s remap.Stats) uint64 { return s.VerifyFailures }},
+	} {
+		prom.MustRegister(prom.NewCounterFunc(prom.CounterOpts{Name: "omniwitness_" + name, Help: f.help}, func() float64 {
+			return float64(f.v(dev.Stats()))
+		}))
+	}
+	prom.MustRegister(prom.NewGaugeFunc(prom.GaugeOpts{Name: "omniwitness_mmc_remapped_blocks", Help: "Number of MMC blocks remapped to spares"}, func() float64 {
+		return float64(dev.Stats().Remapped)
+	}))
+	prom.MustRegister(prom.NewGaugeFunc(prom.GaugeOpts{Name: "omniwitness_mmc_spare_blocks_free", Help: "Number of unused spare MMC blocks"}, func() float64 {
+		return float64(dev.Stats().SparesFree)
+	}))
+}
+
 func init() {
 	runtime.Exit = func(_ int32) { applet.Exit() }
 }
@@ -149,6 +249,7 @@ func main() {
 		Resolver:  DefaultResolver,
 		NTPServer: DefaultNTP,
 	}
+	setRolloutDefaults(cfg)
 
 	// Send network configuration to Trusted OS for network initialization.
 	//
@@ -215,29 +316,44 @@ func main() {
 	go eventHandler()
 
 	klog.Infof("Opening storage...")
-	part := openStorage()
+	var dev *remap.Device
+	part, dev = openStorage(ctx)
 	klog.Infof("Storage opened.")
-
-	// Set this to true to "wipe" the storage.
-	// Currently this simply force-writes an entry with zero bytes to
-	// each known slot.
-	// If the journal(s) become corrupt a larger hammer will be required.
-	reinit := false
-	if reinit {
-		for i := 10; i > 0; i-- {
-			klog.Infof("Erasing in %ds", i)
-			time.Sleep(time.Second)
-		}
-		if err := part.Erase(); err != nil {
-			klog.Exitf("Failed to erase partition: %v", err)
-		}
-		klog.Exit("Erase completed")
+	if storageWriteBackInterval > 0 {
+		part.EnableWriteBack(ctx, storageWriteBackInterval)
 	}
+	registerStorageMetrics(part, dev)
 
 	persistence = storage.NewSlotPersistence(part)
-	if err := persistence.Init(ctx); err != nil {
+	persistence.SetHistoryPolicy(storage.HistoryPolicy{
+		MaxEntries: checkpointHistoryEntries,
+		MaxBytes:   checkpointHistoryBytes,
+	})
+	if rollbackProtection {
+		persistence.SetRollbackAnchor(mmc.RPMB{})
+	}
+	err := persistence.Init(ctx)
+	if errors.Is(err, storage.ErrDirectoryUnavailable) && storageReconstructDirectory {
+		klog.Errorf("Reconstructing witness storage directory: %v", err)
+		_, err = persistence.ReconstructDirectory(ctx)
+	}
+	if errors.Is(err, storage.ErrRollback) {
+		// Carry on so that the storage can be reset via the admin API, the
+		// persistence will refuse to serve or update checkpoints until then.
+		klog.Errorf("Witness storage has been rolled back: %v", err)
+	} else if err != nil {
 		klog.Exitf("Failed to create persistence layer: %v", err)
 	}
+	go func() {
+		if err := persistence.UpgradeRecords(ctx); err != nil {
+			klog.Errorf("Failed to upgrade stored records: %v", err)
+		}
+		if storageEncryption && storageAllowPlaintext {
+			if _, err := part.Encrypt(ctx); err != nil {
+				klog.Errorf("Failed to encrypt stored records: %v", err)
+			}
+		}
+	}()
 
 	// Wait for a DHCP address to be assigned if that's what we're configured to do
 	if cfg.DHCP {
@@ -308,7 +424,16 @@ func runWithNetworking(ctx context.Context) error {
 		return ctx.Err()
 	}
 
-	triggerUpdate := updateChecker(ctx, updateCheckInterval)
+	fwUpdates := &updates{}
+	triggerUpdate := updateChecker(ctx, updateCheckInterval, fwUpdates)
+	// The witness, and the collection of state for logs it no longer
+	// witnesses, must use the same log config.
+	logs, err := omniwitness.NewStaticLogConfig(omniwitness.DefaultConfigLogs)
+	if err != nil {
+		return fmt.Errorf("failed to parse witness log config: %v", err)
+	}
+	// This needs a sensible time, so must come after NTP.
+	go retiredLogCollector(ctx, logs, retiredLogCheckInterval)
 
 	listenCfg := &net.ListenConfig{}
 
@@ -332,6 +457,30 @@ func runWithNetworking(ctx context.Context) error {
 			w.Header().Add("Content-Type", "text/plain")
 			w.Write([]byte("ok, check /consolelog!"))
 		})
+		srvMux.Handle("/updateplan", &planHandler{ctx: ctx, updates: fwUpdates})
+		srvMux.Handle("/scrub", &scrubHandler{ctx: ctx, part: part})
+		srvMux.Handle("/reset", newResetHandler(ctx, persistence))
+		srvMux.Handle("/snapshot", newSnapshotHandler(ctx, persistence, logs))
+		srvMux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+			h, err := persistence.History(r.Context(), r.URL.Query().Get("origin"))
+			if err != nil {
+				code := http.StatusInternalServerError
+				switch {
+				case errors.Is(err, storage.ErrNotFound):
+					code = http.StatusNotFound
+				case errors.Is(err, slots.ErrTimeout), errors.Is(err, storage.ErrRollback):
+					// The storage may become usable again, after a retry or
+					// a reset.
+					code = http.StatusServiceUnavailable
+				}
+				http.Error(w, err.Error(), code)
+				return
+			}
+			w.Header().Add("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(h); err != nil {
+				klog.Warningf("Failed to write history: %v", err)
+			}
+		})
 		srvMux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
 			var s api.Status
 			if err := syscall.Call("RPC.Status", nil, &s); err != nil {
@@ -366,6 +515,7 @@ func runWithNetworking(ctx context.Context) error {
 		FeedInterval:           30 * time.Second,
 		DistributeInterval:     30 * time.Second,
 		DistributeRateLimit:    0.1,
+		Logs:                   logs,
 	}
 	if BastionAddr != "" {
 		klog.Infof("Bastion host %q configured", BastionAddr)
@@ -393,11 +543,7 @@ func runWithNetworking(ctx context.Context) error {
 	return ctx.Err()
 }
 
-func updateChecker(ctx context.Context, i time.Duration) chan<- struct{} {
-	var updateFetcher *update.Fetcher
-	var updateClient *update.Updater
-	var err error
-
+func updateChecker(ctx context.Context, i time.Duration, u *updates) chan<- struct{} {
 	trigger := make(chan struct{}, 1)
 
 	go func(ctx context.Context) {
@@ -421,23 +567,21 @@ func updateChecker(ctx context.Context, i time.Duration) chan<- struct{} {
 				if !ok {
 					return
 				}
-				if updateFetcher == nil || updateClient == nil {
-					updateFetcher, updateClient, err = updater(ctx)
-					if err != nil {
-						klog.Errorf("Failed to create updater: %v", err)
-						continue
+				err := u.do(ctx, func(fu *firmwareUpdate) error {
+					counterFirmwareUpdateAttempt.Inc()
+					klog.V(1).Info("Scanning for available updates")
+					if err := fu.fetcher.Scan(ctx); err != nil {
+						return fmt.Errorf("UpdateFetcher.Scan: %v", err)
 					}
+					if err := fu.updater.Update(ctx); err != nil {
+						klog.Errorf("Update: %v", err)
+					}
+					counterFirmwareUpdateSuccess.Inc()
+					return nil
+				})
+				if err != nil {
+					klog.Error(err)
 				}
-				counterFirmwareUpdateAttempt.Inc()
-				klog.V(1).Info("Scanning for available updates")
-				if err := updateFetcher.Scan(ctx); err != nil {
-					klog.Errorf("UpdateFetcher.Scan: %v", err)
-					continue
-				}
-				if err := updateClient.Update(ctx); err != nil {
-					klog.Errorf("Update: %v", err)
-				}
-				counterFirmwareUpdateSuccess.Inc()
 			case <-ctx.Done():
 				return
 			}
@@ -447,29 +591,90 @@ func updateChecker(ctx context.Context, i time.Duration) chan<- struct{} {
 	return trigger
 }
 
-func openStorage() *slots.Partition {
+// retiredLogCollector periodically retires any stored logs which are no longer
+// in the witness' log config, reinstating any which have returned to it, and
+// reclaims the slots of those which have been retired for long enough.
+func retiredLogCollector(ctx context.Context, logs omniwitness.LogConfig, i time.Duration) {
+	t := time.NewTicker(i)
+	defer t.Stop()
+	for {
+		// Slots are only reclaimed once the retirements are known to be up
+		// to date, so that a log which has returned to the config is never
+		// collected.
+		if err := retireUnwitnessedLogs(ctx, logs); err != nil {
+			klog.Errorf("Failed to retire unwitnessed logs: %v", err)
+		} else if n, err := persistence.CollectGarbage(ctx, retiredLogGracePeriod); err != nil {
+			klog.Errorf("Failed to reclaim slots from retired logs: %v", err)
+		} else if n > 0 {
+			klog.Infof("Reclaimed %d slots from retired logs", n)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retireUnwitnessedLogs retires the stored logs which aren't in the log config.
+func retireUnwitnessedLogs(ctx context.Context, logs omniwitness.LogConfig) error {
+	vs, err := witnessedLogs(ctx, logs)
+	if err != nil {
+		return fmt.Errorf("failed to list witnessed logs: %v", err)
+	}
+	if len(vs) == 0 {
+		// Most likely the config failed to load, rather than the witness
+		// having been deliberately configured with no logs.
+		return errors.New("log config is empty")
+	}
+	origins := make([]string, 0, len(vs))
+	for o := range vs {
+		origins = append(origins, o)
+	}
+	return persistence.RetireAllExcept(ctx, origins)
+}
+
+// witnessedLogs returns the verifiers of the logs in the log config, keyed by
+// origin.
+func witnessedLogs(ctx context.Context, logs omniwitness.LogConfig) (map[string]sumdb_note.Verifier, error) {
+	r := make(map[string]sumdb_note.Verifier)
+	for l, err := range logs.Logs(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if l.Origin == "" {
+			l.Origin = l.Verifier.Name()
+		}
+		r[l.Origin] = l.Verifier
+	}
+	return r, nil
+}
+
+func openStorage(ctx context.Context) (*slots.Partition, *remap.Device) {
 	var info usdhc.CardInfo
 	if err := syscall.Call("RPC.CardInfo", nil, &info); err != nil {
 		klog.Exitf("Failed to get cardinfo: %v", err)
 	}
 	klog.Infof("CardInfo: %+v", info)
-	// dev is our access to the MMC storage.
-	dev := &mmc.Device{CardInfo: &info}
-	bs := dev.BlockSize()
-	geo := slots.Geometry{
-		Start:  slotsPartitionStartBlock,
-		Length: slotsPartitionLengthBlocks,
-	}
-	sl := slotSizeBytes / bs
-	for i := uint(0); i < geo.Length; i += sl {
-		geo.SlotLengths = append(geo.SlotLengths, sl)
+	// dev is our access to the MMC storage, via the layer which remaps bad
+	// blocks.
+	dev, err := remap.New(ctx, &mmc.Device{CardInfo: &info, Timeout: mmcOperationTimeout}, layout.Remap())
+	if err != nil {
+		klog.Exitf("Failed to open remapped MMC: %v", err)
 	}
-
-	p, err := slots.OpenPartition(dev, geo)
+	dev.SetVerify(mmcVerifyWrites)
+	p, err := slots.OpenPartition(dev, layout.Geometry(dev.BlockSize()))
 	if err != nil {
 		klog.Exitf("Failed to open partition: %v", err)
 	}
-	return p
+	if storageEncryption {
+		p.SetEncryption(slots.Encryption{
+			AEAD:           deriveStorageAEAD(),
+			AllowPlaintext: storageAllowPlaintext,
+		})
+	}
+	p.SetLazyOpen(storageLazyOpen)
+	return p, dev
 }
 
 type logHandler struct {
@@ -486,3 +691,75 @@ func (c *logHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	res.Header().Add("Content-Type", "text/plain")
 	res.Write(l)
 }
+
+// scrubHandler serves the results of the most recent storage scrub, and
+// starts a new scrub in the background when it receives a POST request.
+type scrubHandler struct {
+	ctx  context.Context
+	part *slots.Partition
+
+	// mu guards the fields below.
+	mu     sync.Mutex
+	status scrubStatus
+}
+
+// scrubStatus describes the state of the most recent storage scrub.
+type scrubStatus struct {
+	Running  bool
+	Started  *time.Time `json:",omitempty"`
+	Finished *time.Time `json:",omitempty"`
+	Error    string     `json:",omitempty"`
+	// SlotsChecked is the number of slots which were scrubbed.
+	SlotsChecked int
+	// Slots holds the reports for slots which have been written to or
+	// which have problems, empty and healthy slots are omitted.
+	Slots []slots.SlotHealth
+}
+
+func (s *scrubHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		res.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(s.status); err != nil {
+			klog.Errorf("Failed to write scrub status: %v", err)
+		}
+	case http.MethodPost:
+		res.Header().Add("Content-Type", "text/plain")
+		if s.status.Running {
+			res.WriteHeader(http.StatusConflict)
+			res.Write([]byte("scrub already in progress"))
+			return
+		}
+		now := time.Now()
+		s.status = scrubStatus{Running: true, Started: &now}
+		go s.scrub()
+		res.WriteHeader(http.StatusAccepted)
+		res.Write([]byte("ok, scrub started"))
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *scrubHandler) scrub() {
+	klog.Info("Scrubbing storage...")
+	r, err := s.part.Scrub(s.ctx)
+	klog.Infof("Storage scrub finished: %v", err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+	now := time.Now()
+	s.status.Finished = &now
+	if err != nil {
+		s.status.Error = err.Error()
+	}
+	s.status.SlotsChecked = len(r)
+	for _, h := range r {
+		if h.Valid > 0 || !h.Healthy() {
+			s.status.Slots = append(s.status.Slots, h)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage"
+	"github.com/transparency-dev/armored-witness-os/api"
+	"github.com/usbarmory/GoTEE/syscall"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// resetVerifier is the note verifier string for the key which is permitted to
+// authorise a reset of the witness storage.
+// This var is set at compile time using the -X flag, see the Makefile.
+// If it is not set, storage resets cannot be requested.
+var resetVerifier string
+
+const (
+	// resetChallengeHeader is the first line of the challenge note which must
+	// be signed in order to authorise a storage reset.
+	resetChallengeHeader = "ArmoredWitness storage reset v1"
+
+	// resetChallengeTTL is how long an issued challenge remains valid.
+	resetChallengeTTL = 5 * time.Minute
+
+	// maxResetRequestBytes is the largest signed challenge we'll accept.
+	maxResetRequestBytes = 4 << 10
+)
+
+// resetHandler allows an authorised operator to erase and re-initialise the
+// witness storage without needing to rebuild the applet.
+//
+// A reset is a two step process:
+//  1. A GET request returns a challenge note text which binds a single-use
+//     random nonce to this device's serial number.
+//  2. The challenge note, signed by the key corresponding to resetVerifier,
+//     is POSTed back to confirm the reset.
+//
+// The challenge note text is formatted like so:
+//
+//	"ArmoredWitness storage reset v1"
+//	<Device serial string>
+//	<Nonce ASCII hex string>
+type resetHandler struct {
+	ctx         context.Context
+	verifier    note.Verifier
+	persistence *storage.SlotPersistence
+
+	// mu guards the fields below.
+	mu        sync.Mutex
+	challenge string
+	expires   time.Time
+}
+
+// newResetHandler creates a handler for authorised storage reset requests.
+// If no resetVerifier was compiled in, the handler will refuse all requests.
+func newResetHandler(ctx context.Context, p *storage.SlotPersistence) *resetHandler {
+	h := &resetHandler{
+		ctx:         ctx,
+		persistence: p,
+	}
+	if resetVerifier == "" {
+		return h
+	}
+	v, err := note.NewVerifier(resetVerifier)
+	if err != nil {
+		klog.Errorf("Invalid reset verifier, storage reset disabled: %v", err)
+		return h
+	}
+	h.verifier = v
+	return h
+}
+
+func (h *resetHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", "text/plain")
+	if h.verifier == nil {
+		res.WriteHeader(http.StatusNotImplemented)
+		res.Write([]byte("storage reset is not enabled in this build"))
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		var s api.Status
+		if err := syscall.Call("RPC.Status", nil, &s); err != nil {
+			klog.Errorf("Failed to fetch status: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			klog.Errorf("Failed to create reset nonce: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		h.challenge = fmt.Sprintf("%s\n%s\n%x\n", resetChallengeHeader, s.Serial, nonce)
+		h.expires = time.Now().Add(resetChallengeTTL)
+		res.Write([]byte(h.challenge))
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxResetRequestBytes))
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n, err := note.Open(body, note.VerifierList(h.verifier))
+		if err != nil {
+			klog.Warningf("Rejected storage reset request: %v", err)
+			res.WriteHeader(http.StatusForbidden)
+			res.Write([]byte("invalid signature"))
+			return
+		}
+		if h.challenge == "" || n.Text != h.challenge || time.Now().After(h.expires) {
+			klog.Warning("Rejected storage reset request: unknown or expired challenge")
+			res.WriteHeader(http.StatusForbidden)
+			res.Write([]byte("unknown or expired challenge"))
+			return
+		}
+		// Challenges are single-use.
+		h.challenge = ""
+
+		reason := fmt.Sprintf("requested via admin API, authorised by %q", h.verifier.Name())
+		go func() {
+			if err := h.persistence.Reset(h.ctx, reason); err != nil {
+				klog.Errorf("Storage reset failed: %v", err)
+				return
+			}
+			klog.Info("Storage reset completed")
+		}()
+		res.WriteHeader(http.StatusAccepted)
+		res.Write([]byte("ok, reset started, check /consolelog!"))
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
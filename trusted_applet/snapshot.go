@@ -0,0 +1,125 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/storage"
+	"github.com/transparency-dev/armored-witness-os/api"
+	"github.com/transparency-dev/witness/omniwitness"
+	"github.com/usbarmory/GoTEE/syscall"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// maxSnapshotBytes is the largest state snapshot we'll accept for import.
+const maxSnapshotBytes = 8 << 20
+
+// snapshotHandler allows the witness state to be exported from one device and
+// imported onto another, e.g. when replacing hardware.
+//
+// A GET request returns a snapshot of the state of this device, signed by its
+// attestation key.
+//
+// A POST request imports a snapshot taken on another device. The snapshot must
+// have been signed by the key corresponding to resetVerifier to show that an
+// operator has authorised the import. This device has no way to tell whether
+// an attestation key belongs to a genuine device, so the operator is
+// responsible for checking the source device's signature before cosigning.
+type snapshotHandler struct {
+	persistence *storage.SlotPersistence
+	// logs holds the verifiers for the logs witnessed by this device, keyed
+	// by origin, which imported checkpoints must be signed by.
+	logs map[string]note.Verifier
+	// operator verifies the operator's authorisation of imports, and is nil
+	// if no resetVerifier was compiled in.
+	operator note.Verifier
+}
+
+// newSnapshotHandler creates a handler for exporting and importing state
+// snapshots.
+func newSnapshotHandler(ctx context.Context, p *storage.SlotPersistence, logConfig omniwitness.LogConfig) *snapshotHandler {
+	h := &snapshotHandler{persistence: p}
+	if resetVerifier == "" {
+		return h
+	}
+	v, err := note.NewVerifier(resetVerifier)
+	if err != nil {
+		klog.Errorf("Invalid reset verifier, snapshot import disabled: %v", err)
+		return h
+	}
+	logs, err := witnessedLogs(ctx, logConfig)
+	if err != nil {
+		klog.Errorf("Failed to list witnessed logs, snapshot import disabled: %v", err)
+		return h
+	}
+	h.operator, h.logs = v, logs
+	return h
+}
+
+func (h *snapshotHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		var s api.Status
+		if err := syscall.Call("RPC.Status", nil, &s); err != nil {
+			klog.Errorf("Failed to fetch status: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		signer, err := note.NewSigner(attestSigningKey)
+		if err != nil {
+			klog.Errorf("Failed to create attestation signer: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		snap, err := h.persistence.Export(req.Context(), s.Serial, signer)
+		if err != nil {
+			klog.Errorf("Failed to export snapshot: %v", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		res.Header().Add("Content-Type", "text/plain")
+		res.Write(snap)
+	case http.MethodPost:
+		if h.operator == nil {
+			res.WriteHeader(http.StatusNotImplemented)
+			res.Write([]byte("snapshot import is not enabled in this build"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxSnapshotBytes))
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sum, err := h.persistence.Import(req.Context(), body, h.logs, h.operator)
+		if err != nil {
+			klog.Warningf("Snapshot import failed: %v", err)
+			res.WriteHeader(http.StatusForbidden)
+			res.Write([]byte(err.Error()))
+			return
+		}
+		klog.Infof("Imported snapshot: %+v", sum)
+		res.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(sum); err != nil {
+			klog.Warningf("Failed to write snapshot summary: %v", err)
+		}
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -22,15 +22,25 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/machinebox/progress"
+	"github.com/coreos/go-semver/semver"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/update/download"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/update/rollout"
 	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/update/rpc"
+	"github.com/transparency-dev/armored-witness-applet/trusted_applet/internal/update/witnessed"
 	"github.com/transparency-dev/armored-witness-common/release/firmware"
 	"github.com/transparency-dev/armored-witness-common/release/firmware/ftlog"
 	"github.com/transparency-dev/armored-witness-common/release/firmware/update"
+	"github.com/transparency-dev/armored-witness-os/api"
+	logfmt "github.com/transparency-dev/formats/log"
+	"github.com/transparency-dev/merkle/rfc6962"
 	"github.com/transparency-dev/serverless-log/client"
+	"github.com/usbarmory/GoTEE/syscall"
 	"golang.org/x/mod/sumdb/note"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"k8s.io/klog/v2"
 )
 
@@ -42,34 +52,71 @@ var (
 	updateLogVerifier                    string
 	updateAppletVerifier                 string
 	updateOSVerifier1, updateOSVerifier2 string
+
+	// These are the defaults for the staged rollout of updates, which are all
+	// optional and can be overridden by the applet configuration, see
+	// rolloutFields. updateMinReleaseAge and updateMaxRolloutDelay are
+	// durations, e.g. "24h", updateWindows is a list of daily maintenance
+	// windows in UTC, e.g. "02:00-04:00,14:00-15:00", and updatePinOS and
+	// updatePinApplet are the only versions of each which will be installed.
+	// See rollout.Policy.
+	updateMinReleaseAge          string
+	updateMaxRolloutDelay        string
+	updateWindows                string
+	updatePinOS, updatePinApplet string
+
+	// These require checkpoints from the firmware log to be cosigned by
+	// witnesses, and are optional. updateWitnessVerifiers is a whitespace
+	// separated list of witness verifier keys, updateWitnessThreshold is the
+	// number of them which must have cosigned a checkpoint, and if
+	// updateWitnessSelf is "true" then this device witnessing a checkpoint
+	// itself counts as one cosignature. See witnessed.Policy.
+	updateWitnessVerifiers string
+	updateWitnessThreshold string
+	updateWitnessSelf      string
 )
 
-// updater returns an updater struct configured from the compiled-in
-// parameters above.
-func updater(ctx context.Context) (*update.Fetcher, *update.Updater, error) {
+// firmwareUpdate holds the components used to find, verify, and install
+// firmware updates.
+type firmwareUpdate struct {
+	fetcher  *update.Fetcher
+	cache    cachingRemote
+	remote   *rollout.Remote
+	updater  *update.Updater
+	local    *rpc.Client
+	verifier fwVerifier
+}
+
+// updater returns a firmwareUpdate configured from the compiled-in
+// parameters above and the applet configuration.
+func updater(ctx context.Context) (*firmwareUpdate, error) {
 	if updateLogURL[len(updateLogURL)-1] != '/' {
 		updateLogURL += "/"
 	}
 	logBaseURL, err := url.Parse(updateLogURL)
 	if err != nil {
-		return nil, nil, fmt.Errorf("firmware log URL invalid: %v", err)
+		return nil, fmt.Errorf("firmware log URL invalid: %v", err)
 	}
 
 	logVerifier, err := note.NewVerifier(updateLogVerifier)
 	if err != nil {
-		return nil, nil, fmt.Errorf("invalid firmware log verifier: %v", err)
+		return nil, fmt.Errorf("invalid firmware log verifier: %v", err)
 	}
 	appletVerifier, err := note.NewVerifier(updateAppletVerifier)
 	if err != nil {
-		return nil, nil, fmt.Errorf("invalid applet verifier: %v", err)
+		return nil, fmt.Errorf("invalid applet verifier: %v", err)
 	}
 	osVerifier1, err := note.NewVerifier(updateOSVerifier1)
 	if err != nil {
-		return nil, nil, fmt.Errorf("invalid OS verifier 1: %v", err)
+		return nil, fmt.Errorf("invalid OS verifier 1: %v", err)
 	}
 	osVerifier2, err := note.NewVerifier(updateOSVerifier2)
 	if err != nil {
-		return nil, nil, fmt.Errorf("invalid OS verifier 2: %v", err)
+		return nil, fmt.Errorf("invalid OS verifier 2: %v", err)
+	}
+	witnessPolicy, err := witnessPolicy(ctx, logVerifier, newFetcher(logBaseURL, 30*time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("invalid witness policy: %v", err)
 	}
 
 	if updateBinariesURL[len(updateBinariesURL)-1] != '/' {
@@ -77,23 +124,32 @@ func updater(ctx context.Context) (*update.Fetcher, *update.Updater, error) {
 	}
 	binBaseURL, err := url.Parse(updateBinariesURL)
 	if err != nil {
-		return nil, nil, fmt.Errorf("binaries URL invalid: %v", err)
+		return nil, fmt.Errorf("binaries URL invalid: %v", err)
 	}
-	bf := newFetcher(binBaseURL, 5*time.Minute, true)
+	// Firmware images are large, so are fetched in chunks which survive a
+	// flaky connection, and checked against the digest in the manifest.
+	dl := download.New(download.Opts{
+		Timeout:     time.Minute,
+		LogProgress: true,
+	})
 	binFetcher := func(ctx context.Context, r ftlog.FirmwareRelease) ([]byte, []byte, error) {
 		p, err := update.BinaryPath(r)
 		if err != nil {
 			return nil, nil, fmt.Errorf("BinaryPath: %v", err)
 		}
+		u, err := binBaseURL.Parse(p)
+		if err != nil {
+			return nil, nil, err
+		}
 		klog.Infof("Fetching %v bin from %q", r.Component, p)
 		// We don't auto-update the bootloader, so no need to fetch HAB signatures.
-		bin, err := bf(ctx, p)
+		bin, err := dl.Get(ctx, u, r.Output.FirmwareDigestSha256)
 		return bin, nil, err
 	}
 
 	updateFetcher, err := update.NewFetcher(ctx,
 		update.FetcherOpts{
-			LogFetcher:     newFetcher(logBaseURL, 30*time.Second, false),
+			LogFetcher:     witnessPolicy.Fetcher(newFetcher(logBaseURL, 30*time.Second)),
 			LogOrigin:      updateLogOrigin,
 			LogVerifier:    logVerifier,
 			BinaryFetcher:  binFetcher,
@@ -103,28 +159,180 @@ func updater(ctx context.Context) (*update.Fetcher, *update.Updater, error) {
 			// cannot update those components.
 		})
 	if err != nil {
-		return nil, nil, fmt.Errorf("NewFetcher: %v", err)
+		return nil, fmt.Errorf("NewFetcher: %v", err)
 	}
 
-	fwVerifier := newFWVerifier(updateLogOrigin, logVerifier, appletVerifier, []note.Verifier{osVerifier1, osVerifier2})
-	updater, err := update.NewUpdater(&rpc.Client{}, updateFetcher, fwVerifier)
+	fwVerifier := newFWVerifier(updateLogOrigin, logVerifier, witnessPolicy, appletVerifier, []note.Verifier{osVerifier1, osVerifier2})
+	rpcClient := &rpc.Client{
+		BeforeReboot: func() {
+			if err := persistence.Flush(ctx); err != nil {
+				klog.Errorf("Failed to flush storage before reboot: %v", err)
+			}
+		},
+	}
+	policy, err := rolloutPolicy(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rollout policy: %v", err)
+	}
+	var status api.Status
+	if err := syscall.Call("RPC.Status", nil, &status); err != nil {
+		return nil, fmt.Errorf("failed to fetch Status: %v", err)
+	}
+	osVer, appVer, err := rpcClient.GetInstalledVersions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine installed versions: %v", err)
+	}
+	cache := cachingRemote{Fetcher: updateFetcher, verifier: fwVerifier, cache: persistence}
+	cache.evictInstalled(ctx, osVer, appVer)
+	remote := rollout.NewRemote(cache, policy, persistence, status.Serial, osVer, appVer)
+	updater, err := update.NewUpdater(rpcClient, remote, fwVerifier)
 	if err != nil {
-		return nil, nil, fmt.Errorf("NewUdater: %v", err)
+		return nil, fmt.Errorf("NewUdater: %v", err)
+	}
+	return &firmwareUpdate{
+		fetcher:  updateFetcher,
+		cache:    cache,
+		remote:   remote,
+		updater:  updater,
+		local:    rpcClient,
+		verifier: fwVerifier,
+	}, nil
+}
+
+// rolloutFields maps the names of the api.Configuration fields which configure
+// the staged rollout of updates to the compiled-in defaults for them.
+//
+// The fields are looked up by name so that an OS whose api.Configuration
+// doesn't have them yet falls back to the defaults.
+var rolloutFields = map[protoreflect.Name]*string{
+	"UpdateMinReleaseAge":   &updateMinReleaseAge,
+	"UpdateMaxRolloutDelay": &updateMaxRolloutDelay,
+	"UpdateWindows":         &updateWindows,
+	"UpdatePinOS":           &updatePinOS,
+	"UpdatePinApplet":       &updatePinApplet,
+}
+
+// setRolloutDefaults sets the rollout fields which cfg has to their
+// compiled-in defaults.
+func setRolloutDefaults(cfg *api.Configuration) {
+	m := cfg.ProtoReflect()
+	for name, def := range rolloutFields {
+		if f := m.Descriptor().Fields().ByName(name); f != nil && f.Kind() == protoreflect.StringKind {
+			m.Set(f, protoreflect.ValueOfString(*def))
+		}
 	}
-	return updateFetcher, updater, nil
+}
+
+// rolloutField returns the value of the named rollout field of cfg, or its
+// compiled-in default if cfg doesn't have that field.
+func rolloutField(cfg *api.Configuration, name protoreflect.Name) string {
+	m := cfg.ProtoReflect()
+	f := m.Descriptor().Fields().ByName(name)
+	if f == nil || f.Kind() != protoreflect.StringKind {
+		return *rolloutFields[name]
+	}
+	return m.Get(f).String()
+}
+
+// rolloutPolicy returns the staged rollout policy set by cfg.
+func rolloutPolicy(cfg *api.Configuration) (rollout.Policy, error) {
+	var p rollout.Policy
+	var err error
+	if v := rolloutField(cfg, "UpdateMinReleaseAge"); v != "" {
+		if p.MinAge, err = time.ParseDuration(v); err != nil {
+			return p, fmt.Errorf("minimum release age: %v", err)
+		}
+	}
+	if v := rolloutField(cfg, "UpdateMaxRolloutDelay"); v != "" {
+		if p.MaxDelay, err = time.ParseDuration(v); err != nil {
+			return p, fmt.Errorf("maximum rollout delay: %v", err)
+		}
+	}
+	if p.Windows, err = rollout.ParseWindows(rolloutField(cfg, "UpdateWindows")); err != nil {
+		return p, fmt.Errorf("maintenance windows: %v", err)
+	}
+	if v := rolloutField(cfg, "UpdatePinOS"); v != "" {
+		if p.PinOS, err = semver.NewVersion(strings.TrimPrefix(v, "v")); err != nil {
+			return p, fmt.Errorf("OS pin: %v", err)
+		}
+	}
+	if v := rolloutField(cfg, "UpdatePinApplet"); v != "" {
+		if p.PinApplet, err = semver.NewVersion(strings.TrimPrefix(v, "v")); err != nil {
+			return p, fmt.Errorf("applet pin: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// witnessPolicy returns the policy for witness cosignatures on firmware log
+// checkpoints configured by the compiled-in parameters above. The log is
+// fetched from using f to check consistency with this device's witness.
+func witnessPolicy(ctx context.Context, logVerifier note.Verifier, f client.Fetcher) (witnessed.Policy, error) {
+	p := witnessed.Policy{Log: logVerifier}
+	var err error
+	if p.Witnesses, err = witnessed.ParseWitnesses(updateWitnessVerifiers); err != nil {
+		return p, err
+	}
+	if updateWitnessThreshold != "" {
+		if p.Threshold, err = strconv.Atoi(updateWitnessThreshold); err != nil {
+			return p, fmt.Errorf("threshold: %v", err)
+		}
+	}
+	if updateWitnessSelf == "true" {
+		p.Self = func(body string) bool {
+			if err := selfWitnessed(ctx, f, body); err != nil {
+				klog.Infof("Not counting this device as a witness of firmware log checkpoint: %v", err)
+				return false
+			}
+			return true
+		}
+	}
+	return p, nil
+}
+
+// selfWitnessed returns an error describing why this device hasn't witnessed
+// the firmware log checkpoint with the given body, or nil if it has.
+//
+// The device has witnessed a checkpoint if it's consistent with the latest
+// one its witness has verified for the log, and no larger, since the witness
+// has then verified that the checkpoint's tree is contained in the one it
+// holds. This requires the log to be in the witness config.
+func selfWitnessed(ctx context.Context, f client.Fetcher, body string) error {
+	cp := logfmt.Checkpoint{}
+	if _, err := cp.Unmarshal([]byte(body)); err != nil {
+		return fmt.Errorf("invalid checkpoint: %v", err)
+	}
+	latest, err := persistence.Latest(ctx, updateLogOrigin)
+	if err != nil {
+		return fmt.Errorf("no witnessed checkpoint for %q, is it in the witness config? %v", updateLogOrigin, err)
+	}
+	latestBody, _, _ := strings.Cut(string(latest), "\n\n")
+	witnessed := logfmt.Checkpoint{}
+	if _, err := witnessed.Unmarshal([]byte(latestBody + "\n")); err != nil {
+		return fmt.Errorf("invalid witnessed checkpoint: %v", err)
+	}
+	if cp.Size > witnessed.Size {
+		return fmt.Errorf("checkpoint size %d is larger than witnessed size %d", cp.Size, witnessed.Size)
+	}
+	if err := client.CheckConsistency(ctx, rfc6962.DefaultHasher, f, []logfmt.Checkpoint{cp, witnessed}); err != nil {
+		return fmt.Errorf("checkpoint isn't consistent with witnessed checkpoint: %v", err)
+	}
+	return nil
 }
 
 type fwVerifier struct {
 	logOrigin            string
 	logVerifier          note.Verifier
+	witnessPolicy        witnessed.Policy
 	appletBundleVerifier firmware.BundleVerifier
 	osBundleVerifier     firmware.BundleVerifier
 }
 
-func newFWVerifier(logOrigin string, logVerifier note.Verifier, appletVerifier note.Verifier, osVerifiers []note.Verifier) fwVerifier {
+func newFWVerifier(logOrigin string, logVerifier note.Verifier, witnessPolicy witnessed.Policy, appletVerifier note.Verifier, osVerifiers []note.Verifier) fwVerifier {
 	return fwVerifier{
-		logOrigin:   logOrigin,
-		logVerifier: logVerifier,
+		logOrigin:     logOrigin,
+		logVerifier:   logVerifier,
+		witnessPolicy: witnessPolicy,
 		appletBundleVerifier: firmware.BundleVerifier{
 			LogOrigin:         logOrigin,
 			LogVerifer:        logVerifier,
@@ -139,6 +347,9 @@ func newFWVerifier(logOrigin string, logVerifier note.Verifier, appletVerifier n
 }
 
 func (fw fwVerifier) Verify(b firmware.Bundle) error {
+	if _, err := fw.witnessPolicy.Verify(b.Checkpoint); err != nil {
+		return fmt.Errorf("checkpoint doesn't meet witness policy: %v", err)
+	}
 	allVerifiers := append(append([]note.Verifier{}, fw.appletBundleVerifier.ManifestVerifiers...), fw.osBundleVerifier.ManifestVerifiers...)
 	m, err := note.Open(b.Manifest, note.VerifierList(allVerifiers...))
 	if err != nil {
@@ -161,17 +372,17 @@ func (fw fwVerifier) Verify(b firmware.Bundle) error {
 }
 
 // New creates a Fetcher for the log at the given root location.
-func newFetcher(root *url.URL, httpTimeout time.Duration, logProgress bool) client.Fetcher {
+func newFetcher(root *url.URL, httpTimeout time.Duration) client.Fetcher {
 	return func(ctx context.Context, p string) ([]byte, error) {
 		u, err := root.Parse(p)
 		if err != nil {
 			return nil, err
 		}
-		return readHTTP(ctx, u, httpTimeout, logProgress)
+		return readHTTP(ctx, u, httpTimeout)
 	}
 }
 
-func readHTTP(ctx context.Context, u *url.URL, timeout time.Duration, logProgress bool) ([]byte, error) {
+func readHTTP(ctx context.Context, u *url.URL, timeout time.Duration) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -202,19 +413,9 @@ func readHTTP(ctx context.Context, u *url.URL, timeout time.Duration, logProgres
 		}
 	}()
 
-	pr := progress.NewReader(resp.Body)
-	if logProgress && resp.ContentLength > 0 {
-		go func() {
-			progressChan := progress.NewTicker(ctx, pr, resp.ContentLength, 1*time.Second)
-			for p := range progressChan {
-				klog.Infof("Downloading %q: %d%%, %v remaining...", u.String(), int(p.Percent()), p.Remaining().Round(time.Second))
-			}
-		}()
-	}
-	b, err := io.ReadAll(pr)
-	if logProgress {
-		klog.Infof("Downloading %q: finished", u.String())
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", u.String(), err)
 	}
-
 	return b, nil
 }
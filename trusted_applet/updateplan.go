@@ -0,0 +1,219 @@
+// Copyright 2026 The Armored Witness Applet authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/transparency-dev/armored-witness-common/release/firmware"
+	"github.com/transparency-dev/armored-witness-common/release/firmware/ftlog"
+	logfmt "github.com/transparency-dev/formats/log"
+	"golang.org/x/mod/sumdb/note"
+	"k8s.io/klog/v2"
+)
+
+// updates lazily creates the firmwareUpdate shared by the update checker and
+// the update planner, and stops them from using it at the same time.
+type updates struct {
+	mu sync.Mutex
+	fu *firmwareUpdate
+}
+
+// do calls f with the firmwareUpdate, creating it first if necessary.
+func (u *updates) do(ctx context.Context, f func(*firmwareUpdate) error) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.fu == nil {
+		fu, err := updater(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create updater: %v", err)
+		}
+		u.fu = fu
+	}
+	return f(u.fu)
+}
+
+// planHandler serves the results of the most recent update dry run, and
+// starts a new one in the background when it receives a POST request.
+//
+// A dry run scans the firmware log and fetches and verifies any newer
+// releases in the same way as the update checker, but installs nothing.
+type planHandler struct {
+	ctx     context.Context
+	updates *updates
+
+	// mu guards the fields below.
+	mu   sync.Mutex
+	plan updatePlan
+}
+
+// updatePlan describes the outcome of an update dry run.
+type updatePlan struct {
+	Running    bool
+	Started    *time.Time `json:",omitempty"`
+	Finished   *time.Time `json:",omitempty"`
+	Error      string     `json:",omitempty"`
+	Components []componentPlan
+}
+
+// componentPlan describes what the update checker would do for a single
+// firmware component.
+type componentPlan struct {
+	Component string
+	Installed string
+	Latest    string
+	// Install is true if the update checker would install Latest now.
+	Install bool
+	// HeldBack explains why the rollout policy isn't yet allowing Latest to
+	// be installed.
+	HeldBack string `json:",omitempty"`
+	// Bundle describes the release of Latest, and is only set if Latest is
+	// newer than Installed.
+	Bundle *bundlePlan `json:",omitempty"`
+	Error  string      `json:",omitempty"`
+}
+
+// bundlePlan describes a firmware bundle fetched from the log.
+type bundlePlan struct {
+	ManifestSHA256 string
+	LogIndex       uint64
+	// CheckpointSize is the size of the log checkpoint which the bundle's
+	// inclusion proof is relative to.
+	CheckpointSize uint64
+	Verified       bool
+	VerifyError    string `json:",omitempty"`
+}
+
+func (p *planHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch req.Method {
+	case http.MethodGet:
+		res.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(p.plan); err != nil {
+			klog.Errorf("Failed to write update plan: %v", err)
+		}
+	case http.MethodPost:
+		res.Header().Add("Content-Type", "text/plain")
+		if p.plan.Running {
+			res.WriteHeader(http.StatusConflict)
+			res.Write([]byte("update dry run already in progress"))
+			return
+		}
+		now := time.Now()
+		p.plan = updatePlan{Running: true, Started: &now}
+		go p.run()
+		res.WriteHeader(http.StatusAccepted)
+		res.Write([]byte("ok, update dry run started"))
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *planHandler) run() {
+	klog.Info("Starting update dry run...")
+	var c []componentPlan
+	err := p.updates.do(p.ctx, func(fu *firmwareUpdate) error {
+		var err error
+		c, err = fu.plan(p.ctx)
+		return err
+	})
+	klog.Infof("Update dry run finished: %v", err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.plan.Running = false
+	now := time.Now()
+	p.plan.Finished = &now
+	if err != nil {
+		p.plan.Error = err.Error()
+	}
+	p.plan.Components = c
+}
+
+// plan scans the firmware log and describes what Update would do for each
+// updatable component, without installing anything.
+func (fu *firmwareUpdate) plan(ctx context.Context) ([]componentPlan, error) {
+	if err := fu.fetcher.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("UpdateFetcher.Scan: %v", err)
+	}
+	osVer, appVer, err := fu.local.GetInstalledVersions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine installed versions: %v", err)
+	}
+	latestOS, latestApp, err := fu.fetcher.GetLatestVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetLatestVersions: %v", err)
+	}
+	return []componentPlan{
+		fu.planComponent(ctx, ftlog.ComponentOS, osVer, latestOS, fu.cache.GetOS),
+		fu.planComponent(ctx, ftlog.ComponentApplet, appVer, latestApp, fu.cache.GetApplet),
+	}, nil
+}
+
+// planComponent describes what Update would do for one component, fetching
+// and verifying the latest release if it's newer than the installed one.
+// Bundles are fetched through the firmware cache, so one fetched by a dry run
+// needn't be downloaded again to install it.
+func (fu *firmwareUpdate) planComponent(ctx context.Context, component string, installed, latest semver.Version, fetch func(context.Context) (firmware.Bundle, error)) componentPlan {
+	c := componentPlan{
+		Component: component,
+		Installed: installed.String(),
+		Latest:    latest.String(),
+	}
+	if !installed.LessThan(latest) {
+		return c
+	}
+	// Peek rather than Check, so that planning doesn't start the clock on
+	// the release's minimum age.
+	if err := fu.remote.Peek(ctx, component, latest); err != nil {
+		c.HeldBack = err.Error()
+	} else {
+		c.Install = true
+	}
+
+	b, err := fetch(ctx)
+	if err != nil {
+		c.Error = fmt.Sprintf("failed to fetch bundle: %v", err)
+		return c
+	}
+	h := sha256.Sum256(b.Manifest)
+	c.Bundle = &bundlePlan{
+		ManifestSHA256: hex.EncodeToString(h[:]),
+		LogIndex:       b.Index,
+	}
+	// Any problem with the checkpoint is reported by Verify below.
+	if n, err := note.Open(b.Checkpoint, note.VerifierList(fu.verifier.logVerifier)); err == nil {
+		cp := logfmt.Checkpoint{}
+		if _, err := cp.Unmarshal([]byte(n.Text)); err == nil {
+			c.Bundle.CheckpointSize = cp.Size
+		}
+	}
+	if err := fu.verifier.Verify(b); err != nil {
+		c.Bundle.VerifyError = err.Error()
+	} else {
+		c.Bundle.Verified = true
+	}
+	return c
+}